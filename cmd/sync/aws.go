@@ -4,36 +4,72 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"net/url"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	yaml "gopkg.in/yaml.v3"
 )
 
 // AWSClient allows you to get the list of IP addresses of instances of an Auto Scaling group. It implements the CloudProvider interface.
 type AWSClient struct {
-	svcEC2         *ec2.Client
-	svcAutoscaling *autoscaling.Client
-	config         *awsConfig
+	// svcSQS is nil if sqs_queue_url isn't set.
+	svcSQS *sqs.Client
+	config *awsConfig
+	// lifecycleActions are shared with the AWSClient of the reloaded config.
+	lifecycleActions *lifecycleActions
+	// services maps the region and the role of the upstreams to the clients of the AWS APIs, created on first use.
+	services map[awsServicesKey]*awsServices
+	// selfRegion is the region of the current instance, empty until it is retrieved from the EC2 Metadata service.
+	selfRegion string
+	awsConfig  aws.Config
+	mu         sync.Mutex
+}
+
+// awsServices are the clients of the AWS APIs for a region and a role.
+type awsServices struct {
+	ec2         *ec2.Client
+	autoscaling *autoscaling.Client
+}
+
+// awsServicesKey is the region and the role assumed by the clients of the AWS APIs. The region can be self. The role
+// ARN is empty for the default credentials.
+type awsServicesKey struct {
+	region      string
+	roleARN     string
+	externalID  string
+	sessionName string
 }
 
 // NewAWSClient creates and configures an AWSClient.
-func NewAWSClient(data []byte) (*AWSClient, error) {
-	awsClient := &AWSClient{}
+func NewAWSClient(ctx context.Context, data []byte) (*AWSClient, error) {
+	awsClient := &AWSClient{
+		lifecycleActions: &lifecycleActions{actions: make(map[string]*lifecycleAction)},
+		services:         make(map[awsServicesKey]*awsServices),
+	}
 	cfg, err := parseAWSConfig(data)
 	if err != nil {
 		return nil, fmt.Errorf("error validating config: %w", err)
 	}
 	awsClient.config = cfg
 
-	err = awsClient.configure()
+	err = awsClient.configure(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error configuring AWS Client: %w", err)
 	}
@@ -46,15 +82,22 @@ func (client *AWSClient) GetUpstreams() []Upstream {
 	upstreams := make([]Upstream, 0, len(client.config.Upstreams))
 	for i := range len(client.config.Upstreams) {
 		u := Upstream{
-			Name:         client.config.Upstreams[i].Name,
-			Port:         client.config.Upstreams[i].Port,
-			Kind:         client.config.Upstreams[i].Kind,
-			ScalingGroup: client.config.Upstreams[i].AutoscalingGroup,
-			MaxConns:     &client.config.Upstreams[i].MaxConns,
-			MaxFails:     &client.config.Upstreams[i].MaxFails,
-			FailTimeout:  getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
-			SlowStart:    getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
-			InService:    client.config.Upstreams[i].InService,
+			Name:                     client.config.Upstreams[i].Name,
+			Port:                     client.config.Upstreams[i].Port,
+			Kind:                     client.config.Upstreams[i].Kind,
+			ScalingGroups:            client.config.Upstreams[i].getScalingGroups(client.config),
+			MaxConns:                 &client.config.Upstreams[i].MaxConns,
+			MaxFails:                 &client.config.Upstreams[i].MaxFails,
+			FailTimeout:              getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
+			SlowStart:                getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			DrainTimeout:             client.config.Upstreams[i].DrainTimeout,
+			StaleAfter:               client.config.Upstreams[i].StaleAfter,
+			StalePolicy:              client.config.Upstreams[i].StalePolicy,
+			MinServers:               client.config.Upstreams[i].MinServers,
+			MaxRemovalPercent:        client.config.Upstreams[i].MaxRemovalPercent,
+			ServerParametersFromTags: client.config.Upstreams[i].ServerParametersFromTags,
+			AddressFamily:            getAddressFamilyOrDefault(client.config.Upstreams[i].AddressFamily),
+			InService:                client.config.Upstreams[i].InService,
 		}
 		upstreams = append(upstreams, u)
 	}
@@ -62,45 +105,189 @@ func (client *AWSClient) GetUpstreams() []Upstream {
 }
 
 // configure configures the AWSClient with necessary parameters.
-func (client *AWSClient) configure() error {
+func (client *AWSClient) configure(ctx context.Context) error {
 	httpClient := http.NewBuildableClient().WithTimeout(connTimeoutInSecs * time.Second)
 
-	if client.config.Region == "self" {
-		conf, loadErr := config.LoadDefaultConfig(
-			context.TODO(),
-			config.WithSharedConfigProfile(client.config.Profile),
-			config.WithHTTPClient(httpClient),
-		)
-		if loadErr != nil {
-			return fmt.Errorf("unable to load default AWS config: %w", loadErr)
-		}
-
-		imdClient := imds.NewFromConfig(conf)
-
-		response, regionErr := imdClient.GetRegion(context.TODO(), &imds.GetRegionInput{})
-		if regionErr != nil {
-			return fmt.Errorf("unable to retrieve region from ec2metadata: %w", regionErr)
-		}
-		client.config.Region = response.Region
-	}
-
+	// the region of each client is set when it is created
 	cfg, err := config.LoadDefaultConfig(
-		context.TODO(),
+		ctx,
 		config.WithSharedConfigProfile(client.config.Profile),
-		config.WithRegion(client.config.Region),
 		config.WithHTTPClient(httpClient),
 	)
 	if err != nil {
 		return fmt.Errorf("unable to load default AWS config: %w", err)
 	}
 
-	client.svcEC2 = ec2.NewFromConfig(cfg)
+	client.awsConfig = cfg
 
-	client.svcAutoscaling = autoscaling.NewFromConfig(cfg)
+	if client.config.SQSQueueURL != "" {
+		client.mu.Lock()
+		region, regionErr := client.getRegion(ctx, client.config.Region)
+		client.mu.Unlock()
+		if regionErr != nil {
+			return regionErr
+		}
+
+		// the long polling of the queue takes longer than the timeout of the other calls
+		sqsHTTPClient := http.NewBuildableClient().WithTimeout((connTimeoutInSecs + sqsWaitTimeInSecs) * time.Second)
+		client.svcSQS = sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			o.Region = region
+			o.HTTPClient = sqsHTTPClient
+		})
+	}
 
 	return nil
 }
 
+// getRegion returns the region, or the region of the current instance if the region is self. The region of the
+// current instance is retrieved from the EC2 Metadata service the first time it is needed. The caller must hold mu.
+func (client *AWSClient) getRegion(ctx context.Context, region string) (string, error) {
+	if region != "self" {
+		return region, nil
+	}
+
+	if client.selfRegion == "" {
+		response, err := imds.NewFromConfig(client.awsConfig).GetRegion(ctx, &imds.GetRegionInput{})
+		if err != nil {
+			return "", fmt.Errorf("unable to retrieve region from ec2metadata: %w", err)
+		}
+		client.selfRegion = response.Region
+	}
+
+	return client.selfRegion, nil
+}
+
+// getServices returns the clients of the AWS APIs for the Auto Scaling group, in the region and with the role of its
+// upstreams. The upstreams with the same autoscaling_group take precedence over those whose autoscaling_group only
+// matches the name of the Auto Scaling group of a notification. The clients of a region and a role are created on
+// first use and assume the role when they make their first call.
+func (client *AWSClient) getServices(ctx context.Context, name string) (*awsServices, error) {
+	key := client.getServicesKey(name)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if services, ok := client.services[key]; ok {
+		return services, nil
+	}
+
+	region, err := client.getRegion(ctx, key.region)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := client.awsConfig.Copy()
+	cfg.Region = region
+	if key.roleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), key.roleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = key.sessionName
+			if key.externalID != "" {
+				o.ExternalID = aws.String(key.externalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	services := &awsServices{
+		ec2:         ec2.NewFromConfig(cfg),
+		autoscaling: autoscaling.NewFromConfig(cfg),
+	}
+	client.services[key] = services
+
+	return services, nil
+}
+
+// getServicesKey returns the key of the services of the Auto Scaling group, those of its region and its role.
+func (client *AWSClient) getServicesKey(name string) awsServicesKey {
+	group, ok := client.getAutoscalingGroup(name)
+	if !ok {
+		return getAWSServicesKey(client.config, awsUpstream{}, awsScalingGroupConfig{})
+	}
+
+	return group.key
+}
+
+// GetScalingGroupLocation returns the region and the role with which the Auto Scaling group is looked up.
+func (client *AWSClient) GetScalingGroupLocation(name string) string {
+	key := client.getServicesKey(name)
+	return key.region + "/" + key.roleARN
+}
+
+// getAutoscalingGroup returns the scaling group of the first upstream with the name, or else the Auto Scaling group of
+// the name with the region and the role of the first upstream whose autoscaling_group matches it.
+func (client *AWSClient) getAutoscalingGroup(name string) (awsAutoscalingGroup, bool) {
+	for _, u := range client.config.Upstreams {
+		for _, g := range u.getAutoscalingGroups(client.config) {
+			if g.Name == name {
+				return g, true
+			}
+		}
+	}
+	for _, u := range client.config.Upstreams {
+		for _, g := range u.getAutoscalingGroups(client.config) {
+			if matchesAutoscalingGroup(g.Name, name) {
+				return awsAutoscalingGroup{key: g.key, name: name, ScalingGroup: ScalingGroup{Name: name}}, true
+			}
+		}
+	}
+
+	return awsAutoscalingGroup{}, false
+}
+
+// getAutoscalingGroupName returns the name of the Auto Scaling group of the scaling group, without its region.
+func (client *AWSClient) getAutoscalingGroupName(name string) string {
+	if group, ok := client.getAutoscalingGroup(name); ok {
+		return group.name
+	}
+
+	return name
+}
+
+// getAWSServicesKey returns the region and the role of the Auto Scaling group of the upstream, which default to the
+// region and the role of the upstream, then of the config. The role of the Auto Scaling group is assumed with the
+// external ID and the session name of the upstream or the config.
+func getAWSServicesKey(cfg *awsConfig, u awsUpstream, g awsScalingGroupConfig) awsServicesKey {
+	key := awsServicesKey{roleARN: cfg.RoleARN, externalID: cfg.ExternalID, sessionName: cfg.RoleSessionName}
+	if u.RoleARN != "" {
+		key = awsServicesKey{roleARN: u.RoleARN, externalID: u.ExternalID, sessionName: u.RoleSessionName}
+	}
+	if g.RoleARN != "" {
+		key.roleARN = g.RoleARN
+	}
+	if key.roleARN == "" {
+		key = awsServicesKey{}
+	} else if key.sessionName == "" {
+		key.sessionName = defaultRoleSessionName
+	}
+
+	key.region = cfg.Region
+	if u.Region != "" {
+		key.region = u.Region
+	}
+	if g.Region != "" {
+		key.region = g.Region
+	}
+
+	return key
+}
+
+// getIPv6FromNetworkInterface returns the primary IPv6 address of the network interface, or its first IPv6 address if
+// none is primary, or an empty string.
+func getIPv6FromNetworkInterface(iFace types.InstanceNetworkInterface) string {
+	for _, address := range iFace.Ipv6Addresses {
+		if aws.ToBool(address.IsPrimaryIpv6) && address.Ipv6Address != nil {
+			return *address.Ipv6Address
+		}
+	}
+	for _, address := range iFace.Ipv6Addresses {
+		if address.Ipv6Address != nil {
+			return *address.Ipv6Address
+		}
+	}
+
+	return ""
+}
+
 // parseAWSConfig parses and validates AWSClient config.
 func parseAWSConfig(data []byte) (*awsConfig, error) {
 	cfg := &awsConfig{}
@@ -117,20 +304,54 @@ func parseAWSConfig(data []byte) (*awsConfig, error) {
 	return cfg, nil
 }
 
-// CheckIfScalingGroupExists checks if the Auto Scaling group exists.
-func (client *AWSClient) CheckIfScalingGroupExists(name string) (bool, error) {
-	params := &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
+// getInstanceTags returns the instance_tags of the upstreams of the scaling group, or nil if the scaling group is an
+// Auto Scaling group.
+func (client *AWSClient) getInstanceTags(name string) map[string]string {
+	group, _ := client.getAutoscalingGroup(name)
+	return group.instanceTags
+}
+
+// getScalingGroupFilters returns the filters that select the instances of the scaling group: the instance tags of its
+// upstreams if they select the instances by tags, the name of the Auto Scaling group otherwise.
+func (client *AWSClient) getScalingGroupFilters(name string) []types.Filter {
+	tags := client.getInstanceTags(name)
+	if tags == nil {
+		return []types.Filter{
 			{
 				Name: aws.String("tag:aws:autoscaling:groupName"),
 				Values: []string{
-					name,
+					client.getAutoscalingGroupName(name),
 				},
 			},
-		},
+		}
 	}
 
-	response, err := client.svcEC2.DescribeInstances(context.Background(), params)
+	filters := make([]types.Filter, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + k), Values: []string{tags[k]}})
+	}
+
+	return filters
+}
+
+// CheckIfScalingGroupExists checks if the Auto Scaling group exists, or if any instance has the tags of the scaling
+// group of the instances selected by tags.
+func (client *AWSClient) CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error) {
+	services, err := client.getServices(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	if client.getInstanceTags(name) == nil {
+		// the group exists even if it is scaled to 0 or all its instances are stopped
+		return autoscalingGroupExists(ctx, services, client.getAutoscalingGroupName(name))
+	}
+
+	params := &ec2.DescribeInstancesInput{
+		Filters: client.getScalingGroupFilters(name),
+	}
+
+	response, err := services.ec2.DescribeInstances(ctx, params)
 	if err != nil {
 		return false, fmt.Errorf("couldn't check if an AutoScaling group exists: %w", err)
 	}
@@ -138,64 +359,145 @@ func (client *AWSClient) CheckIfScalingGroupExists(name string) (bool, error) {
 	return len(response.Reservations) > 0, nil
 }
 
-// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Auto Scaling group.
-func (client *AWSClient) GetPrivateIPsForScalingGroup(name string) ([]string, error) {
+// autoscalingGroupExists checks if an Auto Scaling group has the name, which can have the wildcards of the EC2 filters.
+// All the Auto Scaling groups are listed to match a name with wildcards.
+func autoscalingGroupExists(ctx context.Context, services *awsServices, name string) (bool, error) {
+	params := &autoscaling.DescribeAutoScalingGroupsInput{}
+	if !strings.ContainsAny(name, "*?") {
+		params.AutoScalingGroupNames = []string{name}
+	}
+
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(services.autoscaling, params)
+	for paginator.HasMorePages() {
+		response, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("couldn't check if an AutoScaling group exists: %w", err)
+		}
+		if slices.ContainsFunc(response.AutoScalingGroups, func(g autoscalingtypes.AutoScalingGroup) bool {
+			return matchesAutoscalingGroup(name, aws.ToString(g.AutoScalingGroupName))
+		}) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Auto Scaling group, or of the instances
+// selected by tags, with their tags.
+func (client *AWSClient) GetPrivateIPsForScalingGroup(ctx context.Context, name string) ([]Instance, error) {
 	var onlyInService bool
 	for _, u := range client.GetUpstreams() {
-		if u.ScalingGroup == name && u.InService {
+		if u.hasScalingGroup(name) && u.InService {
 			onlyInService = true
 			break
 		}
 	}
 	params := &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
-			{
-				Name: aws.String("tag:aws:autoscaling:groupName"),
-				Values: []string{
-					name,
-				},
-			},
-		},
+		Filters: append(client.getScalingGroupFilters(name), types.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: client.getInstanceStates(name),
+		}),
 	}
 
-	response, err := client.svcEC2.DescribeInstances(context.Background(), params)
+	var reservations []types.Reservation
+	services, err := client.getServices(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't describe instances: %w", err)
+		return nil, err
+	}
+	paginator := ec2.NewDescribeInstancesPaginator(services.ec2, params)
+	for paginator.HasMorePages() {
+		response, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't describe instances: %w", err)
+		}
+		reservations = append(reservations, response.Reservations...)
 	}
 
-	if len(response.Reservations) == 0 {
-		return nil, fmt.Errorf("autoscaling group %v doesn't exist", name)
+	if len(reservations) == 0 && client.getInstanceTags(name) == nil {
+		// an Auto Scaling group without instances in the instance_states, like instance_tags that match no instance,
+		// empties the upstream, min_servers guards against it
+		exists, err := client.CheckIfScalingGroupExists(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("autoscaling group %v doesn't exist", name)
+		}
+
+		return nil, nil
 	}
 
-	var result []string
-	insIDtoIP := make(map[string]string)
+	var result []Instance
+	insIDtoInstance := make(map[string]Instance)
 
-	for _, res := range response.Reservations {
+	for _, res := range reservations {
 		for _, ins := range res.Instances {
-			if len(ins.NetworkInterfaces) > 0 && ins.NetworkInterfaces[0].PrivateIpAddress != nil {
+			if len(ins.NetworkInterfaces) > 0 && (ins.NetworkInterfaces[0].PrivateIpAddress != nil || len(ins.NetworkInterfaces[0].Ipv6Addresses) > 0) {
+				instance := newInstance(aws.ToString(ins.NetworkInterfaces[0].PrivateIpAddress), getTagsMap(ins.Tags))
+				instance.IPv6 = getIPv6FromNetworkInterface(ins.NetworkInterfaces[0])
+				// the instances of the lifecycle actions are added or removed before they leave the wait state
+				switch client.getLifecycleTransition(aws.ToString(ins.InstanceId), instance) {
+				case terminatingTransition:
+					continue
+				case launchingTransition:
+					result = append(result, instance)
+					continue
+				}
 				if onlyInService {
-					insIDtoIP[*ins.InstanceId] = *ins.NetworkInterfaces[0].PrivateIpAddress
+					insIDtoInstance[*ins.InstanceId] = instance
 				} else {
-					result = append(result, *ins.NetworkInterfaces[0].PrivateIpAddress)
+					result = append(result, instance)
 				}
 			}
 		}
 	}
 	if onlyInService {
-		result, err = client.getInstancesInService(insIDtoIP)
+		inService, err := client.getInstancesInService(ctx, services, insIDtoInstance)
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, inService...)
 	}
 
 	return result, nil
 }
 
+// getInstanceStates returns the instance states of the upstreams of the Auto Scaling group.
+// If several upstreams use the group, the instances in any of their states are returned.
+func (client *AWSClient) getInstanceStates(name string) []string {
+	var states []string
+	for _, u := range client.config.Upstreams {
+		if !slices.ContainsFunc(u.getScalingGroups(client.config), func(g ScalingGroup) bool { return g.Name == name }) {
+			continue
+		}
+		for _, state := range getInstanceStatesOrDefault(u.InstanceStates) {
+			if !slices.Contains(states, state) {
+				states = append(states, state)
+			}
+		}
+	}
+
+	if len(states) == 0 {
+		return getInstanceStatesOrDefault(nil)
+	}
+
+	return states
+}
+
+func getInstanceStatesOrDefault(states []string) []string {
+	if len(states) == 0 {
+		return []string{string(types.InstanceStateNameRunning)}
+	}
+
+	return states
+}
+
 // getInstancesInService returns the list of instances that have LifecycleState == InService.
-func (client *AWSClient) getInstancesInService(insIDtoIP map[string]string) ([]string, error) {
+func (client *AWSClient) getInstancesInService(ctx context.Context, services *awsServices, insIDtoInstance map[string]Instance) ([]Instance, error) {
 	const maxItems = 50
-	var result []string
-	keys := reflect.ValueOf(insIDtoIP).MapKeys()
+	var result []Instance
+	keys := reflect.ValueOf(insIDtoInstance).MapKeys()
 	instanceIDs := make([]string, len(keys))
 
 	for i := range keys {
@@ -207,14 +509,14 @@ func (client *AWSClient) getInstancesInService(insIDtoIP map[string]string) ([]s
 		params := &autoscaling.DescribeAutoScalingInstancesInput{
 			InstanceIds: batch,
 		}
-		response, err := client.svcAutoscaling.DescribeAutoScalingInstances(context.Background(), params)
+		response, err := services.autoscaling.DescribeAutoScalingInstances(ctx, params)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't describe AutoScaling instances: %w", err)
 		}
 
 		for _, ins := range response.AutoScalingInstances {
 			if *ins.LifecycleState == "InService" {
-				result = append(result, insIDtoIP[*ins.InstanceId])
+				result = append(result, insIDtoInstance[*ins.InstanceId])
 			}
 		}
 	}
@@ -222,6 +524,17 @@ func (client *AWSClient) getInstancesInService(insIDtoIP map[string]string) ([]s
 	return result, nil
 }
 
+func getTagsMap(tags []types.Tag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if tag.Key != nil && tag.Value != nil {
+			result[*tag.Key] = *tag.Value
+		}
+	}
+
+	return result
+}
+
 func prepareBatches(maxItems int, items []string) [][]string {
 	totalBatches := (len(items) + maxItems - 1) / maxItems
 	batches := make([][]string, 0, totalBatches)
@@ -239,58 +552,243 @@ func prepareBatches(maxItems int, items []string) [][]string {
 
 // Configuration for AWS Cloud Provider.
 type awsConfig struct {
-	Region    string        `yaml:"region"`
-	Profile   string        `yaml:"profile"`
-	Upstreams []awsUpstream `yaml:"upstreams"`
+	Region  string `yaml:"region"`
+	Profile string `yaml:"profile"`
+	// SQSQueueURL is the URL of the SQS queue that receives the Auto Scaling notifications.
+	SQSQueueURL string `yaml:"sqs_queue_url"`
+	// RoleARN is the role assumed to call the AWS APIs, unless an upstream sets its own. By default, no role is assumed.
+	RoleARN    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id"`
+	// RoleSessionName is the session name of the assumed role, nginx-asg-sync by default.
+	RoleSessionName string `yaml:"role_session_name"`
+	// LifecycleHooks are the names of the lifecycle hooks whose lifecycle actions are completed once the instances are
+	// added to or removed from the upstreams.
+	LifecycleHooks []string      `yaml:"lifecycle_hooks"`
+	Upstreams      []awsUpstream `yaml:"upstreams"`
 }
 
 type awsUpstream struct {
-	Name             string `yaml:"name"`
-	AutoscalingGroup string `yaml:"autoscaling_group"`
-	Kind             string `yaml:"kind"`
-	FailTimeout      string `yaml:"fail_timeout"`
-	SlowStart        string `yaml:"slow_start"`
-	Port             int    `yaml:"port"`
-	MaxConns         int    `yaml:"max_conns"`
-	MaxFails         int    `yaml:"max_fails"`
-	InService        bool   `yaml:"in_service"`
+	// InstanceTags select the instances of the upstream by their tags, instead of AutoscalingGroup.
+	InstanceTags map[string]string `yaml:"instance_tags"`
+	// StaleAfter and StalePolicy override the top-level ones for the upstream.
+	StaleAfter       *time.Duration `yaml:"stale_after"`
+	Name             string         `yaml:"name"`
+	AutoscalingGroup string         `yaml:"autoscaling_group"`
+	Kind             string         `yaml:"kind"`
+	FailTimeout      string         `yaml:"fail_timeout"`
+	SlowStart        string         `yaml:"slow_start"`
+	AddressFamily    string         `yaml:"address_family"`
+	StalePolicy      string         `yaml:"stale_policy"`
+	Region           string         `yaml:"region"`
+	RoleARN          string         `yaml:"role_arn"`
+	ExternalID       string         `yaml:"external_id"`
+	RoleSessionName  string         `yaml:"role_session_name"`
+	InstanceStates   []string       `yaml:"instance_states"`
+	// AutoscalingGroups are the Auto Scaling groups of the upstream, instead of AutoscalingGroup.
+	AutoscalingGroups        []awsScalingGroupConfig `yaml:"autoscaling_groups"`
+	Port                     int                     `yaml:"port"`
+	MaxConns                 int                     `yaml:"max_conns"`
+	MaxFails                 int                     `yaml:"max_fails"`
+	MinServers               int                     `yaml:"min_servers"`
+	MaxRemovalPercent        int                     `yaml:"max_removal_percent"`
+	DrainTimeout             time.Duration           `yaml:"drain_timeout"`
+	InService                bool                    `yaml:"in_service"`
+	ServerParametersFromTags bool                    `yaml:"server_parameters_from_tags"`
+}
+
+// awsScalingGroupConfig is an Auto Scaling group of the autoscaling_groups of an upstream, which can be in another
+// region or account than the upstream.
+type awsScalingGroupConfig struct {
+	// Region and RoleARN override the region and the role_arn of the upstream for the Auto Scaling group.
+	Region             string `yaml:"region"`
+	RoleARN            string `yaml:"role_arn"`
+	scalingGroupConfig `yaml:",inline"`
+}
+
+// awsAutoscalingGroup is a scaling group of an upstream with the Auto Scaling group, or the instance tags, that it
+// selects and the region and the role with which it is looked up.
+type awsAutoscalingGroup struct {
+	// instanceTags are the instance_tags of the upstream, nil if the scaling group is an Auto Scaling group.
+	instanceTags map[string]string
+	// name is the name of the Auto Scaling group, without the region of the name of the scaling group.
+	name string
+	key  awsServicesKey
+	ScalingGroup
+}
+
+// getScalingGroups returns the Auto Scaling groups of the upstream.
+func (u awsUpstream) getScalingGroups(cfg *awsConfig) []ScalingGroup {
+	groups := u.getAutoscalingGroups(cfg)
+	result := make([]ScalingGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g.ScalingGroup)
+	}
+
+	return result
+}
+
+// getAutoscalingGroups returns the Auto Scaling groups of the upstream, or its instances selected by tags, with the region
+// and the role with which they are looked up.
+func (u awsUpstream) getAutoscalingGroups(cfg *awsConfig) []awsAutoscalingGroup {
+	var groups []awsScalingGroupConfig
+	switch {
+	case len(u.InstanceTags) > 0:
+		groups = []awsScalingGroupConfig{{scalingGroupConfig: scalingGroupConfig{Name: getInstanceTagsScalingGroup(u.InstanceTags)}}}
+	case u.AutoscalingGroup != "":
+		groups = []awsScalingGroupConfig{{scalingGroupConfig: scalingGroupConfig{Name: u.AutoscalingGroup}}}
+	default:
+		groups = u.AutoscalingGroups
+	}
+
+	result := make([]awsAutoscalingGroup, 0, len(groups))
+	for _, g := range groups {
+		key := getAWSServicesKey(cfg, u, g)
+		group := awsAutoscalingGroup{
+			name:         g.Name,
+			key:          key,
+			ScalingGroup: ScalingGroup{Name: getAWSScalingGroupName(cfg, key, g.Name)},
+		}
+		if g.Weight > 0 {
+			group.Weight = &g.Weight
+		}
+		if len(u.InstanceTags) > 0 {
+			group.instanceTags = u.InstanceTags
+		}
+		result = append(result, group)
+	}
+
+	return result
+}
+
+// getScalingGroupConfigs returns the autoscaling_groups of the upstream with the names of their scaling groups.
+func (u awsUpstream) getScalingGroupConfigs(cfg *awsConfig) []scalingGroupConfig {
+	configs := make([]scalingGroupConfig, 0, len(u.AutoscalingGroups))
+	for _, g := range u.AutoscalingGroups {
+		groupCfg := g.scalingGroupConfig
+		groupCfg.Name = getAWSScalingGroupName(cfg, getAWSServicesKey(cfg, u, g), g.Name)
+		configs = append(configs, groupCfg)
+	}
+
+	return configs
+}
+
+// getAWSScalingGroupName returns the name of the scaling group of an Auto Scaling group: its name, preceded by its region
+// if it isn't the region of the config, so that the Auto Scaling groups with the same name in several regions are
+// looked up and cached separately.
+func getAWSScalingGroupName(cfg *awsConfig, key awsServicesKey, name string) string {
+	if key.region == cfg.Region || name == "" {
+		return name
+	}
+
+	return key.region + "/" + name
 }
 
 func validateAWSConfig(cfg *awsConfig) error {
+	var errs []error
+
 	if cfg.Region == "" {
-		return fmt.Errorf(errorMsgFormat, "region")
+		errs = append(errs, fmt.Errorf(errorMsgFormat, "region"))
+	}
+
+	if cfg.SQSQueueURL != "" {
+		if u, err := url.Parse(cfg.SQSQueueURL); err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf(sqsQueueURLErrorMsgFmt, cfg.SQSQueueURL))
+		}
+	}
+
+	if len(cfg.LifecycleHooks) > 0 && cfg.SQSQueueURL == "" {
+		errs = append(errs, errors.New(lifecycleHooksQueueErrorMsg))
+	}
+	for i, hook := range cfg.LifecycleHooks {
+		if hook == "" || slices.Contains(cfg.LifecycleHooks[:i], hook) {
+			errs = append(errs, fmt.Errorf(lifecycleHookErrorMsgFmt, hook))
+		}
 	}
 
 	if len(cfg.Upstreams) == 0 {
-		return errors.New("there are no upstreams found in the config file")
+		errs = append(errs, errors.New("there are no upstreams found in the config file"))
+	}
+
+	if cfg.RoleARN != "" && !arn.IsARN(cfg.RoleARN) {
+		errs = append(errs, fmt.Errorf(roleARNErrorMsgFmt, cfg.RoleARN))
 	}
 
-	for _, ups := range cfg.Upstreams {
+	for i, ups := range cfg.Upstreams {
 		if ups.Name == "" {
-			return errors.New(upstreamNameErrorMsg)
+			errs = append(errs, errors.New(upstreamNameErrorMsg))
+		}
+		if slices.ContainsFunc(cfg.Upstreams[:i], func(u awsUpstream) bool { return u.Name == ups.Name }) {
+			errs = append(errs, fmt.Errorf(upstreamDuplicateNameErrorMsgFmt, ups.Name))
+		}
+		errs = append(errs, validateScalingGroups(ups.Name, "autoscaling_group", "autoscaling_groups", ups.AutoscalingGroup, ups.getScalingGroupConfigs(cfg), ups.InstanceTags)...)
+		for _, g := range ups.AutoscalingGroups {
+			if g.RoleARN != "" && !arn.IsARN(g.RoleARN) {
+				errs = append(errs, fmt.Errorf(upstreamScalingGroupRoleARNErrorMsgFmt, g.RoleARN, g.Name, ups.Name))
+			}
+		}
+		// the instances selected by tags might not be in an Auto Scaling group
+		if ups.InService && len(ups.InstanceTags) > 0 {
+			errs = append(errs, fmt.Errorf(upstreamInServiceInstanceTagsErrorMsgFmt, ups.Name))
+		}
+		if ups.RoleARN != "" && !arn.IsARN(ups.RoleARN) {
+			errs = append(errs, fmt.Errorf(upstreamRoleARNErrorMsgFmt, ups.RoleARN, ups.Name))
 		}
-		if ups.AutoscalingGroup == "" {
-			return fmt.Errorf(upstreamErrorMsgFormat, "autoscaling_group", ups.Name)
+		// the Auto Scaling groups of a region are looked up by name, with the role of the first upstream, and the
+		// notifications of an Auto Scaling group may be matched by the autoscaling_group of another upstream
+		for _, group := range ups.getAutoscalingGroups(cfg) {
+			if slices.ContainsFunc(cfg.Upstreams[:i], func(u awsUpstream) bool {
+				return slices.ContainsFunc(u.getAutoscalingGroups(cfg), func(g awsAutoscalingGroup) bool {
+					return (matchesAutoscalingGroup(g.Name, group.Name) || matchesAutoscalingGroup(group.Name, g.Name)) && g.key != group.key
+				})
+			}) {
+				errs = append(errs, fmt.Errorf(upstreamScalingGroupConflictErrorMsgFmt, ups.Name, group.Name))
+			}
 		}
 		if ups.Port == 0 {
-			return fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name)
+			errs = append(errs, fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name))
 		}
 		if ups.Kind == "" || !(ups.Kind == "http" || ups.Kind == "stream") {
-			return fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name)
+			errs = append(errs, fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name))
 		}
 		if ups.MaxConns < 0 {
-			return fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns)
+			errs = append(errs, fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns))
 		}
 		if ups.MaxFails < 0 {
-			return fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails)
+			errs = append(errs, fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails))
 		}
 		if !isValidTime(ups.FailTimeout) {
-			return fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout)
+			errs = append(errs, fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout))
 		}
 		if !isValidTime(ups.SlowStart) {
-			return fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart)
+			errs = append(errs, fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart))
+		}
+		if !isValidAddressFamily(ups.AddressFamily) {
+			errs = append(errs, fmt.Errorf(upstreamAddressFamilyErrorMsgFmt, ups.AddressFamily, ups.Name))
+		}
+		for _, state := range ups.InstanceStates {
+			if !slices.Contains(types.InstanceStateNameRunning.Values(), types.InstanceStateName(state)) {
+				errs = append(errs, fmt.Errorf(upstreamInstanceStatesErrorMsgFmt, state, ups.Name))
+			}
+		}
+		if ups.MinServers < 0 {
+			errs = append(errs, fmt.Errorf(upstreamMinServersErrorMsgFmt, ups.MinServers))
+		}
+		if ups.MaxRemovalPercent < 0 || ups.MaxRemovalPercent > 100 {
+			errs = append(errs, fmt.Errorf(upstreamMaxRemovalPercentErrorMsgFmt, ups.MaxRemovalPercent))
+		}
+		if ups.DrainTimeout < 0 {
+			errs = append(errs, fmt.Errorf(upstreamDrainTimeoutErrorMsgFmt, ups.DrainTimeout))
+		}
+		if ups.DrainTimeout > 0 && ups.Kind == "stream" {
+			errs = append(errs, fmt.Errorf(upstreamDrainTimeoutKindErrorMsgFmt, ups.Name))
+		}
+		if ups.StaleAfter != nil && *ups.StaleAfter < 0 {
+			errs = append(errs, fmt.Errorf(upstreamStaleAfterErrorMsgFmt, *ups.StaleAfter, ups.Name))
+		}
+		if ups.StalePolicy != "" && ups.StalePolicy != stalePolicyKeep && ups.StalePolicy != stalePolicyEmpty {
+			errs = append(errs, fmt.Errorf(upstreamStalePolicyErrorMsgFmt, ups.StalePolicy, ups.Name))
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
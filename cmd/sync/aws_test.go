@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"reflect"
+	"slices"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type testInputAWS struct {
@@ -39,6 +45,51 @@ func getInvalidAWSConfigInput() []*testInputAWS {
 	invalidMissingUpstreamsCfg.Upstreams = nil
 	input = append(input, &testInputAWS{invalidMissingUpstreamsCfg, "no upstreams"})
 
+	invalidSQSQueueURLCfg := getValidAWSConfig()
+	invalidSQSQueueURLCfg.SQSQueueURL = "nginx-asg-sync"
+	input = append(input, &testInputAWS{invalidSQSQueueURLCfg, "invalid sqs_queue_url"})
+
+	invalidLifecycleHooksCfg := getValidAWSConfig()
+	invalidLifecycleHooksCfg.LifecycleHooks = []string{"nginx-asg-sync"}
+	input = append(input, &testInputAWS{invalidLifecycleHooksCfg, "lifecycle_hooks without sqs_queue_url"})
+
+	duplicateLifecycleHooksCfg := getValidAWSConfig()
+	duplicateLifecycleHooksCfg.SQSQueueURL = "https://sqs.us-west-2.amazonaws.com/123456789012/nginx-asg-sync"
+	duplicateLifecycleHooksCfg.LifecycleHooks = []string{"nginx-asg-sync", "nginx-asg-sync"}
+	input = append(input, &testInputAWS{duplicateLifecycleHooksCfg, "duplicate lifecycle_hooks"})
+
+	invalidRoleARNCfg := getValidAWSConfig()
+	invalidRoleARNCfg.RoleARN = "nginx-asg-sync"
+	input = append(input, &testInputAWS{invalidRoleARNCfg, "invalid role_arn"})
+
+	invalidUpstreamRoleARNCfg := getValidAWSConfig()
+	invalidUpstreamRoleARNCfg.Upstreams[0].RoleARN = "nginx-asg-sync"
+	input = append(input, &testInputAWS{invalidUpstreamRoleARNCfg, "invalid role_arn of the upstream"})
+
+	conflictingScalingGroupRoleCfg := getValidAWSConfig()
+	conflictingScalingGroupRoleCfg.Upstreams = append(conflictingScalingGroupRoleCfg.Upstreams, awsUpstream{
+		Name: "backend2",
+		AutoscalingGroups: []awsScalingGroupConfig{
+			{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}, RoleARN: "arn:aws:iam::123456789012:role/nginx-asg-sync"},
+		},
+		Port: 80,
+		Kind: "http",
+	})
+	input = append(input, &testInputAWS{conflictingScalingGroupRoleCfg, "different role_arn of the autoscaling_groups for the same autoscaling_group"})
+
+	conflictingUpstreamRoleCfg := getValidAWSConfig()
+	conflictingUpstreamRoleCfg.Upstreams = append(conflictingUpstreamRoleCfg.Upstreams, conflictingUpstreamRoleCfg.Upstreams[0])
+	conflictingUpstreamRoleCfg.Upstreams[1].Name = "backend2"
+	conflictingUpstreamRoleCfg.Upstreams[1].RoleARN = "arn:aws:iam::123456789012:role/nginx-asg-sync"
+	input = append(input, &testInputAWS{conflictingUpstreamRoleCfg, "different role_arn for the same autoscaling_group"})
+
+	conflictingUpstreamWildcardCfg := getValidAWSConfig()
+	conflictingUpstreamWildcardCfg.Upstreams = append(conflictingUpstreamWildcardCfg.Upstreams, conflictingUpstreamWildcardCfg.Upstreams[0])
+	conflictingUpstreamWildcardCfg.Upstreams[0].AutoscalingGroup = "backend-*"
+	conflictingUpstreamWildcardCfg.Upstreams[1].Name = "backend2"
+	conflictingUpstreamWildcardCfg.Upstreams[1].RoleARN = "arn:aws:iam::123456789012:role/nginx-asg-sync"
+	input = append(input, &testInputAWS{conflictingUpstreamWildcardCfg, "different role_arn for an autoscaling_group matching a wildcard"})
+
 	invalidUpstreamNameCfg := getValidAWSConfig()
 	invalidUpstreamNameCfg.Upstreams[0].Name = ""
 	input = append(input, &testInputAWS{invalidUpstreamNameCfg, "invalid name of the upstream"})
@@ -47,6 +98,50 @@ func getInvalidAWSConfigInput() []*testInputAWS {
 	invalidUpstreamAutoscalingGroupCfg.Upstreams[0].AutoscalingGroup = ""
 	input = append(input, &testInputAWS{invalidUpstreamAutoscalingGroupCfg, "invalid autoscaling_group of the upstream"})
 
+	bothUpstreamAutoscalingGroupsCfg := getValidAWSConfig()
+	bothUpstreamAutoscalingGroupsCfg.Upstreams[0].AutoscalingGroups = []awsScalingGroupConfig{{scalingGroupConfig: scalingGroupConfig{Name: "backend-group-green"}}}
+	input = append(input, &testInputAWS{bothUpstreamAutoscalingGroupsCfg, "both autoscaling_group and autoscaling_groups of the upstream"})
+
+	invalidUpstreamAutoscalingGroupsCfg := getValidAWSConfig()
+	invalidUpstreamAutoscalingGroupsCfg.Upstreams[0].AutoscalingGroup = ""
+	invalidUpstreamAutoscalingGroupsCfg.Upstreams[0].AutoscalingGroups = []awsScalingGroupConfig{
+		{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}},
+		{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}, RoleARN: "arn:aws:iam::123456789012:role/nginx-asg-sync"},
+	}
+	input = append(input, &testInputAWS{invalidUpstreamAutoscalingGroupsCfg, "duplicate autoscaling_groups of the upstream"})
+
+	invalidUpstreamAutoscalingGroupRoleARNCfg := getValidAWSConfig()
+	invalidUpstreamAutoscalingGroupRoleARNCfg.Upstreams[0].AutoscalingGroup = ""
+	invalidUpstreamAutoscalingGroupRoleARNCfg.Upstreams[0].AutoscalingGroups = []awsScalingGroupConfig{
+		{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}, RoleARN: "nginx-asg-sync"},
+	}
+	input = append(input, &testInputAWS{invalidUpstreamAutoscalingGroupRoleARNCfg, "invalid role_arn of the autoscaling_groups of the upstream"})
+
+	invalidUpstreamAutoscalingGroupWeightCfg := getValidAWSConfig()
+	invalidUpstreamAutoscalingGroupWeightCfg.Upstreams[0].AutoscalingGroup = ""
+	invalidUpstreamAutoscalingGroupWeightCfg.Upstreams[0].AutoscalingGroups = []awsScalingGroupConfig{{scalingGroupConfig: scalingGroupConfig{Name: "backend-group", Weight: -1}}}
+	input = append(input, &testInputAWS{invalidUpstreamAutoscalingGroupWeightCfg, "invalid weight of the autoscaling_groups of the upstream"})
+
+	duplicateUpstreamNameCfg := getValidAWSConfig()
+	duplicateUpstreamNameCfg.Upstreams = append(duplicateUpstreamNameCfg.Upstreams, duplicateUpstreamNameCfg.Upstreams[0])
+	duplicateUpstreamNameCfg.Upstreams[1].AutoscalingGroup = "backend-group-green"
+	input = append(input, &testInputAWS{duplicateUpstreamNameCfg, "duplicate name of the upstream"})
+
+	invalidUpstreamInstanceTagsCfg := getValidAWSConfig()
+	invalidUpstreamInstanceTagsCfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	input = append(input, &testInputAWS{invalidUpstreamInstanceTagsCfg, "both autoscaling_group and instance_tags of the upstream"})
+
+	invalidUpstreamInstanceTagsKeyCfg := getValidAWSConfig()
+	invalidUpstreamInstanceTagsKeyCfg.Upstreams[0].AutoscalingGroup = ""
+	invalidUpstreamInstanceTagsKeyCfg.Upstreams[0].InstanceTags = map[string]string{"": "backend"}
+	input = append(input, &testInputAWS{invalidUpstreamInstanceTagsKeyCfg, "empty key of the instance_tags of the upstream"})
+
+	invalidUpstreamInstanceTagsInServiceCfg := getValidAWSConfig()
+	invalidUpstreamInstanceTagsInServiceCfg.Upstreams[0].AutoscalingGroup = ""
+	invalidUpstreamInstanceTagsInServiceCfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	invalidUpstreamInstanceTagsInServiceCfg.Upstreams[0].InService = true
+	input = append(input, &testInputAWS{invalidUpstreamInstanceTagsInServiceCfg, "in_service with instance_tags of the upstream"})
+
 	invalidUpstreamPortCfg := getValidAWSConfig()
 	invalidUpstreamPortCfg.Upstreams[0].Port = 0
 	input = append(input, &testInputAWS{invalidUpstreamPortCfg, "invalid port of the upstream"})
@@ -71,6 +166,40 @@ func getInvalidAWSConfigInput() []*testInputAWS {
 	invalidUpstreamSlowStartCfg.Upstreams[0].SlowStart = "-10s"
 	input = append(input, &testInputAWS{invalidUpstreamSlowStartCfg, "invalid slow_start of the upstream"})
 
+	invalidUpstreamStaleAfter := -time.Minute
+	invalidUpstreamStaleAfterCfg := getValidAWSConfig()
+	invalidUpstreamStaleAfterCfg.Upstreams[0].StaleAfter = &invalidUpstreamStaleAfter
+	input = append(input, &testInputAWS{invalidUpstreamStaleAfterCfg, "invalid stale_after of the upstream"})
+
+	invalidUpstreamStalePolicyCfg := getValidAWSConfig()
+	invalidUpstreamStalePolicyCfg.Upstreams[0].StalePolicy = "remove"
+	input = append(input, &testInputAWS{invalidUpstreamStalePolicyCfg, "invalid stale_policy of the upstream"})
+
+	invalidUpstreamAddressFamilyCfg := getValidAWSConfig()
+	invalidUpstreamAddressFamilyCfg.Upstreams[0].AddressFamily = "ipv5"
+	input = append(input, &testInputAWS{invalidUpstreamAddressFamilyCfg, "invalid address_family of the upstream"})
+
+	invalidUpstreamInstanceStatesCfg := getValidAWSConfig()
+	invalidUpstreamInstanceStatesCfg.Upstreams[0].InstanceStates = []string{"running", "sleeping"}
+	input = append(input, &testInputAWS{invalidUpstreamInstanceStatesCfg, "invalid instance_states of the upstream"})
+
+	invalidUpstreamMinServersCfg := getValidAWSConfig()
+	invalidUpstreamMinServersCfg.Upstreams[0].MinServers = -1
+	input = append(input, &testInputAWS{invalidUpstreamMinServersCfg, "invalid min_servers of the upstream"})
+
+	invalidUpstreamMaxRemovalPercentCfg := getValidAWSConfig()
+	invalidUpstreamMaxRemovalPercentCfg.Upstreams[0].MaxRemovalPercent = 101
+	input = append(input, &testInputAWS{invalidUpstreamMaxRemovalPercentCfg, "invalid max_removal_percent of the upstream"})
+
+	invalidUpstreamDrainTimeoutCfg := getValidAWSConfig()
+	invalidUpstreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = -10 * time.Second
+	input = append(input, &testInputAWS{invalidUpstreamDrainTimeoutCfg, "invalid drain_timeout of the upstream"})
+
+	invalidUpstreamDrainTimeoutKindCfg := getValidAWSConfig()
+	invalidUpstreamDrainTimeoutKindCfg.Upstreams[0].Kind = "stream"
+	invalidUpstreamDrainTimeoutKindCfg.Upstreams[0].DrainTimeout = 10 * time.Second
+	input = append(input, &testInputAWS{invalidUpstreamDrainTimeoutKindCfg, "drain_timeout for a stream upstream"})
+
 	return input
 }
 
@@ -96,6 +225,25 @@ func TestValidateAWSConfigValid(t *testing.T) {
 	}
 }
 
+func TestValidateAWSConfigSameAutoscalingGroupInSeveralRegions(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAWSConfig()
+	cfg.Upstreams = append(cfg.Upstreams, awsUpstream{
+		Name: "backend2",
+		AutoscalingGroups: []awsScalingGroupConfig{
+			{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}},
+			{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}, Region: "eu-west-1"},
+		},
+		Port: 80,
+		Kind: "http",
+	}, awsUpstream{Name: "backend3", AutoscalingGroup: "backend-*", Port: 80, Kind: "http", Region: "eu-west-1"})
+
+	err := validateAWSConfig(cfg)
+	if err != nil {
+		t.Errorf("validateAWSConfig() failed for the same autoscaling_group in several regions: %v", err)
+	}
+}
+
 func TestGetUpstreamsAWS(t *testing.T) {
 	t.Parallel()
 	cfg := getValidAWSConfig()
@@ -168,6 +316,446 @@ func areEqualUpstreamsAWS(u1 awsUpstream, u2 Upstream) bool {
 	return true
 }
 
+func TestGetPrivateIPsForScalingGroupAWS(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{
+		{id: "i-1", ip: "10.0.0.1", state: "running"},
+		{id: "i-2", ip: "10.0.0.2", state: "stopped"},
+		{id: "i-3", ip: "10.0.0.3", state: "running"},
+		{id: "i-4", ip: "10.0.0.4", state: "shutting-down"},
+		{id: "i-5", ip: "10.0.0.5", state: "running"},
+	}
+
+	tests := []struct {
+		msg            string
+		instanceStates []string
+		expected       []string
+	}{
+		{
+			msg:      "default instance_states",
+			expected: []string{"10.0.0.1", "10.0.0.3", "10.0.0.5"},
+		},
+		{
+			msg:            "running and stopped instance_states",
+			instanceStates: []string{"running", "stopped"},
+			expected:       []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.5"},
+		},
+	}
+
+	for _, test := range tests {
+		cfg := getValidAWSConfig()
+		cfg.Upstreams[0].InstanceStates = test.instanceStates
+		client := newTestAWSClient(t, api, cfg)
+
+		instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+		if err != nil {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned an unexpected error for %v: %v", test.msg, err)
+			continue
+		}
+		ips := getInstanceIPs(instances)
+		if !slices.Equal(ips, test.expected) {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned %v for %v, expected %v", ips, test.msg, test.expected)
+		}
+	}
+
+	// the fake returns one instance per page
+	if api.describeInstancesCalls != 7 {
+		t.Errorf("DescribeInstances was called %v times, expected a call per page, 7 in total", api.describeInstancesCalls)
+	}
+}
+
+func TestSyncOnceAWSStoppedScalingGroup(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{
+		{id: "i-1", ip: "10.0.0.1", state: "running"},
+		{id: "i-2", ip: "10.0.0.2", state: "running"},
+	}
+	client := newTestAWSClient(t, api, getValidAWSConfig())
+
+	nginxAPI := newFakeNginxPlusAPI()
+	nginxAPI.addUpstream("http", "backend1")
+	syncer := NewSyncer(client, newTestNginxEndpoints(t, nginxAPI), NewMetrics(prometheus.NewRegistry()))
+
+	if result := syncer.SyncOnce(context.Background())[0]; result.Err != nil {
+		t.Fatalf("SyncOnce() failed: %v", result.Err)
+	}
+
+	api.mu.Lock()
+	for i := range api.instances["backend-group"] {
+		api.instances["backend-group"][i].state = "stopped"
+	}
+	api.mu.Unlock()
+
+	result := syncer.SyncOnce(context.Background())[0]
+	if result.Err != nil {
+		t.Errorf("SyncOnce() returned an error for the Auto Scaling group with stopped instances: %v", result.Err)
+	}
+	if result.Fallback != "" {
+		t.Errorf("SyncOnce() used the fallback %q for the Auto Scaling group with stopped instances", result.Fallback)
+	}
+	if got := nginxAPI.servers("http", "backend1"); len(got) != 0 {
+		t.Errorf("the upstream has servers %v after every instance stopped, expected none", got)
+	}
+
+	_, err := client.GetPrivateIPsForScalingGroup(context.Background(), "missing-group")
+	if err == nil {
+		t.Error("GetPrivateIPsForScalingGroup() didn't fail for an Auto Scaling group that doesn't exist")
+	}
+}
+
+func TestCheckIfScalingGroupExistsAWSWildcard(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-1"] = nil
+	api.instances["frontend-1"] = []fakeEC2Instance{{id: "i-1", ip: "10.0.0.1", state: "running"}}
+	client := newTestAWSClient(t, api, getValidAWSConfig())
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "backend-*", expected: true},
+		{name: "backend-?", expected: true},
+		{name: "backend-1", expected: true},
+		{name: "backend-2", expected: false},
+		{name: "other-*", expected: false},
+	}
+
+	for _, test := range tests {
+		exists, err := client.CheckIfScalingGroupExists(context.Background(), test.name)
+		if err != nil {
+			t.Errorf("CheckIfScalingGroupExists() failed for %v: %v", test.name, err)
+			continue
+		}
+		if exists != test.expected {
+			t.Errorf("CheckIfScalingGroupExists() returned %v for %v, expected %v", exists, test.name, test.expected)
+		}
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAWSTags(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{
+		{id: "i-1", ip: "10.0.0.1", state: "running", tags: map[string]string{weightTag: "3", routeTag: "a"}},
+		{id: "i-2", ip: "10.0.0.2", state: "running", tags: map[string]string{backupTag: "true"}},
+	}
+	client := newTestAWSClient(t, api, getValidAWSConfig())
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed: %v", err)
+	}
+
+	weight := 3
+	backup := true
+	expected := []Instance{{IP: "10.0.0.1", Weight: &weight, Route: "a"}, {IP: "10.0.0.2", Backup: &backup}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %+v, expected %+v", instances, expected)
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAWSRole(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{{id: "i-1", ip: "10.0.0.1", state: "running"}}
+	api.instances["other-group"] = []fakeEC2Instance{{id: "i-2", ip: "10.0.0.2", state: "running"}}
+
+	cfg := getValidAWSConfig()
+	cfg.RoleARN = "arn:aws:iam::123456789012:role/nginx-asg-sync"
+	cfg.ExternalID = "external-id"
+	cfg.Upstreams = append(cfg.Upstreams, awsUpstream{
+		Name:             "backend2",
+		AutoscalingGroup: "other-group",
+		Port:             80,
+		Kind:             "http",
+		RoleARN:          "arn:aws:iam::210987654321:role/nginx-asg-sync",
+		RoleSessionName:  "backend2",
+	})
+	client := newTestAWSClient(t, api, cfg)
+
+	for _, group := range []string{"backend-group", "other-group", "backend-group"} {
+		if _, err := client.GetPrivateIPsForScalingGroup(context.Background(), group); err != nil {
+			t.Fatalf("GetPrivateIPsForScalingGroup() failed for %v: %v", group, err)
+		}
+	}
+
+	expected := []awsServicesKey{
+		{roleARN: "arn:aws:iam::123456789012:role/nginx-asg-sync", externalID: "external-id", sessionName: defaultRoleSessionName},
+		{roleARN: "arn:aws:iam::210987654321:role/nginx-asg-sync", sessionName: "backend2"},
+	}
+	if got := api.getAssumedRoles(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() assumed the roles %+v, expected %+v", got, expected)
+	}
+}
+
+func TestGetServicesAWSOverlappingUpstreams(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["web-1"] = []fakeEC2Instance{{id: "i-1", ip: "10.0.0.1", state: "running"}}
+
+	// the config is refused by validateAWSConfig, the exact autoscaling_group must still take precedence
+	cfg := getValidAWSConfig()
+	cfg.Upstreams = []awsUpstream{
+		{Name: "web", AutoscalingGroup: "web-*", Port: 80, Kind: "http", RoleARN: "arn:aws:iam::111111111111:role/web", ExternalID: "web"},
+		{Name: "web1", AutoscalingGroup: "web-1", Port: 80, Kind: "http", RoleARN: "arn:aws:iam::222222222222:role/web1"},
+	}
+	client := newTestAWSClient(t, api, cfg)
+
+	_, err := client.GetPrivateIPsForScalingGroup(context.Background(), "web-1")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed for web-1: %v", err)
+	}
+	// the Auto Scaling group of a notification that only matches the wildcard
+	err = client.completeLifecycleAction(context.Background(), &lifecycleAction{
+		notification: autoscalingNotification{AutoScalingGroupName: "web-2", EC2InstanceID: "i-2", LifecycleHookName: "nginx-asg-sync"},
+	})
+	if err != nil {
+		t.Fatalf("completeLifecycleAction() failed for web-2: %v", err)
+	}
+
+	expected := []awsServicesKey{
+		{roleARN: "arn:aws:iam::222222222222:role/web1", sessionName: defaultRoleSessionName},
+		{roleARN: "arn:aws:iam::111111111111:role/web", externalID: "web", sessionName: defaultRoleSessionName},
+	}
+	if got := api.getAssumedRoles(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("the overlapping upstreams assumed the roles %+v, expected %+v", got, expected)
+	}
+}
+
+func TestGetScalingGroupLocationAWS(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAWSConfig()
+	other := cfg.Upstreams[0]
+	other.Name = "backend2"
+	other.AutoscalingGroup = "other-group"
+	other.Region = "eu-west-1"
+	other.RoleARN = "arn:aws:iam::111111111111:role/other"
+	cfg.Upstreams = append(cfg.Upstreams, other)
+	client := &AWSClient{config: cfg}
+
+	tests := map[string]string{
+		"backend-group":           "us-west-2/",
+		"eu-west-1/other-group":   "eu-west-1/arn:aws:iam::111111111111:role/other",
+		"other-group":             "us-west-2/",
+		"eu-west-1/backend-group": "us-west-2/",
+	}
+	for name, expected := range tests {
+		if location := client.GetScalingGroupLocation(name); location != expected {
+			t.Errorf("GetScalingGroupLocation(%v) returned %v, expected %v", name, location, expected)
+		}
+	}
+}
+
+func TestMatchesAutoscalingGroup(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{pattern: "web-1", name: "web-1", expected: true},
+		{pattern: "web-*", name: "web-1", expected: true},
+		{pattern: "web-?", name: "web-12", expected: false},
+		{pattern: "web-[1", name: "web-[1", expected: true},
+		{pattern: "web-[1", name: "web-1", expected: false},
+	}
+
+	for _, test := range tests {
+		if got := matchesAutoscalingGroup(test.pattern, test.name); got != test.expected {
+			t.Errorf("matchesAutoscalingGroup(%q, %q) returned %v, expected %v", test.pattern, test.name, got, test.expected)
+		}
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAWSRegion(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{{id: "i-1", ip: "10.0.0.1", state: "running"}}
+	api.instances["other-group"] = []fakeEC2Instance{{id: "i-2", ip: "10.0.0.2", state: "running"}}
+
+	cfg := getValidAWSConfig()
+	cfg.Upstreams = append(cfg.Upstreams, awsUpstream{
+		Name:             "backend2",
+		AutoscalingGroup: "other-group",
+		Port:             80,
+		Kind:             "http",
+		Region:           "eu-west-1",
+	})
+	client := newTestAWSClient(t, api, cfg)
+
+	for _, group := range []string{"backend-group", "eu-west-1/other-group"} {
+		if _, err := client.GetPrivateIPsForScalingGroup(context.Background(), group); err != nil {
+			t.Fatalf("GetPrivateIPsForScalingGroup() failed for %v: %v", group, err)
+		}
+	}
+
+	expected := map[string]string{"backend-group": "us-west-2", "other-group": "eu-west-1"}
+	if got := api.getRegions(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() looked up the scaling groups in the regions %v, expected %v", got, expected)
+	}
+}
+
+func TestSyncOnceAWSAutoscalingGroupsInSeveralRegions(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{
+		{id: "i-1", ip: "10.0.0.1", state: "running", region: "us-west-2"},
+		{id: "i-2", ip: "10.0.1.1", state: "running", region: "eu-west-1"},
+	}
+
+	cfg := getValidAWSConfig()
+	cfg.Upstreams[0].AutoscalingGroup = ""
+	cfg.Upstreams[0].AutoscalingGroups = []awsScalingGroupConfig{
+		{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}},
+		{scalingGroupConfig: scalingGroupConfig{Name: "backend-group"}, Region: "eu-west-1", RoleARN: "arn:aws:iam::111111111111:role/dr"},
+	}
+	cfg.Upstreams = append(cfg.Upstreams, awsUpstream{
+		Name:             "backend2",
+		AutoscalingGroup: "backend-group",
+		Port:             80,
+		Kind:             "http",
+		Region:           "eu-west-1",
+		RoleARN:          "arn:aws:iam::111111111111:role/dr",
+	})
+	if err := validateAWSConfig(cfg); err != nil {
+		t.Fatalf("validateAWSConfig() failed: %v", err)
+	}
+	client := newTestAWSClient(t, api, cfg)
+
+	expectedGroups := []ScalingGroup{{Name: "backend-group"}, {Name: "eu-west-1/backend-group"}}
+	if got := client.GetUpstreams()[0].ScalingGroups; !reflect.DeepEqual(got, expectedGroups) {
+		t.Errorf("GetUpstreams() returned the scaling groups %+v, expected %+v", got, expectedGroups)
+	}
+
+	nginxAPI := newFakeNginxPlusAPI()
+	nginxAPI.addUpstream("http", "backend1")
+	nginxAPI.addUpstream("http", "backend2")
+	syncer := NewSyncer(client, newTestNginxEndpoints(t, nginxAPI), NewMetrics(prometheus.NewRegistry()))
+
+	for _, result := range syncer.SyncOnce(context.Background()) {
+		if result.Err != nil {
+			t.Fatalf("SyncOnce() failed for the upstream %v: %v", result.Upstream.Name, result.Err)
+		}
+	}
+
+	if got := nginxAPI.servers("http", "backend1"); !slices.Equal(got, []string{"10.0.0.1:80", "10.0.1.1:80"}) {
+		t.Errorf("the upstream has servers %v, expected the instances of both regions [10.0.0.1:80 10.0.1.1:80]", got)
+	}
+	if got := nginxAPI.servers("http", "backend2"); !slices.Equal(got, []string{"10.0.1.1:80"}) {
+		t.Errorf("the upstream of the other region has servers %v, expected [10.0.1.1:80]", got)
+	}
+	expectedRoles := []awsServicesKey{{roleARN: "arn:aws:iam::111111111111:role/dr", sessionName: defaultRoleSessionName}}
+	if got := api.getAssumedRoles(); !reflect.DeepEqual(got, expectedRoles) {
+		t.Errorf("SyncOnce() assumed the roles %+v, expected %+v", got, expectedRoles)
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAWSInstanceTags(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{
+		{id: "i-1", ip: "10.0.0.1", state: "running", tags: map[string]string{"role": "backend", "env": "prod"}},
+	}
+	api.instances[""] = []fakeEC2Instance{
+		{id: "i-2", ip: "10.0.0.2", state: "running", tags: map[string]string{"role": "backend", "env": "prod", weightTag: "2"}},
+		{id: "i-3", ip: "10.0.0.3", state: "running", tags: map[string]string{"role": "backend", "env": "staging"}},
+		{id: "i-4", ip: "10.0.0.4", state: "stopped", tags: map[string]string{"role": "backend", "env": "prod"}},
+	}
+
+	cfg := getValidAWSConfig()
+	cfg.Upstreams[0].AutoscalingGroup = ""
+	cfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend", "env": "prod"}
+	client := newTestAWSClient(t, api, cfg)
+
+	name := client.GetUpstreams()[0].ScalingGroups[0].Name
+	exists, err := client.CheckIfScalingGroupExists(context.Background(), name)
+	if err != nil || !exists {
+		t.Errorf("CheckIfScalingGroupExists() returned %v, %v for the instance_tags, expected true", exists, err)
+	}
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), name)
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed for the instance_tags: %v", err)
+	}
+
+	weight := 2
+	expected := []Instance{{IP: "10.0.0.2", Weight: &weight}, {IP: "10.0.0.1"}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for the instance_tags, expected %+v", instances, expected)
+	}
+}
+
+func TestSyncOnceAWSInstanceTagsNoMatch(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances[""] = []fakeEC2Instance{{id: "i-1", ip: "10.0.0.1", state: "running", tags: map[string]string{"role": "backend"}}}
+
+	cfg := getValidAWSConfig()
+	cfg.Upstreams[0].AutoscalingGroup = ""
+	cfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	client := newTestAWSClient(t, api, cfg)
+
+	nginxAPI := newFakeNginxPlusAPI()
+	nginxAPI.addUpstream("http", "backend1")
+	syncer := NewSyncer(client, newTestNginxEndpoints(t, nginxAPI), NewMetrics(prometheus.NewRegistry()))
+
+	if result := syncer.SyncOnce(context.Background())[0]; result.Err != nil {
+		t.Fatalf("SyncOnce() failed: %v", result.Err)
+	}
+
+	// the last instance with the tags is terminated
+	api.mu.Lock()
+	api.instances[""][0].state = "terminated"
+	api.mu.Unlock()
+
+	result := syncer.SyncOnce(context.Background())[0]
+	if result.Err != nil {
+		t.Errorf("SyncOnce() returned an error for the instance_tags without instances: %v", result.Err)
+	}
+	if got := nginxAPI.servers("http", "backend1"); len(got) != 0 {
+		t.Errorf("the upstream has servers %v after the last instance with the tags was terminated, expected none", got)
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAWSIPv6(t *testing.T) {
+	t.Parallel()
+	api := newFakeAWSAPI()
+	api.instances["backend-group"] = []fakeEC2Instance{
+		{id: "i-1", ip: "10.0.0.1", ipv6: "2001:db8::1", state: "running"},
+		{id: "i-2", ipv6: "2001:db8::2", state: "running"},
+		{id: "i-3", ip: "10.0.0.3", state: "running"},
+	}
+	client := newTestAWSClient(t, api, getValidAWSConfig())
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed for the IPv6 addresses: %v", err)
+	}
+
+	expected := []Instance{{IP: "10.0.0.1", IPv6: "2001:db8::1"}, {IPv6: "2001:db8::2"}, {IP: "10.0.0.3"}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for the IPv6 addresses, expected %+v", instances, expected)
+	}
+}
+
+func TestGetInstanceStates(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAWSConfig()
+	cfg.Upstreams = append(cfg.Upstreams,
+		awsUpstream{Name: "backend2", AutoscalingGroup: "backend-group", InstanceStates: []string{"stopped", "running"}},
+		awsUpstream{Name: "backend3", AutoscalingGroup: "other-group", InstanceStates: []string{"pending"}},
+	)
+	client := AWSClient{config: cfg}
+
+	states := client.getInstanceStates("backend-group")
+	if !slices.Equal(states, []string{"running", "stopped"}) {
+		t.Errorf("getInstanceStates() returned %v, expected [running stopped]", states)
+	}
+}
+
 func TestPrepareBatches(t *testing.T) {
 	t.Parallel()
 	const maxItems = 3
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	// sqsWaitTimeInSecs is the duration of the long polling of the SQS queue, the maximum allowed by SQS.
+	sqsWaitTimeInSecs = 20
+	// sqsMaxMessages is the maximum number of messages received at once, the maximum allowed by SQS.
+	sqsMaxMessages = 10
+	// sqsRetryDelay is the delay before receiving the messages again after a failure.
+	sqsRetryDelay = 10 * time.Second
+	// testNotificationEvent is the event of the notification sent by Auto Scaling when a notification target is configured.
+	testNotificationEvent = "autoscaling:TEST_NOTIFICATION"
+)
+
+// autoscalingNotification is a notification of an Auto Scaling group about one of its instances. It is a lifecycle hook
+// notification, an Auto Scaling notification delivered through SNS or an EventBridge event of Auto Scaling.
+type autoscalingNotification struct {
+	// Event is the event of an Auto Scaling notification, for example autoscaling:EC2_INSTANCE_LAUNCH.
+	Event string `json:"Event"`
+	// LifecycleTransition is the transition of a lifecycle hook notification, for example
+	// autoscaling:EC2_INSTANCE_TERMINATING.
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+}
+
+// snsNotification is the envelope of a message published to an SNS topic and delivered to an SQS queue.
+type snsNotification struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// eventBridgeEvent is the envelope of an EventBridge event delivered to an SQS queue.
+type eventBridgeEvent struct {
+	Detail     *autoscalingNotification `json:"detail"`
+	Source     string                   `json:"source"`
+	DetailType string                   `json:"detail-type"`
+}
+
+// parseAutoscalingNotification parses the body of an SQS message with an Auto Scaling notification.
+func parseAutoscalingNotification(body string) (autoscalingNotification, error) {
+	var envelope struct {
+		snsNotification
+		eventBridgeEvent
+	}
+	err := json.Unmarshal([]byte(body), &envelope)
+	if err != nil {
+		return autoscalingNotification{}, fmt.Errorf("couldn't unmarshal the message: %w", err)
+	}
+
+	var notification autoscalingNotification
+	switch {
+	case envelope.Type == "Notification":
+		err = json.Unmarshal([]byte(envelope.Message), &notification)
+	case envelope.Source == "aws.autoscaling" && envelope.Detail != nil:
+		notification = *envelope.Detail
+	default:
+		err = json.Unmarshal([]byte(body), &notification)
+	}
+	if err != nil {
+		return autoscalingNotification{}, fmt.Errorf("couldn't unmarshal the notification: %w", err)
+	}
+
+	if notification.AutoScalingGroupName == "" {
+		return autoscalingNotification{}, errors.New("the message isn't an Auto Scaling notification")
+	}
+
+	return notification, nil
+}
+
+// WatchScalingGroups receives the Auto Scaling notifications from the SQS queue of the config and sends the names of
+// the Auto Scaling groups of the upstreams they are about to the channel, until the context is done. The messages are
+// deleted from the queue once they are handled. It returns immediately if sqs_queue_url isn't set.
+func (client *AWSClient) WatchScalingGroups(ctx context.Context, changed chan<- string) {
+	if client.config.SQSQueueURL == "" {
+		return
+	}
+
+	slog.Info("Watching the Auto Scaling notifications", "queue_url", client.config.SQSQueueURL)
+
+	for ctx.Err() == nil {
+		response, err := client.svcSQS.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(client.config.SQSQueueURL),
+			MaxNumberOfMessages: sqsMaxMessages,
+			WaitTimeSeconds:     sqsWaitTimeInSecs,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			slog.Warn("Couldn't receive the Auto Scaling notifications, the upstreams are synchronized every sync_interval",
+				"queue_url", client.config.SQSQueueURL, "error", err)
+			select {
+			case <-time.After(sqsRetryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		for _, message := range response.Messages {
+			client.handleNotification(ctx, aws.ToString(message.Body), changed)
+
+			_, err = client.svcSQS.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(client.config.SQSQueueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Couldn't delete the Auto Scaling notification", "message_id", aws.ToString(message.MessageId), "error", err)
+			}
+		}
+	}
+}
+
+// handleNotification sends the Auto Scaling groups of the upstreams that match the group of the notification in the
+// message body to the channel. The lifecycle actions of the lifecycle hooks of the config are kept until they are
+// completed.
+func (client *AWSClient) handleNotification(ctx context.Context, body string, changed chan<- string) {
+	notification, err := parseAutoscalingNotification(body)
+	if err != nil {
+		slog.Warn("Ignoring a message of the SQS queue", "queue_url", client.config.SQSQueueURL, "error", err)
+		return
+	}
+
+	if notification.Event == testNotificationEvent {
+		return
+	}
+
+	var scalingGroups []string
+	for _, u := range client.config.Upstreams {
+		for _, g := range u.getScalingGroups(client.config) {
+			if matchesAutoscalingGroup(g.Name, notification.AutoScalingGroupName) && !slices.Contains(scalingGroups, g.Name) {
+				scalingGroups = append(scalingGroups, g.Name)
+			}
+		}
+	}
+	if len(scalingGroups) == 0 {
+		return
+	}
+
+	slog.Debug("Received an Auto Scaling notification", "scaling_group", notification.AutoScalingGroupName,
+		"instance_id", notification.EC2InstanceID, "event", notification.Event, "lifecycle_transition", notification.LifecycleTransition)
+
+	if slices.Contains(client.config.LifecycleHooks, notification.LifecycleHookName) {
+		client.addLifecycleAction(notification)
+	}
+
+	for _, scalingGroup := range scalingGroups {
+		select {
+		case changed <- scalingGroup:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// matchesAutoscalingGroup returns true if the name of the Auto Scaling group matches the autoscaling_group of an
+// upstream, which can have the * and ? wildcards of the EC2 filters. A name always matches itself, even if it isn't a
+// valid pattern.
+func matchesAutoscalingGroup(pattern string, name string) bool {
+	if pattern == name {
+		return true
+	}
+
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	lifecycleHookNotification = `{"Origin":"EC2","Destination":"AutoScalingGroup","Service":"AWS Auto Scaling",` +
+		`"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","LifecycleHookName":"nginx-asg-sync",` +
+		`"LifecycleActionToken":"token-1","AutoScalingGroupName":"backend-group","EC2InstanceId":"i-1"}`
+	snsNotificationMessage = `{"Type":"Notification","MessageId":"1","TopicArn":"arn:aws:sns:us-west-2:123456789012:asg",` +
+		`"Message":"{\"Event\":\"autoscaling:EC2_INSTANCE_LAUNCH\",\"AutoScalingGroupName\":\"backend-group\",\"EC2InstanceId\":\"i-2\"}"}`
+	eventBridgeNotification = `{"version":"0","source":"aws.autoscaling","detail-type":"EC2 Instance Terminate Successful",` +
+		`"detail":{"AutoScalingGroupName":"backend-group","EC2InstanceId":"i-3"}}`
+	testNotification = `{"Event":"autoscaling:TEST_NOTIFICATION","AutoScalingGroupName":"backend-group"}`
+)
+
+func TestParseAutoscalingNotification(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg      string
+		body     string
+		expected autoscalingNotification
+	}{
+		{
+			msg:  "lifecycle hook notification",
+			body: lifecycleHookNotification,
+			expected: autoscalingNotification{
+				LifecycleTransition:  "autoscaling:EC2_INSTANCE_TERMINATING",
+				LifecycleHookName:    "nginx-asg-sync",
+				LifecycleActionToken: "token-1",
+				AutoScalingGroupName: "backend-group",
+				EC2InstanceID:        "i-1",
+			},
+		},
+		{
+			msg:  "notification delivered through SNS",
+			body: snsNotificationMessage,
+			expected: autoscalingNotification{
+				Event:                "autoscaling:EC2_INSTANCE_LAUNCH",
+				AutoScalingGroupName: "backend-group",
+				EC2InstanceID:        "i-2",
+			},
+		},
+		{
+			msg:  "EventBridge event",
+			body: eventBridgeNotification,
+			expected: autoscalingNotification{
+				AutoScalingGroupName: "backend-group",
+				EC2InstanceID:        "i-3",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		notification, err := parseAutoscalingNotification(test.body)
+		if err != nil {
+			t.Errorf("parseAutoscalingNotification() failed for the %v: %v", test.msg, err)
+			continue
+		}
+		if notification != test.expected {
+			t.Errorf("parseAutoscalingNotification() returned %+v for the %v, expected %+v", notification, test.msg, test.expected)
+		}
+	}
+}
+
+func TestParseAutoscalingNotificationInvalid(t *testing.T) {
+	t.Parallel()
+	for _, body := range []string{
+		"not json",
+		`{"Type":"Notification","Message":"not json"}`,
+		`{"source":"aws.ec2","detail-type":"EC2 Instance State-change Notification","detail":{"instance-id":"i-1"}}`,
+	} {
+		if _, err := parseAutoscalingNotification(body); err == nil {
+			t.Errorf("parseAutoscalingNotification() didn't fail for the message %v", body)
+		}
+	}
+}
+
+func TestWatchScalingGroups(t *testing.T) {
+	t.Parallel()
+	api := newFakeSQSAPI()
+	for _, body := range []string{
+		lifecycleHookNotification,
+		testNotification,
+		"not json",
+		`{"Event":"autoscaling:EC2_INSTANCE_LAUNCH","AutoScalingGroupName":"other-group"}`,
+		eventBridgeNotification,
+	} {
+		api.sendMessage(body)
+	}
+
+	cfg := getValidAWSConfig()
+	cfg.SQSQueueURL = "https://sqs.us-west-2.amazonaws.com/123456789012/nginx-asg-sync"
+	client := newTestAWSClient(t, newFakeAWSAPI(), cfg)
+	client.svcSQS = newTestSQSClient(t, api)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		client.WatchScalingGroups(ctx, changed)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for api.messageCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	close(changed)
+
+	if got := api.messageCount(); got != 0 {
+		t.Errorf("WatchScalingGroups() left %v messages in the queue, expected them to be deleted", got)
+	}
+
+	var got []string
+	for scalingGroup := range changed {
+		got = append(got, scalingGroup)
+	}
+	if expected := []string{"backend-group", "backend-group"}; !slices.Equal(got, expected) {
+		t.Errorf("WatchScalingGroups() sent the scaling groups %v, expected %v", got, expected)
+	}
+}
+
+func TestWatchScalingGroupsWithoutQueue(t *testing.T) {
+	t.Parallel()
+	client := &AWSClient{config: getValidAWSConfig()}
+
+	done := make(chan struct{})
+	go func() {
+		client.WatchScalingGroups(context.Background(), make(chan string))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("WatchScalingGroups() didn't return without sqs_queue_url")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+)
+
+const (
+	launchingTransition   = "autoscaling:EC2_INSTANCE_LAUNCHING"
+	terminatingTransition = "autoscaling:EC2_INSTANCE_TERMINATING"
+	// maxLifecycleActionAge is the maximum time an instance can stay in the wait state of a lifecycle hook.
+	maxLifecycleActionAge = 48 * time.Hour
+)
+
+// lifecycleAction is the lifecycle action of a lifecycle hook of the config, waiting for its instance to be added to
+// or removed from the upstreams of its Auto Scaling group.
+type lifecycleAction struct {
+	received     time.Time
+	notification autoscalingNotification
+	// addresses are the IP addresses of the instance, empty until the Auto Scaling group is looked up.
+	addresses []string
+}
+
+// lifecycleActions are the lifecycle actions waiting for their instances to be added to or removed from the upstreams.
+type lifecycleActions struct {
+	// actions maps the ID of an instance to its lifecycle action.
+	actions map[string]*lifecycleAction
+	mu      sync.Mutex
+}
+
+// addLifecycleAction keeps the lifecycle action of the notification until it is completed.
+func (client *AWSClient) addLifecycleAction(notification autoscalingNotification) {
+	if notification.LifecycleTransition != launchingTransition && notification.LifecycleTransition != terminatingTransition {
+		return
+	}
+
+	client.lifecycleActions.mu.Lock()
+	defer client.lifecycleActions.mu.Unlock()
+
+	client.lifecycleActions.actions[notification.EC2InstanceID] = &lifecycleAction{received: time.Now(), notification: notification}
+}
+
+// getLifecycleTransition records the IP addresses of the instance if it has a lifecycle action and returns the transition
+// of the lifecycle action, or an empty string.
+func (client *AWSClient) getLifecycleTransition(instanceID string, instance Instance) string {
+	client.lifecycleActions.mu.Lock()
+	defer client.lifecycleActions.mu.Unlock()
+
+	action, ok := client.lifecycleActions.actions[instanceID]
+	if !ok {
+		return ""
+	}
+
+	action.addresses = instance.getAddresses(addressFamilyDual)
+	return action.notification.LifecycleTransition
+}
+
+// CompleteLifecycleActions completes the lifecycle actions whose instances were added to or removed from every upstream
+// of their Auto Scaling group in every NGINX Plus instance, according to the results of a synchronization. The lifecycle
+// actions of the Auto Scaling groups that weren't synchronized successfully are completed after a later synchronization.
+func (client *AWSClient) CompleteLifecycleActions(ctx context.Context, results []SyncResult) {
+	client.lifecycleActions.mu.Lock()
+	var done []*lifecycleAction
+	for id, action := range client.lifecycleActions.actions {
+		if time.Since(action.received) > maxLifecycleActionAge {
+			delete(client.lifecycleActions.actions, id)
+			continue
+		}
+		if len(action.addresses) > 0 && isLifecycleActionDone(action, results) {
+			done = append(done, action)
+		}
+	}
+	client.lifecycleActions.mu.Unlock()
+
+	for _, action := range done {
+		err := client.completeLifecycleAction(ctx, action)
+		if err != nil {
+			// the lifecycle action is completed after the next synchronization, or by the heartbeat timeout of the hook
+			slog.Warn("Couldn't complete the lifecycle action", "scaling_group", action.notification.AutoScalingGroupName,
+				"instance_id", action.notification.EC2InstanceID, "lifecycle_hook", action.notification.LifecycleHookName, "error", err)
+			continue
+		}
+
+		slog.Info("Completed the lifecycle action", "scaling_group", action.notification.AutoScalingGroupName,
+			"instance_id", action.notification.EC2InstanceID, "lifecycle_hook", action.notification.LifecycleHookName,
+			"lifecycle_transition", action.notification.LifecycleTransition, "waited", time.Since(action.received))
+
+		client.lifecycleActions.mu.Lock()
+		// a new lifecycle action of the instance might have been received in the meantime
+		if client.lifecycleActions.actions[action.notification.EC2InstanceID] == action {
+			delete(client.lifecycleActions.actions, action.notification.EC2InstanceID)
+		}
+		client.lifecycleActions.mu.Unlock()
+	}
+}
+
+// completeLifecycleAction completes the lifecycle action with CONTINUE.
+func (client *AWSClient) completeLifecycleAction(ctx context.Context, action *lifecycleAction) error {
+	services, err := client.getServices(ctx, action.notification.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+
+	_, err = services.autoscaling.CompleteLifecycleAction(ctx, &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(action.notification.AutoScalingGroupName),
+		LifecycleHookName:     aws.String(action.notification.LifecycleHookName),
+		LifecycleActionToken:  aws.String(action.notification.LifecycleActionToken),
+		InstanceId:            aws.String(action.notification.EC2InstanceID),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't complete the lifecycle action: %w", err)
+	}
+
+	return nil
+}
+
+// isLifecycleActionDone returns true if the results have every upstream of the Auto Scaling group of the lifecycle
+// action synchronized successfully, with the instance among their servers if it is launching, or not if it is terminating.
+func isLifecycleActionDone(action *lifecycleAction, results []SyncResult) bool {
+	var synchronized bool
+	for _, result := range results {
+		if !slices.ContainsFunc(result.Upstream.ScalingGroups, func(g ScalingGroup) bool {
+			return matchesAutoscalingGroup(g.Name, action.notification.AutoScalingGroupName)
+		}) {
+			continue
+		}
+		if result.Err != nil {
+			return false
+		}
+
+		inUpstream := slices.ContainsFunc(result.Servers, func(server string) bool {
+			host, _, err := net.SplitHostPort(server)
+			return err == nil && slices.Contains(action.addresses, host)
+		})
+		if inUpstream != (action.notification.LifecycleTransition == launchingTransition) {
+			return false
+		}
+		synchronized = true
+	}
+
+	return synchronized
+}
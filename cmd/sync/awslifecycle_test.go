@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// getLifecycleNotification returns a lifecycle hook notification of the nginx-asg-sync hook for the instance.
+func getLifecycleNotification(transition string, instanceID string) string {
+	return fmt.Sprintf(`{"LifecycleTransition":%q,"LifecycleHookName":"nginx-asg-sync","LifecycleActionToken":"token",`+
+		`"AutoScalingGroupName":"backend-group","EC2InstanceId":%q}`, transition, instanceID)
+}
+
+func TestLifecycleActions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg string
+		// lifecycleState is the lifecycle state of the instance i-2 with the lifecycle action.
+		lifecycleState string
+		transition     string
+		// serversBefore are the servers before the instance is added or removed.
+		serversBefore []string
+		// serversAfter are the servers once the instance is added or removed.
+		serversAfter []string
+		// expected are the IP addresses of the instances of the scaling group.
+		expected  []string
+		inService bool
+	}{
+		{
+			msg:            "terminating",
+			lifecycleState: "Terminating:Wait",
+			transition:     terminatingTransition,
+			serversBefore:  []string{"10.0.0.1:80", "10.0.0.2:80"},
+			serversAfter:   []string{"10.0.0.1:80"},
+			expected:       []string{"10.0.0.1"},
+		},
+		{
+			msg:            "launching in service",
+			lifecycleState: "Pending:Wait",
+			transition:     launchingTransition,
+			serversBefore:  []string{"10.0.0.1:80"},
+			serversAfter:   []string{"10.0.0.1:80", "10.0.0.2:80"},
+			expected:       []string{"10.0.0.1", "10.0.0.2"},
+			inService:      true,
+		},
+	}
+
+	for _, test := range tests {
+		api := newFakeAWSAPI()
+		api.instances["backend-group"] = []fakeEC2Instance{
+			{id: "i-1", ip: "10.0.0.1", state: "running"},
+			{id: "i-2", ip: "10.0.0.2", state: "running", lifecycleState: test.lifecycleState},
+		}
+
+		cfg := getValidAWSConfig()
+		cfg.LifecycleHooks = []string{"nginx-asg-sync"}
+		cfg.Upstreams[0].InService = test.inService
+		client := newTestAWSClient(t, api, cfg)
+
+		changed := make(chan string, 1)
+		client.handleNotification(context.Background(), getLifecycleNotification(test.transition, "i-2"), changed)
+		if got := <-changed; got != "backend-group" {
+			t.Errorf("handleNotification() sent the scaling group %v for %v, expected backend-group", got, test.msg)
+		}
+
+		instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+		if err != nil {
+			t.Fatalf("GetPrivateIPsForScalingGroup() failed for %v: %v", test.msg, err)
+		}
+		var ips []string
+		for _, ins := range instances {
+			ips = append(ips, ins.IP)
+		}
+		slices.Sort(ips)
+		if !slices.Equal(ips, test.expected) {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned %v for %v, expected %v", ips, test.msg, test.expected)
+		}
+
+		upstream := client.GetUpstreams()[0]
+		client.CompleteLifecycleActions(context.Background(), []SyncResult{
+			{Upstream: upstream, Endpoint: "nginx-1", Servers: test.serversAfter},
+			{Upstream: upstream, Endpoint: "nginx-2", Servers: test.serversBefore},
+		})
+		if got := api.getCompletedLifecycleActions(); len(got) != 0 {
+			t.Errorf("CompleteLifecycleActions() completed %v for %v before every NGINX Plus instance was updated", got, test.msg)
+		}
+
+		client.CompleteLifecycleActions(context.Background(), []SyncResult{
+			{Upstream: upstream, Endpoint: "nginx-1", Servers: test.serversAfter},
+			{Upstream: upstream, Endpoint: "nginx-2", Err: errors.New("unreachable")},
+		})
+		if got := api.getCompletedLifecycleActions(); len(got) != 0 {
+			t.Errorf("CompleteLifecycleActions() completed %v for %v after a failed synchronization", got, test.msg)
+		}
+
+		for range 2 {
+			client.CompleteLifecycleActions(context.Background(), []SyncResult{
+				{Upstream: upstream, Endpoint: "nginx-1", Servers: test.serversAfter},
+				{Upstream: upstream, Endpoint: "nginx-2", Servers: test.serversAfter},
+			})
+		}
+		if got := api.getCompletedLifecycleActions(); !slices.Equal(got, []string{"i-2"}) {
+			t.Errorf("CompleteLifecycleActions() completed %v for %v, expected the lifecycle action of i-2 once", got, test.msg)
+		}
+	}
+}
+
+func TestLifecycleActionsOfOtherHooks(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAWSConfig()
+	cfg.LifecycleHooks = []string{"other-hook"}
+	client := newTestAWSClient(t, newFakeAWSAPI(), cfg)
+
+	client.handleNotification(context.Background(), getLifecycleNotification(terminatingTransition, "i-2"), make(chan string, 1))
+
+	if got := client.getLifecycleTransition("i-2", Instance{IP: "10.0.0.2"}); got != "" {
+		t.Errorf("handleNotification() kept the lifecycle action of a hook not in the config with the transition %v", got)
+	}
+}
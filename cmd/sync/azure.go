@@ -4,22 +4,54 @@ import (
 	"context"
 	"errors"
 	"fmt"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
 	yaml "gopkg.in/yaml.v3"
 )
 
+// The codes of the statuses of the instance view of a Virtual Machine in service.
+const (
+	provisioningSucceededCode = "ProvisioningState/succeeded"
+	powerStateRunningCode     = "PowerState/running"
+	healthyStateCode          = "HealthState/healthy"
+)
+
 // AzureClient allows you to get the list of IP addresses of VirtualMachines of a VirtualMachine Scale Set. It implements the CloudProvider interface.
 type AzureClient struct {
-	config      *azureConfig
-	vMSSClient  *armcompute.VirtualMachineScaleSetsClient
-	iFaceClient *armnetwork.InterfacesClient
+	config *azureConfig
+	// clients are the Azure API clients by subscription ID.
+	clients map[string]*azureClients
+	// scaleSets caches the Virtual Machine Scale Sets by name, since their orchestration mode can't change.
+	scaleSets map[string]azureScaleSet
+	mu        sync.Mutex
+}
+
+// azureClients are the Azure API clients of a subscription.
+type azureClients struct {
+	vMSSClient    *armcompute.VirtualMachineScaleSetsClient
+	vMSSVMsClient *armcompute.VirtualMachineScaleSetVMsClient
+	vMClient      *armcompute.VirtualMachinesClient
+	iFaceClient   *armnetwork.InterfacesClient
+}
+
+// azureScaleSet is a Virtual Machine Scale Set.
+type azureScaleSet struct {
+	id string
+	// flexible is true if the Virtual Machine Scale Set uses the Flexible orchestration mode, whose Virtual Machines and
+	// network interfaces are listed like those that aren't in a Virtual Machine Scale Set.
+	flexible bool
 }
 
 // NewAzureClient creates an AzureClient.
-func NewAzureClient(data []byte) (*AzureClient, error) {
+func NewAzureClient(ctx context.Context, data []byte) (*AzureClient, error) {
 	azureClient := &AzureClient{}
 	cfg, err := parseAzureConfig(data)
 	if err != nil {
@@ -28,7 +60,7 @@ func NewAzureClient(data []byte) (*AzureClient, error) {
 
 	azureClient.config = cfg
 
-	err = azureClient.configure()
+	err = azureClient.configure(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error configuring Azure Client: %w", err)
 	}
@@ -52,9 +84,9 @@ func parseAzureConfig(data []byte) (*azureConfig, error) {
 	return cfg, nil
 }
 
-func (client *AzureClient) listScaleSetsNetworkInterfaces(ctx context.Context, resourceGroupName, vmssName string) ([]*armnetwork.Interface, error) {
+func (clients *azureClients) listScaleSetsNetworkInterfaces(ctx context.Context, resourceGroupName, vmssName string) ([]*armnetwork.Interface, error) {
 	var result []*armnetwork.Interface
-	pager := client.iFaceClient.NewListVirtualMachineScaleSetNetworkInterfacesPager(resourceGroupName, vmssName, nil)
+	pager := clients.iFaceClient.NewListVirtualMachineScaleSetNetworkInterfacesPager(resourceGroupName, vmssName, nil)
 	for pager.More() {
 		resp, err := pager.NextPage(ctx)
 		if err != nil {
@@ -65,30 +97,323 @@ func (client *AzureClient) listScaleSetsNetworkInterfaces(ctx context.Context, r
 	return result, nil
 }
 
-// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Virtual Machine Scale Set.
-func (client *AzureClient) GetPrivateIPsForScalingGroup(name string) ([]string, error) {
-	var ips []string
+// listFlexibleScaleSetsNetworkInterfaces returns the network interfaces of the Virtual Machines of the Virtual Machine
+// Scale Set in the Flexible orchestration mode, and its Virtual Machines by their lowercase ID. The instance views of
+// the Virtual Machines are only listed if instanceView is true.
+func (clients *azureClients) listFlexibleScaleSetsNetworkInterfaces(ctx context.Context, resourceGroupName string, scaleSet azureScaleSet, instanceView bool) ([]*armnetwork.Interface, map[string]scaleSetVM, error) {
+	options := &armcompute.VirtualMachinesClientListOptions{Filter: to.Ptr(fmt.Sprintf("'virtualMachineScaleSet/id' eq '%v'", scaleSet.id))}
+	if instanceView {
+		options.Expand = to.Ptr(armcompute.ExpandTypeForListVMsInstanceView)
+	}
 
-	ctx := context.TODO()
+	vms := make(map[string]scaleSetVM)
+	pager := clients.vMClient.NewListPager(resourceGroupName, options)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("listing virtual machines: %w", err)
+		}
+		for _, vm := range resp.Value {
+			if vm.ID == nil {
+				continue
+			}
+			var inService bool
+			if vm.Properties != nil && vm.Properties.InstanceView != nil {
+				inService = isInService(vm.Properties.InstanceView.Statuses, vm.Properties.InstanceView.VMHealth)
+			}
+			vms[strings.ToLower(*vm.ID)] = scaleSetVM{tags: getAzureTagsMap(vm.Tags), inService: inService}
+		}
+	}
 
-	iFaces, err := client.listScaleSetsNetworkInterfaces(ctx, client.config.ResourceGroupName, name)
+	iFaces, err := clients.listNetworkInterfaces(ctx, resourceGroupName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	var result []*armnetwork.Interface
 	for _, iFace := range iFaces {
-		if iFace.Properties.VirtualMachine != nil && iFace.Properties.VirtualMachine.ID != nil && iFace.Properties.IPConfigurations != nil {
-			for _, n := range iFace.Properties.IPConfigurations {
-				ip := getPrimaryIPFromInterfaceIPConfiguration(n)
-				if ip != "" {
-					ips = append(ips, *n.Properties.PrivateIPAddress)
-					break
-				}
+		if iFace.Properties == nil || iFace.Properties.VirtualMachine == nil || iFace.Properties.VirtualMachine.ID == nil {
+			continue
+		}
+		if _, ok := vms[strings.ToLower(*iFace.Properties.VirtualMachine.ID)]; ok {
+			result = append(result, iFace)
+		}
+	}
+
+	return result, vms, nil
+}
+
+// scaleSetVM is a Virtual Machine of a Virtual Machine Scale Set.
+type scaleSetVM struct {
+	tags map[string]string
+	// inService is true if the Virtual Machine is in service, it is only set if the instance views are listed.
+	inService bool
+}
+
+// listScaleSetVMs returns the Virtual Machines of the Virtual Machine Scale Set by their lowercase ID. The instance views
+// of the Virtual Machines are only listed if instanceView is true.
+func (clients *azureClients) listScaleSetVMs(ctx context.Context, resourceGroupName, vmssName string, instanceView bool) (map[string]scaleSetVM, error) {
+	var options *armcompute.VirtualMachineScaleSetVMsClientListOptions
+	if instanceView {
+		options = &armcompute.VirtualMachineScaleSetVMsClientListOptions{Expand: to.Ptr("instanceView")}
+	}
+
+	result := make(map[string]scaleSetVM)
+	pager := clients.vMSSVMsClient.NewListPager(resourceGroupName, vmssName, options)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing virtual machines: %w", err)
+		}
+		for _, vm := range resp.Value {
+			if vm.ID == nil {
+				continue
+			}
+			var inService bool
+			if vm.Properties != nil && vm.Properties.InstanceView != nil {
+				inService = isInService(vm.Properties.InstanceView.Statuses, vm.Properties.InstanceView.VMHealth)
+			}
+			result[strings.ToLower(*vm.ID)] = scaleSetVM{tags: getAzureTagsMap(vm.Tags), inService: inService}
+		}
+	}
+	return result, nil
+}
+
+// isInService returns true if the statuses of the instance view of a Virtual Machine have the succeeded provisioning
+// state and the running power state, and the health has the healthy state if the Application Health extension is
+// installed.
+func isInService(statuses []*armcompute.InstanceViewStatus, health *armcompute.VirtualMachineHealthStatus) bool {
+	if health != nil && health.Status != nil && health.Status.Code != nil &&
+		!strings.EqualFold(*health.Status.Code, healthyStateCode) {
+		return false
+	}
+
+	var provisioned, running bool
+	for _, status := range statuses {
+		if status == nil || status.Code == nil {
+			continue
+		}
+		provisioned = provisioned || strings.EqualFold(*status.Code, provisioningSucceededCode)
+		running = running || strings.EqualFold(*status.Code, powerStateRunningCode)
+	}
+
+	return provisioned && running
+}
+
+// listVMsTags returns the tags of the Virtual Machines of the resource group by their lowercase ID.
+func (clients *azureClients) listVMsTags(ctx context.Context, resourceGroupName string) (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string)
+	pager := clients.vMClient.NewListPager(resourceGroupName, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing virtual machines: %w", err)
+		}
+		for _, vm := range resp.Value {
+			if vm.ID != nil {
+				result[strings.ToLower(*vm.ID)] = getAzureTagsMap(vm.Tags)
 			}
 		}
 	}
+	return result, nil
+}
+
+func (clients *azureClients) listNetworkInterfaces(ctx context.Context, resourceGroupName string) ([]*armnetwork.Interface, error) {
+	var result []*armnetwork.Interface
+	pager := clients.iFaceClient.NewListPager(resourceGroupName, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing network interfaces: %w", err)
+		}
+		result = append(result, resp.Value...)
+	}
+	return result, nil
+}
+
+func getAzureTagsMap(tags map[string]*string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			result[k] = *v
+		}
+	}
+
+	return result
+}
+
+// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Virtual Machine Scale Set, or of the
+// Virtual Machines selected by tags. The Virtual Machines of a Virtual Machine Scale Set in the Uniform orchestration
+// mode are only listed if an upstream of the Virtual Machine Scale Set uses their tags or only its instances in service,
+// with their instance views.
+func (client *AzureClient) GetPrivateIPsForScalingGroup(ctx context.Context, name string) ([]Instance, error) {
+	if tags := client.getInstanceTags(name); tags != nil {
+		return client.listInstancesWithTags(ctx, name, tags)
+	}
+
+	clients, resourceGroupName := client.getClients(name)
+	scaleSet, err := client.getScaleSet(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	var iFaces []*armnetwork.Interface
+	var vms map[string]scaleSetVM
+	onlyInService := client.usesInService(name)
+	usesTags := client.usesTags(name)
+
+	if scaleSet.flexible {
+		iFaces, vms, err = clients.listFlexibleScaleSetsNetworkInterfaces(ctx, resourceGroupName, scaleSet, onlyInService)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		iFaces, err = clients.listScaleSetsNetworkInterfaces(ctx, resourceGroupName, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if onlyInService || usesTags {
+			vms, err = clients.listScaleSetVMs(ctx, resourceGroupName, name, onlyInService)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, iFace := range iFaces {
+		ip, ipv6 := getPrimaryIPFromInterface(iFace), getIPv6FromInterface(iFace)
+		if ip == "" && ipv6 == "" {
+			continue
+		}
+
+		vm := vms[strings.ToLower(*iFace.Properties.VirtualMachine.ID)]
+		if onlyInService && !vm.inService {
+			continue
+		}
+		var tags map[string]string
+		if usesTags {
+			tags = vm.tags
+		}
+		instance := newInstance(ip, tags)
+		instance.IPv6 = ipv6
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// listInstancesWithTags returns the Virtual Machines of the resource group of the scaling group that have the tags, or
+// whose network interface has them.
+func (client *AzureClient) listInstancesWithTags(ctx context.Context, name string, tags map[string]string) ([]Instance, error) {
+	clients, resourceGroupName := client.getClients(name)
+	vmsTags, err := clients.listVMsTags(ctx, resourceGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	iFaces, err := clients.listNetworkInterfaces(ctx, resourceGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	for _, iFace := range iFaces {
+		ip, ipv6 := getPrimaryIPFromInterface(iFace), getIPv6FromInterface(iFace)
+		if ip == "" && ipv6 == "" {
+			continue
+		}
+
+		vmTags, ok := vmsTags[strings.ToLower(*iFace.Properties.VirtualMachine.ID)]
+		if ok && (hasTags(vmTags, tags) || hasTags(getAzureTagsMap(iFace.Tags), tags)) {
+			instance := newInstance(ip, vmTags)
+			instance.IPv6 = ipv6
+			instances = append(instances, instance)
+		}
+	}
+
+	return instances, nil
+}
+
+// getInstanceTags returns the instance_tags of the upstreams of the scaling group, or nil if the scaling group is a
+// Virtual Machine Scale Set.
+func (client *AzureClient) getInstanceTags(name string) map[string]string {
+	for _, u := range client.config.Upstreams {
+		if len(u.InstanceTags) > 0 && getInstanceTagsScalingGroup(u.InstanceTags) == name {
+			return u.InstanceTags
+		}
+	}
 
-	return ips, nil
+	return nil
+}
+
+// hasTags returns true if the tags include every selector tag with the same value.
+func hasTags(tags map[string]string, selector map[string]string) bool {
+	for k, v := range selector {
+		if value, ok := tags[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getPrimaryIPFromInterface returns the private IP address of the primary IP configuration of the network interface of
+// a Virtual Machine, or an empty string.
+func getPrimaryIPFromInterface(iFace *armnetwork.Interface) string {
+	if iFace.Properties == nil || iFace.Properties.VirtualMachine == nil || iFace.Properties.VirtualMachine.ID == nil {
+		return ""
+	}
+
+	for _, n := range iFace.Properties.IPConfigurations {
+		if ip := getPrimaryIPFromInterfaceIPConfiguration(n); ip != "" {
+			return ip
+		}
+	}
+
+	return ""
+}
+
+// getIPv6FromInterface returns the private IPv6 address of the first IPv6 configuration of the network interface of a
+// Virtual Machine, or an empty string. The primary IP configuration of a network interface is always an IPv4 one.
+func getIPv6FromInterface(iFace *armnetwork.Interface) string {
+	if iFace.Properties == nil || iFace.Properties.VirtualMachine == nil || iFace.Properties.VirtualMachine.ID == nil {
+		return ""
+	}
+
+	for _, n := range iFace.Properties.IPConfigurations {
+		if n.Properties == nil || n.Properties.PrivateIPAddressVersion == nil || n.Properties.PrivateIPAddress == nil {
+			continue
+		}
+		if *n.Properties.PrivateIPAddressVersion == armnetwork.IPVersionIPv6 {
+			return *n.Properties.PrivateIPAddress
+		}
+	}
+
+	return ""
+}
+
+// usesInService returns true if an upstream of the Virtual Machine Scale Set only uses its instances in service.
+func (client *AzureClient) usesInService(name string) bool {
+	for _, u := range client.config.Upstreams {
+		if u.InService && slices.ContainsFunc(u.getScalingGroups(), func(g ScalingGroup) bool { return g.Name == name }) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// usesTags returns true if an upstream of the Virtual Machine Scale Set sets the server parameters from the tags.
+func (client *AzureClient) usesTags(name string) bool {
+	for _, u := range client.config.Upstreams {
+		if u.ServerParametersFromTags && slices.ContainsFunc(u.getScalingGroups(), func(g ScalingGroup) bool { return g.Name == name }) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func getPrimaryIPFromInterfaceIPConfiguration(ipConfig *armnetwork.InterfaceIPConfiguration) string {
@@ -111,52 +436,175 @@ func getPrimaryIPFromInterfaceIPConfiguration(ipConfig *armnetwork.InterfaceIPCo
 	return *ipConfig.Properties.PrivateIPAddress
 }
 
-// CheckIfScalingGroupExists checks if the Virtual Machine Scale Set exists.
-func (client *AzureClient) CheckIfScalingGroupExists(name string) (bool, error) {
-	ctx := context.TODO()
+// CheckIfScalingGroupExists checks if the Virtual Machine Scale Set exists, or if any Virtual Machine has the tags of the
+// scaling group of the Virtual Machines selected by tags.
+func (client *AzureClient) CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error) {
+	if tags := client.getInstanceTags(name); tags != nil {
+		instances, err := client.listInstancesWithTags(ctx, name, tags)
+		if err != nil {
+			return false, fmt.Errorf("couldn't check if Virtual Machines have the instance_tags: %w", err)
+		}
+
+		return len(instances) > 0, nil
+	}
+
+	clients, resourceGroupName := client.getClients(name)
 	expandType := armcompute.ExpandTypesForGetVMScaleSetsUserData
-	vmss, err := client.vMSSClient.Get(ctx, client.config.ResourceGroupName, name, &armcompute.VirtualMachineScaleSetsClientGetOptions{Expand: &expandType})
+	vmss, err := clients.vMSSClient.Get(ctx, resourceGroupName, name, &armcompute.VirtualMachineScaleSetsClientGetOptions{Expand: &expandType})
 	if err != nil {
 		return false, fmt.Errorf("couldn't check if a Virtual Machine Scale Set exists: %w", err)
 	}
+	client.setScaleSet(name, vmss.VirtualMachineScaleSet)
 
 	return vmss.ID != nil, nil
 }
 
-func (client *AzureClient) configure() error {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return fmt.Errorf("couldn't create authorizer: %w", err)
+// getScaleSet returns the Virtual Machine Scale Set, which is only fetched if CheckIfScalingGroupExists didn't fetch it.
+func (client *AzureClient) getScaleSet(ctx context.Context, name string) (azureScaleSet, error) {
+	client.mu.Lock()
+	scaleSet, ok := client.scaleSets[name]
+	client.mu.Unlock()
+	if ok {
+		return scaleSet, nil
 	}
 
-	computeClientFactory, err := armcompute.NewClientFactory(client.config.SubscriptionID, cred, nil)
+	clients, resourceGroupName := client.getClients(name)
+	vmss, err := clients.vMSSClient.Get(ctx, resourceGroupName, name, nil)
 	if err != nil {
-		return fmt.Errorf("couldn't create client factory: %w", err)
+		return azureScaleSet{}, fmt.Errorf("couldn't get the Virtual Machine Scale Set: %w", err)
+	}
+
+	return client.setScaleSet(name, vmss.VirtualMachineScaleSet), nil
+}
+
+// setScaleSet caches the Virtual Machine Scale Set with its orchestration mode, which is Uniform if it isn't set.
+func (client *AzureClient) setScaleSet(name string, vmss armcompute.VirtualMachineScaleSet) azureScaleSet {
+	if vmss.ID == nil {
+		return azureScaleSet{}
+	}
+
+	scaleSet := azureScaleSet{id: *vmss.ID}
+	if vmss.Properties != nil && vmss.Properties.OrchestrationMode != nil {
+		scaleSet.flexible = *vmss.Properties.OrchestrationMode == armcompute.OrchestrationModeFlexible
 	}
-	client.vMSSClient = computeClientFactory.NewVirtualMachineScaleSetsClient()
 
-	iclient, err := armnetwork.NewInterfacesClient(client.config.SubscriptionID, cred, nil)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.scaleSets == nil {
+		client.scaleSets = make(map[string]azureScaleSet)
+	}
+	client.scaleSets[name] = scaleSet
+
+	return scaleSet
+}
+
+func (client *AzureClient) configure(ctx context.Context) error {
+	cred, err := newAzureCredential(client.config.Credential)
 	if err != nil {
-		return fmt.Errorf("couldn't create interfaces client: %w", err)
+		return fmt.Errorf("couldn't create authorizer: %w", err)
+	}
+
+	// the default credential chain might only authenticate later, for example once a managed identity is assigned
+	if client.config.Credential != nil {
+		err = checkAzureCredential(ctx, cred, client.config.Credential.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.createClients(cred, nil)
+}
+
+// createClients creates the Azure API clients of every subscription of the upstreams with the credential and the client
+// options.
+func (client *AzureClient) createClients(cred azcore.TokenCredential, options *arm.ClientOptions) error {
+	client.clients = make(map[string]*azureClients)
+	for _, u := range client.config.Upstreams {
+		subscriptionID, _ := getAzureScaleSetLocation(client.config, u)
+		if _, ok := client.clients[subscriptionID]; ok {
+			continue
+		}
+
+		computeClientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, options)
+		if err != nil {
+			return fmt.Errorf("couldn't create client factory for the subscription %v: %w", subscriptionID, err)
+		}
+
+		iclient, err := armnetwork.NewInterfacesClient(subscriptionID, cred, options)
+		if err != nil {
+			return fmt.Errorf("couldn't create interfaces client for the subscription %v: %w", subscriptionID, err)
+		}
+
+		client.clients[subscriptionID] = &azureClients{
+			vMSSClient:    computeClientFactory.NewVirtualMachineScaleSetsClient(),
+			vMSSVMsClient: computeClientFactory.NewVirtualMachineScaleSetVMsClient(),
+			vMClient:      computeClientFactory.NewVirtualMachinesClient(),
+			iFaceClient:   iclient,
+		}
 	}
-	client.iFaceClient = iclient
 
 	return nil
 }
 
+// getClients returns the Azure API clients of the subscription and the resource group of the scaling group.
+func (client *AzureClient) getClients(name string) (*azureClients, string) {
+	subscriptionID, resourceGroupName := client.getScalingGroupLocation(name)
+	return client.clients[subscriptionID], resourceGroupName
+}
+
+// getScalingGroupLocation returns the subscription ID and the resource group name of the scaling group, which are those
+// of the first upstream of the scaling group.
+func (client *AzureClient) getScalingGroupLocation(name string) (string, string) {
+	for _, u := range client.config.Upstreams {
+		if slices.ContainsFunc(u.getScalingGroups(), func(g ScalingGroup) bool { return g.Name == name }) {
+			return getAzureScaleSetLocation(client.config, u)
+		}
+	}
+
+	return client.config.SubscriptionID, client.config.ResourceGroupName
+}
+
+// GetScalingGroupLocation returns the subscription and the resource group in which the scaling group is looked up.
+func (client *AzureClient) GetScalingGroupLocation(name string) string {
+	subscriptionID, resourceGroupName := client.getScalingGroupLocation(name)
+	return subscriptionID + "/" + resourceGroupName
+}
+
+// getAzureScaleSetLocation returns the subscription ID and the resource group name of the scaling groups of the upstream,
+// which override the top-level ones.
+func getAzureScaleSetLocation(cfg *azureConfig, u azureUpstream) (string, string) {
+	subscriptionID, resourceGroupName := cfg.SubscriptionID, cfg.ResourceGroupName
+	if u.SubscriptionID != "" {
+		subscriptionID = u.SubscriptionID
+	}
+	if u.ResourceGroupName != "" {
+		resourceGroupName = u.ResourceGroupName
+	}
+
+	return subscriptionID, resourceGroupName
+}
+
 // GetUpstreams returns the Upstreams list.
 func (client *AzureClient) GetUpstreams() []Upstream {
 	upstreams := make([]Upstream, 0, len(client.config.Upstreams))
 	for i := range len(client.config.Upstreams) {
 		u := Upstream{
-			Name:         client.config.Upstreams[i].Name,
-			Port:         client.config.Upstreams[i].Port,
-			Kind:         client.config.Upstreams[i].Kind,
-			ScalingGroup: client.config.Upstreams[i].VMScaleSet,
-			MaxConns:     &client.config.Upstreams[i].MaxConns,
-			MaxFails:     &client.config.Upstreams[i].MaxFails,
-			FailTimeout:  getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
-			SlowStart:    getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			Name:                     client.config.Upstreams[i].Name,
+			Port:                     client.config.Upstreams[i].Port,
+			Kind:                     client.config.Upstreams[i].Kind,
+			ScalingGroups:            client.config.Upstreams[i].getScalingGroups(),
+			MaxConns:                 &client.config.Upstreams[i].MaxConns,
+			MaxFails:                 &client.config.Upstreams[i].MaxFails,
+			FailTimeout:              getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
+			SlowStart:                getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			DrainTimeout:             client.config.Upstreams[i].DrainTimeout,
+			StaleAfter:               client.config.Upstreams[i].StaleAfter,
+			StalePolicy:              client.config.Upstreams[i].StalePolicy,
+			MinServers:               client.config.Upstreams[i].MinServers,
+			MaxRemovalPercent:        client.config.Upstreams[i].MaxRemovalPercent,
+			ServerParametersFromTags: client.config.Upstreams[i].ServerParametersFromTags,
+			AddressFamily:            getAddressFamilyOrDefault(client.config.Upstreams[i].AddressFamily),
+			InService:                client.config.Upstreams[i].InService,
 		}
 		upstreams = append(upstreams, u)
 	}
@@ -164,60 +612,127 @@ func (client *AzureClient) GetUpstreams() []Upstream {
 }
 
 type azureConfig struct {
-	SubscriptionID    string          `yaml:"subscription_id"`
-	ResourceGroupName string          `yaml:"resource_group_name"`
-	Upstreams         []azureUpstream `yaml:"upstreams"`
+	// Credential selects the credential used to call the Azure APIs, instead of the default credential chain.
+	Credential        *azureCredentialConfig `yaml:"credential"`
+	SubscriptionID    string                 `yaml:"subscription_id"`
+	ResourceGroupName string                 `yaml:"resource_group_name"`
+	Upstreams         []azureUpstream        `yaml:"upstreams"`
 }
 
 type azureUpstream struct {
-	Name        string `yaml:"name"`
-	VMScaleSet  string `yaml:"virtual_machine_scale_set"`
-	Kind        string `yaml:"kind"`
-	FailTimeout string `yaml:"fail_timeout"`
-	SlowStart   string `yaml:"slow_start"`
-	Port        int    `yaml:"port"`
-	MaxConns    int    `yaml:"max_conns"`
-	MaxFails    int    `yaml:"max_fails"`
+	// InstanceTags select the Virtual Machines of the upstream by their tags, instead of VMScaleSet.
+	InstanceTags map[string]string `yaml:"instance_tags"`
+	// StaleAfter and StalePolicy override the top-level ones for the upstream.
+	StaleAfter *time.Duration `yaml:"stale_after"`
+	Name       string         `yaml:"name"`
+	// SubscriptionID and ResourceGroupName override the top-level ones for the scaling groups of the upstream.
+	SubscriptionID    string `yaml:"subscription_id"`
+	ResourceGroupName string `yaml:"resource_group_name"`
+	VMScaleSet        string `yaml:"virtual_machine_scale_set"`
+	Kind              string `yaml:"kind"`
+	FailTimeout       string `yaml:"fail_timeout"`
+	SlowStart         string `yaml:"slow_start"`
+	AddressFamily     string `yaml:"address_family"`
+	StalePolicy       string `yaml:"stale_policy"`
+	// VMScaleSets are the Virtual Machine Scale Sets of the upstream, instead of VMScaleSet.
+	VMScaleSets              []scalingGroupConfig `yaml:"virtual_machine_scale_sets"`
+	Port                     int                  `yaml:"port"`
+	MaxConns                 int                  `yaml:"max_conns"`
+	MaxFails                 int                  `yaml:"max_fails"`
+	MinServers               int                  `yaml:"min_servers"`
+	MaxRemovalPercent        int                  `yaml:"max_removal_percent"`
+	DrainTimeout             time.Duration        `yaml:"drain_timeout"`
+	InService                bool                 `yaml:"in_service"`
+	ServerParametersFromTags bool                 `yaml:"server_parameters_from_tags"`
+}
+
+// getScalingGroups returns the Virtual Machine Scale Sets of the upstream.
+func (u azureUpstream) getScalingGroups() []ScalingGroup {
+	return getScalingGroups(u.VMScaleSet, u.VMScaleSets, u.InstanceTags)
 }
 
 func validateAzureConfig(cfg *azureConfig) error {
-	if cfg.SubscriptionID == "" {
-		return fmt.Errorf(errorMsgFormat, "subscription_id")
+	var errs []error
+
+	// the top-level fields are only mandatory for the upstreams that don't set their own
+	if cfg.SubscriptionID == "" && (len(cfg.Upstreams) == 0 || slices.ContainsFunc(cfg.Upstreams, func(u azureUpstream) bool { return u.SubscriptionID == "" })) {
+		errs = append(errs, fmt.Errorf(errorMsgFormat, "subscription_id"))
 	}
 
-	if cfg.ResourceGroupName == "" {
-		return fmt.Errorf(errorMsgFormat, "resource_group_name")
+	if cfg.ResourceGroupName == "" && (len(cfg.Upstreams) == 0 || slices.ContainsFunc(cfg.Upstreams, func(u azureUpstream) bool { return u.ResourceGroupName == "" })) {
+		errs = append(errs, fmt.Errorf(errorMsgFormat, "resource_group_name"))
+	}
+
+	if cfg.Credential != nil {
+		errs = append(errs, validateAzureCredentialConfig(cfg.Credential)...)
 	}
 
 	if len(cfg.Upstreams) == 0 {
-		return errors.New("there are no upstreams found in the config file")
+		errs = append(errs, errors.New("there are no upstreams found in the config file"))
 	}
 
-	for _, ups := range cfg.Upstreams {
+	for i, ups := range cfg.Upstreams {
 		if ups.Name == "" {
-			return errors.New(upstreamNameErrorMsg)
+			errs = append(errs, errors.New(upstreamNameErrorMsg))
 		}
-		if ups.VMScaleSet == "" {
-			return fmt.Errorf(upstreamErrorMsgFormat, "virtual_machine_scale_set", ups.Name)
+		if slices.ContainsFunc(cfg.Upstreams[:i], func(u azureUpstream) bool { return u.Name == ups.Name }) {
+			errs = append(errs, fmt.Errorf(upstreamDuplicateNameErrorMsgFmt, ups.Name))
+		}
+		errs = append(errs, validateScalingGroups(ups.Name, "virtual_machine_scale_set", "virtual_machine_scale_sets", ups.VMScaleSet, ups.VMScaleSets, ups.InstanceTags)...)
+		// the scaling groups are looked up by name, in the subscription and the resource group of the first upstream
+		for _, group := range ups.getScalingGroups() {
+			if slices.ContainsFunc(cfg.Upstreams[:i], func(u azureUpstream) bool {
+				subscriptionID, resourceGroupName := getAzureScaleSetLocation(cfg, u)
+				upsSubscriptionID, upsResourceGroupName := getAzureScaleSetLocation(cfg, ups)
+				return slices.ContainsFunc(u.getScalingGroups(), func(g ScalingGroup) bool { return g.Name == group.Name }) &&
+					(subscriptionID != upsSubscriptionID || resourceGroupName != upsResourceGroupName)
+			}) {
+				errs = append(errs, fmt.Errorf(upstreamScaleSetConflictErrorMsgFmt, ups.Name, group.Name))
+			}
+		}
+		// the instance views are those of the Virtual Machine Scale Sets
+		if ups.InService && len(ups.InstanceTags) > 0 {
+			errs = append(errs, fmt.Errorf(upstreamInServiceInstanceTagsErrorMsgFmt, ups.Name))
 		}
 		if ups.Port == 0 {
-			return fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name)
+			errs = append(errs, fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name))
 		}
 		if ups.Kind == "" || !(ups.Kind == "http" || ups.Kind == "stream") {
-			return fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name)
+			errs = append(errs, fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name))
 		}
 		if ups.MaxConns < 0 {
-			return fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns)
+			errs = append(errs, fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns))
 		}
 		if ups.MaxFails < 0 {
-			return fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails)
+			errs = append(errs, fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails))
 		}
 		if !isValidTime(ups.FailTimeout) {
-			return fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout)
+			errs = append(errs, fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout))
 		}
 		if !isValidTime(ups.SlowStart) {
-			return fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart)
+			errs = append(errs, fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart))
+		}
+		if !isValidAddressFamily(ups.AddressFamily) {
+			errs = append(errs, fmt.Errorf(upstreamAddressFamilyErrorMsgFmt, ups.AddressFamily, ups.Name))
+		}
+		if ups.MinServers < 0 {
+			errs = append(errs, fmt.Errorf(upstreamMinServersErrorMsgFmt, ups.MinServers))
+		}
+		if ups.MaxRemovalPercent < 0 || ups.MaxRemovalPercent > 100 {
+			errs = append(errs, fmt.Errorf(upstreamMaxRemovalPercentErrorMsgFmt, ups.MaxRemovalPercent))
+		}
+		if ups.DrainTimeout < 0 {
+			errs = append(errs, fmt.Errorf(upstreamDrainTimeoutErrorMsgFmt, ups.DrainTimeout))
+		}
+		if ups.DrainTimeout > 0 && ups.Kind == "stream" {
+			errs = append(errs, fmt.Errorf(upstreamDrainTimeoutKindErrorMsgFmt, ups.Name))
+		}
+		if ups.StaleAfter != nil && *ups.StaleAfter < 0 {
+			errs = append(errs, fmt.Errorf(upstreamStaleAfterErrorMsgFmt, *ups.StaleAfter, ups.Name))
+		}
+		if ups.StalePolicy != "" && ups.StalePolicy != stalePolicyKeep && ups.StalePolicy != stalePolicyEmpty {
+			errs = append(errs, fmt.Errorf(upstreamStalePolicyErrorMsgFmt, ups.StalePolicy, ups.Name))
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	network "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type testInputAzure struct {
@@ -40,6 +46,10 @@ func getInvalidAzureConfigInput() []*testInputAzure {
 	invalidResourceGroupNameCfg.ResourceGroupName = ""
 	input = append(input, &testInputAzure{invalidResourceGroupNameCfg, "invalid resource group name"})
 
+	invalidCredentialCfg := getValidAzureConfig()
+	invalidCredentialCfg.Credential = &azureCredentialConfig{Type: azureCredentialServicePrincipal}
+	input = append(input, &testInputAzure{invalidCredentialCfg, "invalid credential"})
+
 	invalidMissingUpstreamsCfg := getValidAzureConfig()
 	invalidMissingUpstreamsCfg.Upstreams = nil
 	input = append(input, &testInputAzure{invalidMissingUpstreamsCfg, "no upstreams"})
@@ -52,6 +62,38 @@ func getInvalidAzureConfigInput() []*testInputAzure {
 	invalidUpstreamVMMSetCfg.Upstreams[0].VMScaleSet = ""
 	input = append(input, &testInputAzure{invalidUpstreamVMMSetCfg, "invalid virtual_machine_scale_set of the upstream"})
 
+	bothUpstreamVMSSetsCfg := getValidAzureConfig()
+	bothUpstreamVMSSetsCfg.Upstreams[0].VMScaleSets = []scalingGroupConfig{{Name: "backend-group-green"}}
+	input = append(input, &testInputAzure{bothUpstreamVMSSetsCfg, "both virtual_machine_scale_set and virtual_machine_scale_sets of the upstream"})
+
+	duplicateUpstreamNameCfg := getValidAzureConfig()
+	duplicateUpstreamNameCfg.Upstreams = append(duplicateUpstreamNameCfg.Upstreams, duplicateUpstreamNameCfg.Upstreams[0])
+	input = append(input, &testInputAzure{duplicateUpstreamNameCfg, "duplicate name of the upstream"})
+
+	invalidUpstreamInstanceTagsCfg := getValidAzureConfig()
+	invalidUpstreamInstanceTagsCfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	input = append(input, &testInputAzure{invalidUpstreamInstanceTagsCfg, "both virtual_machine_scale_set and instance_tags of the upstream"})
+
+	invalidUpstreamInServiceInstanceTagsCfg := getValidAzureConfig()
+	invalidUpstreamInServiceInstanceTagsCfg.Upstreams[0].VMScaleSet = ""
+	invalidUpstreamInServiceInstanceTagsCfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	invalidUpstreamInServiceInstanceTagsCfg.Upstreams[0].InService = true
+	input = append(input, &testInputAzure{invalidUpstreamInServiceInstanceTagsCfg, "both in_service and instance_tags of the upstream"})
+
+	conflictingUpstreamResourceGroupCfg := getValidAzureConfig()
+	conflictingUpstreamResourceGroupCfg.Upstreams = append(conflictingUpstreamResourceGroupCfg.Upstreams, conflictingUpstreamResourceGroupCfg.Upstreams[0])
+	conflictingUpstreamResourceGroupCfg.Upstreams[1].Name = "backend2"
+	conflictingUpstreamResourceGroupCfg.Upstreams[1].ResourceGroupName = "other_resource_group_name"
+	input = append(input, &testInputAzure{conflictingUpstreamResourceGroupCfg, "the same virtual_machine_scale_set in different resource groups"})
+
+	missingUpstreamSubscriptionCfg := getValidAzureConfig()
+	missingUpstreamSubscriptionCfg.SubscriptionID = ""
+	missingUpstreamSubscriptionCfg.Upstreams = append(missingUpstreamSubscriptionCfg.Upstreams, missingUpstreamSubscriptionCfg.Upstreams[0])
+	missingUpstreamSubscriptionCfg.Upstreams[0].SubscriptionID = "other_subscription_id"
+	missingUpstreamSubscriptionCfg.Upstreams[1].Name = "backend2"
+	missingUpstreamSubscriptionCfg.Upstreams[1].VMScaleSet = "backend-group-2"
+	input = append(input, &testInputAzure{missingUpstreamSubscriptionCfg, "no subscription id for an upstream"})
+
 	invalidUpstreamPortCfg := getValidAzureConfig()
 	invalidUpstreamPortCfg.Upstreams[0].Port = 0
 	input = append(input, &testInputAzure{invalidUpstreamPortCfg, "invalid port of the upstream"})
@@ -76,6 +118,36 @@ func getInvalidAzureConfigInput() []*testInputAzure {
 	invalidUpstreamSlowStartCfg.Upstreams[0].SlowStart = "-10s"
 	input = append(input, &testInputAzure{invalidUpstreamSlowStartCfg, "invalid slow_start of the upstream"})
 
+	invalidUpstreamStaleAfter := -time.Minute
+	invalidUpstreamStaleAfterCfg := getValidAzureConfig()
+	invalidUpstreamStaleAfterCfg.Upstreams[0].StaleAfter = &invalidUpstreamStaleAfter
+	input = append(input, &testInputAzure{invalidUpstreamStaleAfterCfg, "invalid stale_after of the upstream"})
+
+	invalidUpstreamStalePolicyCfg := getValidAzureConfig()
+	invalidUpstreamStalePolicyCfg.Upstreams[0].StalePolicy = "remove"
+	input = append(input, &testInputAzure{invalidUpstreamStalePolicyCfg, "invalid stale_policy of the upstream"})
+
+	invalidUpstreamAddressFamilyCfg := getValidAzureConfig()
+	invalidUpstreamAddressFamilyCfg.Upstreams[0].AddressFamily = "ipv5"
+	input = append(input, &testInputAzure{invalidUpstreamAddressFamilyCfg, "invalid address_family of the upstream"})
+
+	invalidUpstreamMinServersCfg := getValidAzureConfig()
+	invalidUpstreamMinServersCfg.Upstreams[0].MinServers = -1
+	input = append(input, &testInputAzure{invalidUpstreamMinServersCfg, "invalid min_servers of the upstream"})
+
+	invalidUpstreamMaxRemovalPercentCfg := getValidAzureConfig()
+	invalidUpstreamMaxRemovalPercentCfg.Upstreams[0].MaxRemovalPercent = 101
+	input = append(input, &testInputAzure{invalidUpstreamMaxRemovalPercentCfg, "invalid max_removal_percent of the upstream"})
+
+	invalidUpstreamDrainTimeoutCfg := getValidAzureConfig()
+	invalidUpstreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = -10 * time.Second
+	input = append(input, &testInputAzure{invalidUpstreamDrainTimeoutCfg, "invalid drain_timeout of the upstream"})
+
+	invalidUpstreamDrainTimeoutKindCfg := getValidAzureConfig()
+	invalidUpstreamDrainTimeoutKindCfg.Upstreams[0].Kind = "stream"
+	invalidUpstreamDrainTimeoutKindCfg.Upstreams[0].DrainTimeout = 10 * time.Second
+	input = append(input, &testInputAzure{invalidUpstreamDrainTimeoutKindCfg, "drain_timeout for a stream upstream"})
+
 	return input
 }
 
@@ -101,6 +173,20 @@ func TestValidateAzureConfigValid(t *testing.T) {
 	}
 }
 
+func TestValidateAzureConfigValidUpstreamSubscription(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAzureConfig()
+	cfg.SubscriptionID = ""
+	cfg.ResourceGroupName = ""
+	cfg.Upstreams[0].SubscriptionID = "subscription_id"
+	cfg.Upstreams[0].ResourceGroupName = "resource_group_name"
+
+	err := validateAzureConfig(cfg)
+	if err != nil {
+		t.Errorf("validateAzureConfig() failed for the subscription_id and resource_group_name of the upstream: %v", err)
+	}
+}
+
 func TestGetPrimaryIPFromInterfaceIPConfiguration(t *testing.T) {
 	t.Parallel()
 	primary := true
@@ -226,3 +312,287 @@ func areEqualUpstreamsAzure(u1 azureUpstream, u2 Upstream) bool {
 
 	return true
 }
+
+func TestGetPrivateIPsForScalingGroupAzure(t *testing.T) {
+	t.Parallel()
+	api := newFakeAzureAPI()
+	api.scaleSets["backend-group"] = []fakeAzureVM{
+		{ip: "10.0.0.1", tags: map[string]string{weightTag: "3", routeTag: "a"}},
+		{ip: "10.0.0.2", tags: map[string]string{backupTag: "true"}},
+	}
+	weight := 3
+	backup := true
+
+	tests := []struct {
+		msg                      string
+		expected                 []Instance
+		serverParametersFromTags bool
+		expectedVMListCalls      int
+	}{
+		{
+			msg:      "tags not used",
+			expected: []Instance{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+		},
+		{
+			msg:                      "server_parameters_from_tags",
+			serverParametersFromTags: true,
+			expected:                 []Instance{{IP: "10.0.0.1", Weight: &weight, Route: "a"}, {IP: "10.0.0.2", Backup: &backup}},
+			expectedVMListCalls:      1,
+		},
+	}
+
+	for _, test := range tests {
+		api.vmListCalls = 0
+		cfg := getValidAzureConfig()
+		cfg.Upstreams[0].ServerParametersFromTags = test.serverParametersFromTags
+		client := newTestAzureClient(t, api, cfg)
+
+		instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+		if err != nil {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned an unexpected error for %v: %v", test.msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(instances, test.expected) {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for %v, expected %+v", instances, test.msg, test.expected)
+		}
+		if api.vmListCalls != test.expectedVMListCalls {
+			t.Errorf("the Virtual Machines were listed %v times for %v, expected %v", api.vmListCalls, test.msg, test.expectedVMListCalls)
+		}
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAzureInstanceTags(t *testing.T) {
+	t.Parallel()
+	api := newFakeAzureAPI()
+	api.scaleSets["backend-group"] = []fakeAzureVM{{ip: "10.0.0.1", tags: map[string]string{"role": "backend"}}}
+	api.vms = []fakeAzureVM{
+		{ip: "10.0.1.1", tags: map[string]string{"role": "backend", weightTag: "2"}},
+		{ip: "10.0.1.2", nicTags: map[string]string{"role": "backend"}},
+		{ip: "10.0.1.3", tags: map[string]string{"role": "frontend"}},
+	}
+
+	cfg := getValidAzureConfig()
+	cfg.Upstreams[0].VMScaleSet = ""
+	cfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	client := newTestAzureClient(t, api, cfg)
+
+	name := client.GetUpstreams()[0].ScalingGroups[0].Name
+	exists, err := client.CheckIfScalingGroupExists(context.Background(), name)
+	if err != nil || !exists {
+		t.Errorf("CheckIfScalingGroupExists() returned %v, %v for the instance_tags, expected true", exists, err)
+	}
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), name)
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed for the instance_tags: %v", err)
+	}
+
+	weight := 2
+	expected := []Instance{{IP: "10.0.1.1", Weight: &weight}, {IP: "10.0.1.2"}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for the instance_tags, expected %+v", instances, expected)
+	}
+}
+
+func TestSyncOnceAzureInstanceTagsNoMatch(t *testing.T) {
+	t.Parallel()
+	api := newFakeAzureAPI()
+	api.vms = []fakeAzureVM{
+		{ip: "10.0.1.1", tags: map[string]string{"role": "backend"}},
+		{ip: "10.0.1.3", tags: map[string]string{"role": "frontend"}},
+	}
+
+	cfg := getValidAzureConfig()
+	cfg.Upstreams[0].VMScaleSet = ""
+	cfg.Upstreams[0].InstanceTags = map[string]string{"role": "backend"}
+	client := newTestAzureClient(t, api, cfg)
+
+	nginxAPI := newFakeNginxPlusAPI()
+	nginxAPI.addUpstream("http", "backend1")
+	syncer := NewSyncer(client, newTestNginxEndpoints(t, nginxAPI), NewMetrics(prometheus.NewRegistry()))
+
+	if result := syncer.SyncOnce(context.Background())[0]; result.Err != nil {
+		t.Fatalf("SyncOnce() failed: %v", result.Err)
+	}
+
+	// the last Virtual Machine with the tags is deleted
+	api.vms = api.vms[1:]
+
+	name := client.GetUpstreams()[0].ScalingGroups[0].Name
+	exists, err := client.CheckIfScalingGroupExists(context.Background(), name)
+	if err != nil || exists {
+		t.Errorf("CheckIfScalingGroupExists() returned %v, %v for the instance_tags without instances, expected false", exists, err)
+	}
+
+	result := syncer.SyncOnce(context.Background())[0]
+	if result.Err != nil {
+		t.Errorf("SyncOnce() returned an error for the instance_tags without instances: %v", result.Err)
+	}
+	if result.Fallback != "" {
+		t.Errorf("SyncOnce() used the fallback %q for the instance_tags without instances", result.Fallback)
+	}
+	if got := nginxAPI.servers("http", "backend1"); len(got) != 0 {
+		t.Errorf("the upstream has servers %v after the last Virtual Machine with the tags was deleted, expected none", got)
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAzureInService(t *testing.T) {
+	t.Parallel()
+	running := []string{provisioningSucceededCode, powerStateRunningCode}
+	api := newFakeAzureAPI()
+	api.scaleSets["backend-group"] = []fakeAzureVM{
+		{ip: "10.0.0.1", statuses: running},
+		{ip: "10.0.0.2", statuses: []string{"ProvisioningState/succeeded", "PowerState/deallocated"}},
+		{ip: "10.0.0.3", statuses: []string{"ProvisioningState/updating", "PowerState/running"}},
+		{ip: "10.0.0.4", statuses: running, health: "HealthState/unhealthy"},
+		{ip: "10.0.0.5", statuses: running, health: "HealthState/Healthy"},
+		{ip: "10.0.0.6"},
+	}
+
+	tests := []struct {
+		msg       string
+		expected  []Instance
+		inService bool
+	}{
+		{
+			msg:      "in_service not set",
+			expected: []Instance{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.3"}, {IP: "10.0.0.4"}, {IP: "10.0.0.5"}, {IP: "10.0.0.6"}},
+		},
+		{
+			msg:       "in_service",
+			inService: true,
+			expected:  []Instance{{IP: "10.0.0.1"}, {IP: "10.0.0.5"}},
+		},
+	}
+
+	for _, test := range tests {
+		cfg := getValidAzureConfig()
+		cfg.Upstreams[0].InService = test.inService
+		client := newTestAzureClient(t, api, cfg)
+
+		instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+		if err != nil {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned an unexpected error for %v: %v", test.msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(instances, test.expected) {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for %v, expected %+v", instances, test.msg, test.expected)
+		}
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAzureFlexible(t *testing.T) {
+	t.Parallel()
+	running := []string{provisioningSucceededCode, powerStateRunningCode}
+	api := newFakeAzureAPI()
+	api.scaleSets["backend-group"] = []fakeAzureVM{
+		{ip: "10.0.0.1", statuses: running, tags: map[string]string{weightTag: "3"}},
+		{ip: "10.0.0.2", statuses: []string{provisioningSucceededCode, "PowerState/stopped"}},
+	}
+	api.flexibleScaleSets["backend-group"] = true
+	api.scaleSets["other-group"] = []fakeAzureVM{{ip: "10.0.1.1", statuses: running}}
+	api.flexibleScaleSets["other-group"] = true
+	api.vms = []fakeAzureVM{{ip: "10.0.2.1"}}
+	weight := 3
+
+	tests := []struct {
+		msg                      string
+		expected                 []Instance
+		inService                bool
+		serverParametersFromTags bool
+	}{
+		{
+			msg:      "in_service not set",
+			expected: []Instance{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+		},
+		{
+			msg:                      "in_service and server_parameters_from_tags",
+			inService:                true,
+			serverParametersFromTags: true,
+			expected:                 []Instance{{IP: "10.0.0.1", Weight: &weight}},
+		},
+	}
+
+	for _, test := range tests {
+		api.scaleSetGetCalls = 0
+		cfg := getValidAzureConfig()
+		cfg.Upstreams[0].InService = test.inService
+		cfg.Upstreams[0].ServerParametersFromTags = test.serverParametersFromTags
+		client := newTestAzureClient(t, api, cfg)
+
+		exists, err := client.CheckIfScalingGroupExists(context.Background(), "backend-group")
+		if err != nil || !exists {
+			t.Errorf("CheckIfScalingGroupExists() returned %v, %v for %v, expected true", exists, err, test.msg)
+		}
+
+		instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+		if err != nil {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned an unexpected error for %v: %v", test.msg, err)
+			continue
+		}
+		slices.SortFunc(instances, func(a, b Instance) int { return strings.Compare(a.IP, b.IP) })
+		if !reflect.DeepEqual(instances, test.expected) {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for %v, expected %+v", instances, test.msg, test.expected)
+		}
+		if api.scaleSetGetCalls != 1 {
+			t.Errorf("the Virtual Machine Scale Set was fetched %v times for %v, expected 1", api.scaleSetGetCalls, test.msg)
+		}
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAzureUpstreamSubscription(t *testing.T) {
+	t.Parallel()
+	api := newFakeAzureAPI()
+	api.scaleSets["backend-group"] = []fakeAzureVM{{ip: "10.0.0.1"}}
+	api.scaleSets["other-group"] = []fakeAzureVM{{ip: "10.0.1.1"}}
+
+	cfg := getValidAzureConfig()
+	cfg.Upstreams = append(cfg.Upstreams, azureUpstream{
+		Name:              "backend2",
+		VMScaleSet:        "other-group",
+		SubscriptionID:    "other_subscription_id",
+		ResourceGroupName: "other_resource_group_name",
+		Port:              80,
+		Kind:              "http",
+	})
+	client := newTestAzureClient(t, api, cfg)
+
+	for _, name := range []string{"backend-group", "other-group"} {
+		exists, err := client.CheckIfScalingGroupExists(context.Background(), name)
+		if err != nil || !exists {
+			t.Errorf("CheckIfScalingGroupExists() returned %v, %v for %v, expected true", exists, err, name)
+		}
+		_, err = client.GetPrivateIPsForScalingGroup(context.Background(), name)
+		if err != nil {
+			t.Errorf("GetPrivateIPsForScalingGroup() returned an unexpected error for %v: %v", name, err)
+		}
+	}
+
+	expected := map[string]string{
+		"resource_group_name":       "subscription_id",
+		"other_resource_group_name": "other_subscription_id",
+	}
+	if !reflect.DeepEqual(api.subscriptions, expected) {
+		t.Errorf("the Azure API was called for the resource groups and subscriptions %v, expected %v", api.subscriptions, expected)
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupAzureIPv6(t *testing.T) {
+	t.Parallel()
+	api := newFakeAzureAPI()
+	api.scaleSets["backend-group"] = []fakeAzureVM{
+		{ip: "10.0.0.1", ipv6: "fd00::1"},
+		{ip: "10.0.0.2"},
+	}
+	client := newTestAzureClient(t, api, getValidAzureConfig())
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed for the IPv6 addresses: %v", err)
+	}
+
+	expected := []Instance{{IP: "10.0.0.1", IPv6: "fd00::1"}, {IP: "10.0.0.2"}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for the IPv6 addresses, expected %+v", instances, expected)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// azureManagementScope is the scope of the tokens of the Azure Resource Manager APIs.
+const azureManagementScope = "https://management.azure.com/.default"
+
+// The types of azureCredentialConfig.
+const (
+	azureCredentialManagedIdentity  = "managed_identity"
+	azureCredentialServicePrincipal = "service_principal"
+	azureCredentialWorkloadIdentity = "workload_identity"
+	azureCredentialCLI              = "cli"
+)
+
+// azureCredentialConfig selects the credential used to call the Azure APIs, instead of the default credential chain.
+type azureCredentialConfig struct {
+	Type     string `yaml:"type"`
+	ClientID string `yaml:"client_id"`
+	TenantID string `yaml:"tenant_id"`
+	// ClientSecret or ClientCertificatePath authenticate the service principal.
+	ClientSecret              string `yaml:"client_secret"`
+	ClientCertificatePath     string `yaml:"client_certificate_path"`
+	ClientCertificatePassword string `yaml:"client_certificate_password"`
+	// TokenFilePath is the file of the federated token of the workload identity.
+	TokenFilePath string `yaml:"token_file_path"`
+}
+
+func validateAzureCredentialConfig(cfg *azureCredentialConfig) []error {
+	var errs []error
+
+	switch cfg.Type {
+	case azureCredentialManagedIdentity, azureCredentialWorkloadIdentity, azureCredentialCLI:
+	case azureCredentialServicePrincipal:
+		if cfg.TenantID == "" {
+			errs = append(errs, fmt.Errorf(azureCredentialFieldErrorMsgFmt, "tenant_id", cfg.Type))
+		}
+		if cfg.ClientID == "" {
+			errs = append(errs, fmt.Errorf(azureCredentialFieldErrorMsgFmt, "client_id", cfg.Type))
+		}
+		if (cfg.ClientSecret == "") == (cfg.ClientCertificatePath == "") {
+			errs = append(errs, errors.New(azureCredentialSecretErrorMsg))
+		}
+	default:
+		errs = append(errs, fmt.Errorf(azureCredentialTypeErrorMsgFmt, cfg.Type))
+	}
+
+	return errs
+}
+
+// newAzureCredential creates the credential of the config, or the default credential chain if the config is nil. The
+// workload identity and the cli use the environment variables of the default credential chain for the fields that
+// aren't set.
+func newAzureCredential(cfg *azureCredentialConfig) (azcore.TokenCredential, error) {
+	if cfg == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the default credential: %w", err)
+		}
+
+		return cred, nil
+	}
+
+	var cred azcore.TokenCredential
+	var err error
+	switch cfg.Type {
+	case azureCredentialManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if cfg.ClientID != "" {
+			options.ID = azidentity.ClientID(cfg.ClientID)
+		}
+		cred, err = azidentity.NewManagedIdentityCredential(options)
+	case azureCredentialServicePrincipal:
+		cred, err = newAzureServicePrincipalCredential(cfg)
+	case azureCredentialWorkloadIdentity:
+		cred, err = azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientID:      cfg.ClientID,
+			TenantID:      cfg.TenantID,
+			TokenFilePath: cfg.TokenFilePath,
+		})
+	case azureCredentialCLI:
+		cred, err = azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: cfg.TenantID})
+	default:
+		err = fmt.Errorf(azureCredentialTypeErrorMsgFmt, cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the %v credential: %w", cfg.Type, err)
+	}
+
+	return cred, nil
+}
+
+// checkAzureCredential gets a token of the Azure Resource Manager APIs with the credential, so that a credential that
+// can't authenticate, for example a managed identity that isn't assigned to the VM, fails at the start instead of at
+// every synchronization.
+func checkAzureCredential(ctx context.Context, cred azcore.TokenCredential, credentialType string) error {
+	ctx, cancel := context.WithTimeout(ctx, connTimeoutInSecs*time.Second)
+	defer cancel()
+
+	_, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureManagementScope}})
+	if err != nil {
+		return fmt.Errorf("couldn't get a token with the %v credential: %w", credentialType, err)
+	}
+
+	return nil
+}
+
+// newAzureServicePrincipalCredential creates the credential of the service principal with the client secret, or with the
+// certificate and the private key of the PEM or PKCS#12 file.
+func newAzureServicePrincipalCredential(cfg *azureCredentialConfig) (azcore.TokenCredential, error) {
+	if cfg.ClientSecret != "" {
+		cred, err := azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't use the client secret: %w", err)
+		}
+
+		return cred, nil
+	}
+
+	data, err := os.ReadFile(cfg.ClientCertificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the client certificate: %w", err)
+	}
+
+	var password []byte
+	if cfg.ClientCertificatePassword != "" {
+		password = []byte(cfg.ClientCertificatePassword)
+	}
+	certs, key, err := azidentity.ParseCertificates(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the client certificate %v: %w", cfg.ClientCertificatePath, err)
+	}
+
+	cred, err := azidentity.NewClientCertificateCredential(cfg.TenantID, cfg.ClientID, certs, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't use the client certificate %v: %w", cfg.ClientCertificatePath, err)
+	}
+
+	return cred, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+)
+
+func TestValidateAzureCredentialConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		cfg   azureCredentialConfig
+		msg   string
+		valid bool
+	}{
+		{
+			cfg:   azureCredentialConfig{Type: azureCredentialManagedIdentity},
+			msg:   "managed_identity",
+			valid: true,
+		},
+		{
+			cfg:   azureCredentialConfig{Type: azureCredentialManagedIdentity, ClientID: "client"},
+			msg:   "managed_identity with client_id",
+			valid: true,
+		},
+		{
+			cfg:   azureCredentialConfig{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientSecret: "secret"},
+			msg:   "service_principal with client_secret",
+			valid: true,
+		},
+		{
+			cfg:   azureCredentialConfig{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientCertificatePath: "cert.pem"},
+			msg:   "service_principal with client_certificate_path",
+			valid: true,
+		},
+		{
+			cfg:   azureCredentialConfig{Type: azureCredentialWorkloadIdentity},
+			msg:   "workload_identity",
+			valid: true,
+		},
+		{
+			cfg:   azureCredentialConfig{Type: azureCredentialCLI},
+			msg:   "cli",
+			valid: true,
+		},
+		{
+			cfg: azureCredentialConfig{},
+			msg: "no type",
+		},
+		{
+			cfg: azureCredentialConfig{Type: "environment"},
+			msg: "invalid type",
+		},
+		{
+			cfg: azureCredentialConfig{Type: azureCredentialServicePrincipal, ClientID: "client", ClientSecret: "secret"},
+			msg: "service_principal without tenant_id",
+		},
+		{
+			cfg: azureCredentialConfig{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientSecret: "secret"},
+			msg: "service_principal without client_id",
+		},
+		{
+			cfg: azureCredentialConfig{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client"},
+			msg: "service_principal without client_secret or client_certificate_path",
+		},
+		{
+			cfg: azureCredentialConfig{
+				Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientSecret: "secret", ClientCertificatePath: "cert.pem",
+			},
+			msg: "service_principal with client_secret and client_certificate_path",
+		},
+	}
+
+	for _, test := range tests {
+		errs := validateAzureCredentialConfig(&test.cfg)
+		if test.valid && len(errs) > 0 {
+			t.Errorf("validateAzureCredentialConfig() failed for %v: %v", test.msg, errs)
+		}
+		if !test.valid && len(errs) == 0 {
+			t.Errorf("validateAzureCredentialConfig() didn't fail for %v", test.msg)
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its private key to a PEM file and returns its path.
+func writeTestCertificate(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldn't generate the key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nginx-asg-sync"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("couldn't create the certificate: %v", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatalf("couldn't write the certificate: %v", err)
+	}
+
+	return path
+}
+
+func TestNewAzureCredential(t *testing.T) {
+	t.Parallel()
+	certPath := writeTestCertificate(t)
+
+	tests := []*azureCredentialConfig{
+		nil,
+		{Type: azureCredentialManagedIdentity},
+		{Type: azureCredentialManagedIdentity, ClientID: "client"},
+		{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientSecret: "secret"},
+		{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientCertificatePath: certPath},
+		{Type: azureCredentialWorkloadIdentity, TenantID: "tenant", ClientID: "client", TokenFilePath: certPath},
+		{Type: azureCredentialCLI},
+	}
+
+	for _, cfg := range tests {
+		cred, err := newAzureCredential(cfg)
+		if err != nil || cred == nil {
+			t.Errorf("newAzureCredential() returned %v, %v for %+v, expected a credential", cred, err, cfg)
+		}
+	}
+}
+
+func TestNewAzureCredentialFail(t *testing.T) {
+	t.Parallel()
+	invalidCertPath := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidCertPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("couldn't write the certificate: %v", err)
+	}
+
+	tests := []*azureCredentialConfig{
+		{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientCertificatePath: "missing.pem"},
+		{Type: azureCredentialServicePrincipal, TenantID: "tenant", ClientID: "client", ClientCertificatePath: invalidCertPath},
+		{Type: azureCredentialServicePrincipal, TenantID: "invalid tenant", ClientID: "client", ClientSecret: "secret"},
+	}
+
+	for _, cfg := range tests {
+		_, err := newAzureCredential(cfg)
+		if err == nil {
+			t.Errorf("newAzureCredential() didn't fail for %+v", cfg)
+			continue
+		}
+		if !strings.Contains(err.Error(), cfg.Type) {
+			t.Errorf("newAzureCredential() returned the error %q for %+v, expected it to name the credential type", err, cfg)
+		}
+	}
+}
+
+func TestCheckAzureCredential(t *testing.T) {
+	t.Parallel()
+	cred := &azfake.TokenCredential{}
+
+	err := checkAzureCredential(context.Background(), cred, azureCredentialManagedIdentity)
+	if err != nil {
+		t.Errorf("checkAzureCredential() failed: %v", err)
+	}
+
+	cred.SetError(errors.New("no managed identity is assigned"))
+	err = checkAzureCredential(context.Background(), cred, azureCredentialManagedIdentity)
+	if err == nil {
+		t.Fatal("checkAzureCredential() didn't fail for a credential that can't get a token")
+	}
+	if !strings.Contains(err.Error(), azureCredentialManagedIdentity) {
+		t.Errorf("checkAzureCredential() returned the error %q, expected it to name the credential type", err)
+	}
+}
+
+func TestNewAzureClientWithoutCredential(t *testing.T) {
+	t.Parallel()
+	// the default credential chain isn't checked, even if the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	data := []byte("subscription_id: subscription_id\nresource_group_name: resource_group_name\n" +
+		"upstreams:\n  - name: backend1\n    virtual_machine_scale_set: backend-group\n    port: 80\n    kind: http\n")
+	if _, err := NewAzureClient(ctx, data); err != nil {
+		t.Errorf("NewAzureClient() failed without a credential: %v", err)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	yaml "gopkg.in/yaml.v3"
@@ -10,9 +13,26 @@ import (
 
 // commonConfig stores the configuration parameters common to all providers.
 type commonConfig struct {
-	APIEndpoint   string        `yaml:"api_endpoint"`
-	CloudProvider string        `yaml:"cloud_provider"`
-	SyncInterval  time.Duration `yaml:"sync_interval"`
+	APIEndpoint          string `yaml:"api_endpoint"`
+	CloudProvider        string `yaml:"cloud_provider"`
+	MetricsListenAddress string `yaml:"metrics_listen_address"`
+	HealthListenAddress  string `yaml:"health_listen_address"`
+	// LogFormat is the format of the logs, text or json.
+	LogFormat string `yaml:"log_format"`
+	LogLevel  string `yaml:"log_level"`
+	// StalePolicy is what happens to the servers when the instances of the scaling group are stale, keep or empty.
+	StalePolicy string `yaml:"stale_policy"`
+	// APIEndpoints is the list of the NGINX Plus API endpoints. A single api_endpoint is added to it on validation.
+	APIEndpoints []string `yaml:"api_endpoints"`
+	// ReadySyncIntervals is the number of sync intervals within which every upstream must have been synchronized
+	// successfully for nginx-asg-sync to be ready.
+	ReadySyncIntervals int `yaml:"ready_sync_intervals"`
+	// MaxConcurrency is the maximum number of upstreams synchronized at the same time.
+	MaxConcurrency int           `yaml:"max_concurrency"`
+	SyncInterval   time.Duration `yaml:"sync_interval"`
+	// StaleAfter is how long the last instances of a scaling group are used when the cloud provider API fails.
+	// Zero means forever.
+	StaleAfter time.Duration `yaml:"stale_after"`
 }
 
 func parseCommonConfig(data []byte) (*commonConfig, error) {
@@ -30,13 +50,82 @@ func parseCommonConfig(data []byte) (*commonConfig, error) {
 	return cfg, nil
 }
 
+// validateConfig validates the common and the cloud provider config and returns all the errors found, joined.
+func validateConfig(data []byte) error {
+	cfg := &commonConfig{}
+	err := yaml.Unmarshal(data, cfg)
+	if err != nil {
+		return fmt.Errorf("couldn't unmarshal common config: %w", err)
+	}
+
+	commonErr := validateCommonConfig(cfg)
+
+	switch cfg.CloudProvider {
+	case "AWS":
+		_, err = parseAWSConfig(data)
+	case "Azure":
+		_, err = parseAzureConfig(data)
+	case "GCP":
+		_, err = parseGCPConfig(data)
+	}
+
+	return errors.Join(commonErr, err)
+}
+
 func validateCommonConfig(cfg *commonConfig) error {
-	if cfg.APIEndpoint == "" {
-		return fmt.Errorf(errorMsgFormat, "api_endpoint")
+	var errs []error
+
+	switch {
+	case cfg.APIEndpoint == "" && len(cfg.APIEndpoints) == 0:
+		errs = append(errs, fmt.Errorf(errorMsgFormat, "api_endpoint"))
+	case cfg.APIEndpoint != "" && len(cfg.APIEndpoints) > 0:
+		errs = append(errs, errors.New(apiEndpointsErrorMsg))
+	case cfg.APIEndpoint != "":
+		cfg.APIEndpoints = []string{cfg.APIEndpoint}
+	}
+
+	for i, endpoint := range cfg.APIEndpoints {
+		if endpoint == "" || slices.Contains(cfg.APIEndpoints[:i], endpoint) {
+			errs = append(errs, fmt.Errorf(apiEndpointErrorMsgFmt, endpoint))
+		}
 	}
 
 	if cfg.SyncInterval <= 0 {
-		return errors.New(intervalErrorMsg)
+		errs = append(errs, errors.New(intervalErrorMsg))
+	}
+
+	if cfg.ReadySyncIntervals < 0 {
+		errs = append(errs, fmt.Errorf(readySyncIntervalsErrorMsgFmt, cfg.ReadySyncIntervals))
+	} else if cfg.ReadySyncIntervals == 0 {
+		cfg.ReadySyncIntervals = defaultReadySyncIntervals
+	}
+
+	if cfg.MaxConcurrency < 0 {
+		errs = append(errs, fmt.Errorf(maxConcurrencyErrorMsgFmt, cfg.MaxConcurrency))
+	} else if cfg.MaxConcurrency == 0 {
+		cfg.MaxConcurrency = defaultMaxConcurrency
+	}
+
+	if cfg.StaleAfter < 0 {
+		errs = append(errs, fmt.Errorf(staleAfterErrorMsgFmt, cfg.StaleAfter))
+	}
+
+	if cfg.StalePolicy == "" {
+		cfg.StalePolicy = defaultStalePolicy
+	} else if cfg.StalePolicy != stalePolicyKeep && cfg.StalePolicy != stalePolicyEmpty {
+		errs = append(errs, fmt.Errorf(stalePolicyErrorMsgFmt, cfg.StalePolicy))
+	}
+
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = defaultLogFormat
+	} else if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf(logFormatErrorMsgFmt, cfg.LogFormat))
+	}
+
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = defaultLogLevel
+	} else if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf(logLevelErrorMsgFmt, cfg.LogLevel))
 	}
 
 	if cfg.CloudProvider == "" {
@@ -44,21 +133,129 @@ func validateCommonConfig(cfg *commonConfig) error {
 	}
 
 	if !validateCloudProvider(cfg.CloudProvider) {
-		return fmt.Errorf(cloudProviderErrorMsg, cfg.CloudProvider)
+		errs = append(errs, fmt.Errorf(cloudProviderErrorMsg, cfg.CloudProvider))
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 // Upstream is the cloud agnostic representation of an Upstream (eg, common fields for every cloud provider).
 type Upstream struct {
-	MaxConns     *int
-	MaxFails     *int
-	Name         string
-	ScalingGroup string
-	Kind         string
-	FailTimeout  string
-	SlowStart    string
-	Port         int
-	InService    bool
+	MaxConns *int
+	MaxFails *int
+	// StaleAfter overrides the stale_after of the config for the upstream, nil if the upstream doesn't set it.
+	StaleAfter  *time.Duration
+	Name        string
+	Kind        string
+	FailTimeout string
+	SlowStart   string
+	// AddressFamily selects the IP addresses of the instances used for the servers: ipv4, ipv6 or dual.
+	AddressFamily string
+	// StalePolicy overrides the stale_policy of the config for the upstream, empty if the upstream doesn't set it.
+	StalePolicy string
+	// ScalingGroups are the scaling groups whose instances are the servers of the upstream.
+	ScalingGroups     []ScalingGroup
+	Port              int
+	MinServers        int
+	MaxRemovalPercent int
+	DrainTimeout      time.Duration
+	InService         bool
+	// ServerParametersFromTags is true if the weight, backup and route of the servers are set by the instance tags.
+	ServerParametersFromTags bool
+}
+
+// ScalingGroup is a scaling group of an upstream.
+type ScalingGroup struct {
+	// Weight is the weight of the servers of the scaling group, nil to keep the weight of the servers.
+	Weight *int
+	Name   string
+}
+
+// hasScalingGroup returns true if the upstream has the scaling group.
+func (u Upstream) hasScalingGroup(name string) bool {
+	return slices.ContainsFunc(u.ScalingGroups, func(g ScalingGroup) bool { return g.Name == name })
+}
+
+// getScalingGroupNames returns the comma-separated names of the scaling groups of the upstream, for the logs and the
+// status.
+func (u Upstream) getScalingGroupNames() string {
+	names := make([]string, 0, len(u.ScalingGroups))
+	for _, g := range u.ScalingGroups {
+		names = append(names, g.Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+// scalingGroupConfig is a scaling group of the list of scaling groups of an upstream in the config file.
+type scalingGroupConfig struct {
+	Name string `yaml:"name"`
+	// Weight is the weight of the servers of the scaling group, 0 to keep the weight of the servers.
+	Weight int `yaml:"weight"`
+}
+
+// instanceTagsPrefix is the prefix of the name of the scaling group of the instances selected by the instance_tags of
+// an upstream.
+const instanceTagsPrefix = "tags:"
+
+// getInstanceTagsScalingGroup returns the name of the scaling group of the instances with the tags: the prefix followed
+// by the key=value pairs sorted by key.
+func getInstanceTagsScalingGroup(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+
+	return instanceTagsPrefix + strings.Join(pairs, ",")
+}
+
+// getScalingGroups returns the scaling groups of an upstream with either a single scaling group, a list of them or the
+// instance tags that select its instances.
+func getScalingGroups(name string, groups []scalingGroupConfig, tags map[string]string) []ScalingGroup {
+	if len(tags) > 0 {
+		return []ScalingGroup{{Name: getInstanceTagsScalingGroup(tags)}}
+	}
+	if name != "" {
+		return []ScalingGroup{{Name: name}}
+	}
+
+	result := make([]ScalingGroup, 0, len(groups))
+	for _, g := range groups {
+		group := ScalingGroup{Name: g.Name}
+		if g.Weight > 0 {
+			group.Weight = &g.Weight
+		}
+		result = append(result, group)
+	}
+
+	return result
+}
+
+// validateScalingGroups validates the scaling groups of the upstream, which must have exactly one of the single scaling
+// group field of the provider, its list field and instance_tags.
+func validateScalingGroups(upstream string, field string, listField string, name string, groups []scalingGroupConfig, tags map[string]string) []error {
+	set := 0
+	for _, isSet := range []bool{name != "", len(groups) > 0, len(tags) > 0} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return []error{fmt.Errorf(upstreamScalingGroupsErrorMsgFmt, field, listField, upstream)}
+	}
+
+	var errs []error
+	if _, ok := tags[""]; ok {
+		errs = append(errs, fmt.Errorf(upstreamInstanceTagsErrorMsgFmt, upstream))
+	}
+	for i, g := range groups {
+		if g.Name == "" || slices.ContainsFunc(groups[:i], func(other scalingGroupConfig) bool { return other.Name == g.Name }) {
+			errs = append(errs, fmt.Errorf(upstreamScalingGroupNameErrorMsgFmt, listField, g.Name, upstream))
+		}
+		if g.Weight < 0 {
+			errs = append(errs, fmt.Errorf(upstreamScalingGroupWeightErrorMsgFmt, g.Weight, g.Name, upstream))
+		}
+	}
+
+	return errs
 }
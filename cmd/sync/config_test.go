@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
 
 var validYaml = []byte(`
 cloud_provider: AWS
@@ -31,6 +37,44 @@ func getInvalidCommonConfigInput() []*testInputCommon {
 	invalidSyncIntervalCfg.SyncInterval = 0
 	input = append(input, &testInputCommon{invalidSyncIntervalCfg, "invalid sync_interval"})
 
+	multipleAPIEndpointsCfg := getValidCommonConfig()
+	multipleAPIEndpointsCfg.APIEndpoints = []string{"http://127.0.0.1:8081/api"}
+	input = append(input, &testInputCommon{multipleAPIEndpointsCfg, "both api_endpoint and api_endpoints"})
+
+	duplicateAPIEndpointsCfg := getValidCommonConfig()
+	duplicateAPIEndpointsCfg.APIEndpoint = ""
+	duplicateAPIEndpointsCfg.APIEndpoints = []string{"http://127.0.0.1:8080/api", "http://127.0.0.1:8080/api"}
+	input = append(input, &testInputCommon{duplicateAPIEndpointsCfg, "duplicate api_endpoints"})
+
+	emptyAPIEndpointsCfg := getValidCommonConfig()
+	emptyAPIEndpointsCfg.APIEndpoint = ""
+	emptyAPIEndpointsCfg.APIEndpoints = []string{""}
+	input = append(input, &testInputCommon{emptyAPIEndpointsCfg, "empty api_endpoints"})
+
+	invalidReadySyncIntervalsCfg := getValidCommonConfig()
+	invalidReadySyncIntervalsCfg.ReadySyncIntervals = -1
+	input = append(input, &testInputCommon{invalidReadySyncIntervalsCfg, "invalid ready_sync_intervals"})
+
+	invalidMaxConcurrencyCfg := getValidCommonConfig()
+	invalidMaxConcurrencyCfg.MaxConcurrency = -1
+	input = append(input, &testInputCommon{invalidMaxConcurrencyCfg, "invalid max_concurrency"})
+
+	invalidStaleAfterCfg := getValidCommonConfig()
+	invalidStaleAfterCfg.StaleAfter = -1
+	input = append(input, &testInputCommon{invalidStaleAfterCfg, "invalid stale_after"})
+
+	invalidStalePolicyCfg := getValidCommonConfig()
+	invalidStalePolicyCfg.StalePolicy = "drop"
+	input = append(input, &testInputCommon{invalidStalePolicyCfg, "invalid stale_policy"})
+
+	invalidLogFormatCfg := getValidCommonConfig()
+	invalidLogFormatCfg.LogFormat = "xml"
+	input = append(input, &testInputCommon{invalidLogFormatCfg, "invalid log_format"})
+
+	invalidLogLevelCfg := getValidCommonConfig()
+	invalidLogLevelCfg.LogLevel = "verbose"
+	input = append(input, &testInputCommon{invalidLogLevelCfg, "invalid log_level"})
+
 	return input
 }
 
@@ -54,6 +98,12 @@ func TestValidateCommonConfigValid(t *testing.T) {
 	if err != nil {
 		t.Errorf("validateCommonConfig() failed for the valid config: %v", err)
 	}
+	if cfg.ReadySyncIntervals != defaultReadySyncIntervals {
+		t.Errorf("validateCommonConfig() set ready_sync_intervals to %v, expected the default %v", cfg.ReadySyncIntervals, defaultReadySyncIntervals)
+	}
+	if cfg.StalePolicy != defaultStalePolicy {
+		t.Errorf("validateCommonConfig() set stale_policy to %v, expected the default %v", cfg.StalePolicy, defaultStalePolicy)
+	}
 }
 
 func TestParseCommonConfig(t *testing.T) {
@@ -63,3 +113,114 @@ func TestParseCommonConfig(t *testing.T) {
 		t.Errorf("parseCommonConfig() failed for the valid config yaml: %v", string(validYaml))
 	}
 }
+
+func TestParseCommonConfigAPIEndpoints(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg      string
+		yaml     string
+		expected []string
+	}{
+		{
+			msg:      "api_endpoint",
+			yaml:     "api_endpoint: http://10.0.0.1:8080/api\nsync_interval: 5s\n",
+			expected: []string{"http://10.0.0.1:8080/api"},
+		},
+		{
+			msg:      "api_endpoints",
+			yaml:     "api_endpoints:\n  - http://10.0.0.1:8080/api\n  - http://10.0.0.2:8080/api\nsync_interval: 5s\n",
+			expected: []string{"http://10.0.0.1:8080/api", "http://10.0.0.2:8080/api"},
+		},
+	}
+
+	for _, test := range tests {
+		cfg, err := parseCommonConfig([]byte(test.yaml))
+		if err != nil {
+			t.Errorf("parseCommonConfig() failed for the config with %v: %v", test.msg, err)
+			continue
+		}
+		if !slices.Equal(cfg.APIEndpoints, test.expected) {
+			t.Errorf("parseCommonConfig() returned the endpoints %v for the config with %v, expected %v", cfg.APIEndpoints, test.msg, test.expected)
+		}
+	}
+}
+
+func TestValidateConfigReportsAllErrors(t *testing.T) {
+	t.Parallel()
+	data := []byte(`
+cloud_provider: AWS
+sync_interval: 5s
+upstreams:
+  - name: backend
+    autoscaling_group: backend-group
+    kind: tcp
+`)
+
+	err := validateConfig(data)
+	if err == nil {
+		t.Fatal("validateConfig() didn't fail for the invalid config")
+	}
+
+	expected := []string{
+		fmt.Sprintf(errorMsgFormat, "api_endpoint"),
+		fmt.Sprintf(errorMsgFormat, "region"),
+		fmt.Sprintf(upstreamPortErrorMsgFormat, "backend"),
+		fmt.Sprintf(upstreamKindErrorMsgFormat, "backend"),
+	}
+	for _, msg := range expected {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("validateConfig() returned %q, expected it to contain %q", err, msg)
+		}
+	}
+}
+
+func TestValidateConfigValid(t *testing.T) {
+	t.Parallel()
+	data := append(validYaml, []byte(`
+region: us-west-2
+upstreams:
+  - name: backend
+    autoscaling_group: backend-group
+    port: 80
+    kind: http
+`)...)
+
+	err := validateConfig(data)
+	if err != nil {
+		t.Errorf("validateConfig() failed for the valid config: %v", err)
+	}
+}
+
+func TestGetScalingGroups(t *testing.T) {
+	t.Parallel()
+	weight := 2
+	tests := []struct {
+		msg      string
+		name     string
+		groups   []scalingGroupConfig
+		tags     map[string]string
+		expected []ScalingGroup
+	}{
+		{
+			msg:      "single scaling group",
+			name:     "backend-group",
+			expected: []ScalingGroup{{Name: "backend-group"}},
+		},
+		{
+			msg:      "list of scaling groups",
+			groups:   []scalingGroupConfig{{Name: "backend-blue"}, {Name: "backend-green", Weight: 2}},
+			expected: []ScalingGroup{{Name: "backend-blue"}, {Name: "backend-green", Weight: &weight}},
+		},
+		{
+			msg:      "instance tags",
+			tags:     map[string]string{"role": "backend", "env": "prod"},
+			expected: []ScalingGroup{{Name: "tags:env=prod,role=backend"}},
+		},
+	}
+
+	for _, test := range tests {
+		if got := getScalingGroups(test.name, test.groups, test.tags); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("getScalingGroups() returned %+v for the %v, expected %+v", got, test.msg, test.expected)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v2/client"
+)
+
+// dryRunNginxClient is an NginxClient that doesn't change NGINX Plus: its updates only return the servers that would be
+// added, removed and updated, determined the same way as the NGINX Plus client does.
+type dryRunNginxClient struct {
+	NginxClient
+}
+
+func (c dryRunNginxClient) UpdateHTTPServers(ctx context.Context, upstream string, servers []nginx.UpstreamServer) ([]nginx.UpstreamServer, []nginx.UpstreamServer, []nginx.UpstreamServer, error) {
+	serversInNginx, err := c.GetHTTPServers(ctx, upstream)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to update servers of %v upstream: %w", upstream, err)
+	}
+
+	added, removed, updated := diffServers(servers, serversInNginx, func(s nginx.UpstreamServer) nginx.UpstreamServer { return s })
+	return added, removed, updated, nil
+}
+
+func (c dryRunNginxClient) UpdateStreamServers(ctx context.Context, upstream string, servers []nginx.StreamUpstreamServer) ([]nginx.StreamUpstreamServer, []nginx.StreamUpstreamServer, []nginx.StreamUpstreamServer, error) {
+	serversInNginx, err := c.GetStreamServers(ctx, upstream)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to update stream servers of %v upstream: %w", upstream, err)
+	}
+
+	added, removed, updated := diffServers(servers, serversInNginx, streamToUpstreamServer)
+	return added, removed, updated, nil
+}
+
+// diffServers returns the servers to add to, remove from and update in NGINX Plus to get the servers, like the NGINX
+// Plus client does. toUpstreamServer converts a server to an nginx.UpstreamServer to compare its address and parameters.
+func diffServers[S any](servers []S, serversInNginx []S, toUpstreamServer func(S) nginx.UpstreamServer) ([]S, []S, []S) {
+	inNginx := make(map[string]nginx.UpstreamServer, len(serversInNginx))
+	for _, server := range serversInNginx {
+		s := toUpstreamServer(server)
+		inNginx[s.Server] = s
+	}
+
+	var added, removed, updated []S
+	addresses := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		s := toUpstreamServer(server)
+		addresses[s.Server] = true
+		if serverInNginx, ok := inNginx[s.Server]; !ok {
+			added = append(added, server)
+		} else if !haveSameParameters(s, serverInNginx) {
+			updated = append(updated, server)
+		}
+	}
+
+	for _, server := range serversInNginx {
+		if !addresses[toUpstreamServer(server).Server] {
+			removed = append(removed, server)
+		}
+	}
+
+	return added, removed, updated
+}
+
+// haveSameParameters compares the parameters of a server with those of the server in NGINX Plus. The Syncer always
+// sets max_conns, max_fails, fail_timeout and slow_start, so only the parameters it may leave unset take the NGINX Plus
+// default when NGINX Plus reports them.
+func haveSameParameters(server nginx.UpstreamServer, serverInNginx nginx.UpstreamServer) bool {
+	server.ID = serverInNginx.ID
+	setDefault(&server.Weight, serverInNginx.Weight, 1)
+	setDefault(&server.Backup, serverInNginx.Backup, false)
+	setDefault(&server.Down, serverInNginx.Down, false)
+
+	return reflect.DeepEqual(server, serverInNginx)
+}
+
+// setDefault sets the parameter to the default if it is unset and NGINX Plus reports it.
+func setDefault[T any](parameter **T, parameterInNginx *T, value T) {
+	if *parameter == nil && parameterInNginx != nil {
+		*parameter = &value
+	}
+}
+
+func streamToUpstreamServer(s nginx.StreamUpstreamServer) nginx.UpstreamServer {
+	return nginx.UpstreamServer{
+		ID:          s.ID,
+		Server:      s.Server,
+		MaxConns:    s.MaxConns,
+		MaxFails:    s.MaxFails,
+		FailTimeout: s.FailTimeout,
+		SlowStart:   s.SlowStart,
+		Weight:      s.Weight,
+		Down:        s.Down,
+		Backup:      s.Backup,
+		Service:     s.Service,
+	}
+}
+
+// printDryRunResults writes the changes of the results of a dry run to w. It returns false if any upstream failed.
+func printDryRunResults(w io.Writer, results []SyncResult) bool {
+	ok := true
+	for _, result := range results {
+		if result.Err != nil {
+			ok = false
+			fmt.Fprintf(w, "%v (group %v) in %v: error: %v\n", result.Upstream.Name, result.Upstream.getScalingGroupNames(), result.Endpoint, result.Err)
+			continue
+		}
+
+		if !result.Changed() {
+			fmt.Fprintf(w, "%v (group %v) in %v: no changes\n", result.Upstream.Name, result.Upstream.getScalingGroupNames(), result.Endpoint)
+			continue
+		}
+
+		updated, drained := splitDrainedServers(result.Updated, result.Draining)
+		fmt.Fprintf(w, "%v (group %v) in %v: add %v, remove %v, update %v, drain %v\n", result.Upstream.Name, result.Upstream.getScalingGroupNames(),
+			result.Endpoint, result.Added, result.Removed, updated, drained)
+	}
+
+	return ok
+}
+
+// splitDrainedServers splits the updated servers into the servers whose parameters are updated and the servers that are
+// put in drain mode. The servers that are already in drain mode aren't updated.
+func splitDrainedServers(updated []string, draining []string) ([]string, []string) {
+	var parametersUpdated, drained []string
+	for _, server := range updated {
+		if slices.Contains(draining, server) {
+			drained = append(drained, server)
+		} else {
+			parametersUpdated = append(parametersUpdated, server)
+		}
+	}
+
+	return parametersUpdated, drained
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v2/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDryRunSyncerDoesNotChangeNginx(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.2:80")
+	api.addUpstream("stream", "backend-stream", "10.0.1.1:5432")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1", "10.0.0.3"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewDryRunSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	results := syncer.SyncOnce(context.Background())
+	if !slices.Equal(results[0].Added, []string{"10.0.0.3:80"}) || !slices.Equal(results[0].Removed, []string{"10.0.0.2:80"}) {
+		t.Errorf("SyncOnce() of the dry run returned %+v, expected 10.0.0.3:80 added and 10.0.0.2:80 removed", results[0])
+	}
+	// the servers added by the fake have no parameters, so they differ from those of the scaling group
+	if !slices.Equal(results[0].Updated, []string{"10.0.0.1:80"}) {
+		t.Errorf("SyncOnce() of the dry run returned %v updated, expected [10.0.0.1:80]", results[0].Updated)
+	}
+	if !slices.Equal(results[1].Updated, []string{"10.0.1.1:5432"}) || len(results[1].Added)+len(results[1].Removed) != 0 {
+		t.Errorf("SyncOnce() of the dry run returned %+v, expected only 10.0.1.1:5432 updated", results[1])
+	}
+
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.1:80", "10.0.0.2:80"}) {
+		t.Errorf("the dry run changed the servers of backend-http to %v", got)
+	}
+}
+
+func TestDryRunSyncerNoChanges(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+	endpoints := newTestNginxEndpoints(t, api)
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	NewSyncer(cloud, endpoints, NewMetrics(prometheus.NewRegistry())).SyncOnce(context.Background())
+
+	results := NewDryRunSyncer(cloud, endpoints, NewMetrics(prometheus.NewRegistry())).SyncOnce(context.Background())
+	for _, result := range results {
+		if result.Changed() {
+			t.Errorf("SyncOnce() of the dry run after a sync returned changes: %+v", result)
+		}
+	}
+}
+
+func TestPrintDryRunResults(t *testing.T) {
+	t.Parallel()
+	upstreams := getTestUpstreams()
+	results := []SyncResult{
+		{Upstream: upstreams[0], Endpoint: "nginx-1", Added: []string{"10.0.0.1:80"}},
+		{Upstream: upstreams[1], Endpoint: "nginx-1", Err: errors.New("throttled")},
+	}
+
+	var out bytes.Buffer
+	if printDryRunResults(&out, results) {
+		t.Error("printDryRunResults() returned true for a failed upstream")
+	}
+
+	expected := "backend-http (group group-http) in nginx-1: add [10.0.0.1:80], remove [], update [], drain []\n" +
+		"backend-stream (group group-stream) in nginx-1: error: throttled\n"
+	if out.String() != expected {
+		t.Errorf("printDryRunResults() wrote %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestPrintDryRunResultsDrain(t *testing.T) {
+	t.Parallel()
+	upstreams := getTestUpstreams()
+	results := []SyncResult{
+		{
+			Upstream: upstreams[0], Endpoint: "nginx-1",
+			Updated: []string{"10.0.0.1:80", "10.0.0.2:80"}, Draining: []string{"10.0.0.2:80", "10.0.0.3:80"},
+		},
+	}
+
+	var out bytes.Buffer
+	printDryRunResults(&out, results)
+
+	// 10.0.0.3:80 is already in drain mode, so it isn't updated
+	expected := "backend-http (group group-http) in nginx-1: add [], remove [], update [10.0.0.1:80], drain [10.0.0.2:80]\n"
+	if out.String() != expected {
+		t.Errorf("printDryRunResults() wrote %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestDryRunSyncerDrain(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+	endpoints := newTestNginxEndpoints(t, api)
+
+	upstreams := getTestUpstreams()
+	upstreams[0].DrainTimeout = time.Minute
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1", "10.0.0.2"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: upstreams,
+	}
+	NewSyncer(cloud, endpoints, NewMetrics(prometheus.NewRegistry())).SyncOnce(context.Background())
+	api.setActiveConnections("10.0.0.2:80", 1)
+	cloud.ips["group-http"] = []string{"10.0.0.1"}
+
+	results := NewDryRunSyncer(cloud, endpoints, NewMetrics(prometheus.NewRegistry())).SyncOnce(context.Background())
+	updated, drained := splitDrainedServers(results[0].Updated, results[0].Draining)
+	if len(updated) != 0 || !slices.Equal(drained, []string{"10.0.0.2:80"}) {
+		t.Errorf("SyncOnce() of the dry run returned %v updated and %v drained, expected only 10.0.0.2:80 drained", updated, drained)
+	}
+	if server, _ := api.server("http", "backend-http", "10.0.0.2:80"); server.Drain {
+		t.Error("the dry run put the server 10.0.0.2:80 in drain mode")
+	}
+}
+
+// TestDryRunMatchesNginxClient checks that the dry run reports the servers that the NGINX Plus client adds, removes and
+// updates, for servers in NGINX Plus with every parameter set as NGINX Plus reports them.
+func TestDryRunMatchesNginxClient(t *testing.T) {
+	t.Parallel()
+	maxConns, maxFails := 0, 1
+	defaultWeight, weight := 1, 5
+	noBackup, backup := false, true
+	down := false
+	nginxServer := func(address string) nginx.UpstreamServer {
+		return nginx.UpstreamServer{
+			Server: address, MaxConns: &maxConns, MaxFails: &maxFails, FailTimeout: defaultFailTimeout, SlowStart: defaultSlowStart,
+			Weight: &defaultWeight, Backup: &noBackup, Down: &down,
+		}
+	}
+	// server is built like the Syncer does, without the parameters from the tags
+	server := func(address string) nginx.UpstreamServer {
+		return nginx.UpstreamServer{Server: address, MaxConns: &maxConns, MaxFails: &maxFails, FailTimeout: defaultFailTimeout, SlowStart: defaultSlowStart}
+	}
+	with := func(s nginx.UpstreamServer, set func(s *nginx.UpstreamServer)) nginx.UpstreamServer {
+		set(&s)
+		return s
+	}
+
+	tests := []struct {
+		msg            string
+		serversInNginx []nginx.UpstreamServer
+		servers        []nginx.UpstreamServer
+	}{
+		{
+			msg:            "no changes",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80")},
+			servers:        []nginx.UpstreamServer{server("10.0.0.1:80")},
+		},
+		{
+			msg:            "add",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80")},
+			servers:        []nginx.UpstreamServer{server("10.0.0.1:80"), server("10.0.0.2:80")},
+		},
+		{
+			msg:            "remove",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80"), nginxServer("10.0.0.2:80")},
+			servers:        []nginx.UpstreamServer{server("10.0.0.1:80")},
+		},
+		{
+			msg:            "update of the weight",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80")},
+			servers:        []nginx.UpstreamServer{with(server("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Weight = &weight })},
+		},
+		{
+			msg:            "update of the weight to the default",
+			serversInNginx: []nginx.UpstreamServer{with(nginxServer("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Weight = &weight })},
+			servers:        []nginx.UpstreamServer{server("10.0.0.1:80")},
+		},
+		{
+			msg:            "update of the backup",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80")},
+			servers:        []nginx.UpstreamServer{with(server("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Backup = &backup })},
+		},
+		{
+			msg:            "update of the route",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80")},
+			servers:        []nginx.UpstreamServer{with(server("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Route = "a" })},
+		},
+		{
+			msg:            "drain",
+			serversInNginx: []nginx.UpstreamServer{nginxServer("10.0.0.1:80")},
+			servers:        []nginx.UpstreamServer{with(server("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Drain = true })},
+		},
+		{
+			msg:            "already in drain mode",
+			serversInNginx: []nginx.UpstreamServer{with(nginxServer("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Drain = true })},
+			servers:        []nginx.UpstreamServer{with(server("10.0.0.1:80"), func(s *nginx.UpstreamServer) { s.Drain = true })},
+		},
+	}
+
+	for _, test := range tests {
+		api, dryRunAPI := newFakeNginxPlusAPI(), newFakeNginxPlusAPI()
+		for _, a := range []*fakeNginxPlusAPI{api, dryRunAPI} {
+			a.addServers("http", "backend-http", test.serversInNginx...)
+			a.addServers("stream", "backend-stream", test.serversInNginx...)
+		}
+		client := newTestNginxClient(t, api)
+		dryRunClient := dryRunNginxClient{newTestNginxClient(t, dryRunAPI)}
+
+		added, removed, updated, err := client.UpdateHTTPServers(context.Background(), "backend-http", test.servers)
+		if err != nil {
+			t.Fatalf("UpdateHTTPServers() failed for %v: %v", test.msg, err)
+		}
+		dryRunAdded, dryRunRemoved, dryRunUpdated, err := dryRunClient.UpdateHTTPServers(context.Background(), "backend-http", test.servers)
+		if err != nil {
+			t.Fatalf("UpdateHTTPServers() of the dry run failed for %v: %v", test.msg, err)
+		}
+		expected := [][]string{getUpstreamServerAddresses(added), getUpstreamServerAddresses(removed), getUpstreamServerAddresses(updated)}
+		got := [][]string{getUpstreamServerAddresses(dryRunAdded), getUpstreamServerAddresses(dryRunRemoved), getUpstreamServerAddresses(dryRunUpdated)}
+		if !slices.EqualFunc(got, expected, slices.Equal) {
+			t.Errorf("UpdateHTTPServers() of the dry run returned %v for %v, expected %v", got, test.msg, expected)
+		}
+
+		streamServers := make([]nginx.StreamUpstreamServer, 0, len(test.servers))
+		for _, s := range test.servers {
+			streamServers = append(streamServers, nginx.StreamUpstreamServer{
+				Server: s.Server, MaxConns: s.MaxConns, MaxFails: s.MaxFails, FailTimeout: s.FailTimeout, SlowStart: s.SlowStart,
+				Weight: s.Weight, Backup: s.Backup,
+			})
+		}
+		streamAdded, streamRemoved, streamUpdated, err := client.UpdateStreamServers(context.Background(), "backend-stream", streamServers)
+		if err != nil {
+			t.Fatalf("UpdateStreamServers() failed for %v: %v", test.msg, err)
+		}
+		dryRunStreamAdded, dryRunStreamRemoved, dryRunStreamUpdated, err := dryRunClient.UpdateStreamServers(context.Background(), "backend-stream", streamServers)
+		if err != nil {
+			t.Fatalf("UpdateStreamServers() of the dry run failed for %v: %v", test.msg, err)
+		}
+		expected = [][]string{getStreamUpstreamServerAddresses(streamAdded), getStreamUpstreamServerAddresses(streamRemoved), getStreamUpstreamServerAddresses(streamUpdated)}
+		got = [][]string{getStreamUpstreamServerAddresses(dryRunStreamAdded), getStreamUpstreamServerAddresses(dryRunStreamRemoved), getStreamUpstreamServerAddresses(dryRunStreamUpdated)}
+		if !slices.EqualFunc(got, expected, slices.Equal) {
+			t.Errorf("UpdateStreamServers() of the dry run returned %v for %v, expected %v", got, test.msg, expected)
+		}
+	}
+}
@@ -1,16 +1,56 @@
 package main
 
 const (
-	errorMsgFormat                 = "the mandatory field %v is either empty or missing in the config file"
-	intervalErrorMsg               = "the mandatory field sync_interval is either 0, negative or missing in the config file"
-	cloudProviderErrorMsg          = "the field cloud_provider has invalid value %v in the config file"
-	defaultCloudProvider           = "AWS"
-	upstreamNameErrorMsg           = "the mandatory field name is either empty or missing for an upstream in the config file"
-	upstreamErrorMsgFormat         = "the mandatory field %v is either empty or missing for the upstream %v in the config file"
-	upstreamPortErrorMsgFormat     = "the mandatory field port is either zero or missing for the upstream %v in the config file"
-	upstreamKindErrorMsgFormat     = "the mandatory field kind is either not equal to http or tcp or missing for the upstream %v in the config file"
-	upstreamMaxConnsErrorMsgFmt    = "the field max_conns has invalid value %v in the config file"
-	upstreamMaxFailsErrorMsgFmt    = "the field max_fails has invalid value %v in the config file"
-	upstreamFailTimeoutErrorMsgFmt = "the field fail_timeout has invalid value %v in the config file"
-	upstreamSlowStartErrorMsgFmt   = "the field slow_start has invalid value %v in the config file"
+	errorMsgFormat                           = "the mandatory field %v is either empty or missing in the config file"
+	intervalErrorMsg                         = "the mandatory field sync_interval is either 0, negative or missing in the config file"
+	cloudProviderErrorMsg                    = "the field cloud_provider has invalid value %v in the config file"
+	defaultCloudProvider                     = "AWS"
+	upstreamNameErrorMsg                     = "the mandatory field name is either empty or missing for an upstream in the config file"
+	upstreamErrorMsgFormat                   = "the mandatory field %v is either empty or missing for the upstream %v in the config file"
+	upstreamDuplicateNameErrorMsgFmt         = "the upstream %v is defined more than once in the config file"
+	upstreamScalingGroupsErrorMsgFmt         = "exactly one of the fields %v, %v or instance_tags must be set for the upstream %v in the config file"
+	upstreamScalingGroupNameErrorMsgFmt      = "the field %v has an empty or duplicate name %q for the upstream %v in the config file"
+	upstreamInstanceTagsErrorMsgFmt          = "the field instance_tags has an empty key for the upstream %v in the config file"
+	upstreamInServiceInstanceTagsErrorMsgFmt = "the field in_service can't be set with instance_tags for the upstream %v in the config file"
+	upstreamScalingGroupWeightErrorMsgFmt    = "the field weight has invalid value %v for the scaling group %v of the upstream %v in the config file"
+	upstreamPortErrorMsgFormat               = "the mandatory field port is either zero or missing for the upstream %v in the config file"
+	upstreamKindErrorMsgFormat               = "the mandatory field kind is either not equal to http or tcp or missing for the upstream %v in the config file"
+	upstreamMaxConnsErrorMsgFmt              = "the field max_conns has invalid value %v in the config file"
+	upstreamMaxFailsErrorMsgFmt              = "the field max_fails has invalid value %v in the config file"
+	upstreamFailTimeoutErrorMsgFmt           = "the field fail_timeout has invalid value %v in the config file"
+	upstreamSlowStartErrorMsgFmt             = "the field slow_start has invalid value %v in the config file"
+	upstreamDrainTimeoutErrorMsgFmt          = "the field drain_timeout has invalid value %v in the config file"
+	upstreamDrainTimeoutKindErrorMsgFmt      = "the field drain_timeout is only supported for upstreams of kind http, but the upstream %v is not of kind http"
+	upstreamMinServersErrorMsgFmt            = "the field min_servers has invalid value %v in the config file"
+	upstreamMaxRemovalPercentErrorMsgFmt     = "the field max_removal_percent has invalid value %v in the config file, it must be between 0 and 100"
+	apiEndpointsErrorMsg                     = "only one of the fields api_endpoint and api_endpoints can be set in the config file"
+	apiEndpointErrorMsgFmt                   = "the field api_endpoints has an empty or duplicate endpoint %q in the config file"
+	upstreamStaleAfterErrorMsgFmt            = "the field stale_after has invalid value %v for the upstream %v in the config file"
+	upstreamStalePolicyErrorMsgFmt           = "the field stale_policy has invalid value %v for the upstream %v in the config file, it must be keep or empty"
+	upstreamAddressFamilyErrorMsgFmt         = "the field address_family has invalid value %v for the upstream %v in the config file, it must be ipv4, ipv6 or dual"
+	upstreamInstanceStatesErrorMsgFmt        = "the field instance_states has invalid value %v for the upstream %v in the config file"
+	readySyncIntervalsErrorMsgFmt            = "the field ready_sync_intervals has invalid value %v in the config file"
+	defaultReadySyncIntervals                = 3
+	maxConcurrencyErrorMsgFmt                = "the field max_concurrency has invalid value %v in the config file"
+	defaultMaxConcurrency                    = 10
+	staleAfterErrorMsgFmt                    = "the field stale_after has invalid value %v in the config file"
+	stalePolicyErrorMsgFmt                   = "the field stale_policy has invalid value %v in the config file, it must be keep or empty"
+	defaultStalePolicy                       = stalePolicyKeep
+	logFormatErrorMsgFmt                     = "the field log_format has invalid value %v in the config file, it must be text or json"
+	logLevelErrorMsgFmt                      = "the field log_level has invalid value %v in the config file, it must be debug, info, warn or error"
+	defaultLogFormat                         = "text"
+	defaultLogLevel                          = "info"
+	sqsQueueURLErrorMsgFmt                   = "the field sqs_queue_url has invalid value %v in the config file"
+	lifecycleHooksQueueErrorMsg              = "the field lifecycle_hooks requires the field sqs_queue_url in the config file"
+	lifecycleHookErrorMsgFmt                 = "the field lifecycle_hooks has an empty or duplicate lifecycle hook %q in the config file"
+	roleARNErrorMsgFmt                       = "the field role_arn has invalid value %v in the config file"
+	upstreamRoleARNErrorMsgFmt               = "the field role_arn has invalid value %v for the upstream %v in the config file"
+	upstreamScalingGroupConflictErrorMsgFmt  = "the upstream %v uses the autoscaling_group %v, which is or matches the autoscaling_group of another upstream with a different role_arn"
+	upstreamScalingGroupRoleARNErrorMsgFmt   = "the field role_arn has invalid value %v for the Auto Scaling group %v of the upstream %v in the config file"
+	upstreamScaleSetConflictErrorMsgFmt      = "the upstream %v uses the virtual_machine_scale_set %v of another upstream with a different subscription_id or resource_group_name"
+	defaultRoleSessionName                   = "nginx-asg-sync"
+	azureCredentialTypeErrorMsgFmt           = "the field credential.type has invalid value %v in the config file, it must be managed_identity, service_principal, workload_identity or cli"
+	azureCredentialFieldErrorMsgFmt          = "the mandatory field credential.%v is either empty or missing for the credential type %v in the config file"
+	azureCredentialSecretErrorMsg            = "exactly one of the fields credential.client_secret or credential.client_certificate_path must be set for the credential type service_principal in the config file"
+	gcpLocationErrorMsg                      = "exactly one of the fields zone or region must be set in the config file"
 )
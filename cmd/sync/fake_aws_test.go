@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// fakeEC2Instance is an instance of an Auto Scaling group in the fakeAWSAPI.
+type fakeEC2Instance struct {
+	tags map[string]string
+	id   string
+	ip   string
+	// ipv6 is the primary IPv6 address of the instance, if it has one.
+	ipv6  string
+	state string
+	// lifecycleState is the Auto Scaling lifecycle state of the instance, InService if it is empty.
+	lifecycleState string
+	// region is the region of the instance, which is in every region if it is empty.
+	region string
+}
+
+// fakeAWSAPI is an in-process stand-in for the EC2, Auto Scaling and STS query APIs, serving DescribeInstances,
+// DescribeAutoScalingGroups, DescribeAutoScalingInstances, CompleteLifecycleAction and AssumeRole.
+type fakeAWSAPI struct {
+	// instances maps an Auto Scaling group name to its instances, the instances that aren't in an Auto Scaling group
+	// are under the empty name. An Auto Scaling group exists if it has an entry, even without instances.
+	instances map[string][]fakeEC2Instance
+	// regions maps an Auto Scaling group name to the region of the last DescribeInstances request about it.
+	regions map[string]string
+	// completedLifecycleActions are the instance IDs of the completed lifecycle actions.
+	completedLifecycleActions []string
+	// assumedRoles are the RoleArn, ExternalId and RoleSessionName parameters of the AssumeRole requests.
+	assumedRoles []awsServicesKey
+	// describeInstancesCalls is the number of DescribeInstances requests.
+	describeInstancesCalls int
+	// pageSize is the number of instances returned in a page of DescribeInstances.
+	pageSize int
+	mu       sync.Mutex
+}
+
+func newFakeAWSAPI() *fakeAWSAPI {
+	return &fakeAWSAPI{
+		instances: make(map[string][]fakeEC2Instance),
+		regions:   make(map[string]string),
+		pageSize:  1,
+	}
+}
+
+func (f *fakeAWSAPI) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Form.Get("Action") {
+		case "DescribeInstances":
+			f.describeInstances(w, r)
+		case "DescribeAutoScalingGroups":
+			f.describeAutoScalingGroups(w, r)
+		case "DescribeAutoScalingInstances":
+			f.describeAutoScalingInstances(w, r)
+		case "CompleteLifecycleAction":
+			f.completeLifecycleAction(w, r)
+		case "AssumeRole":
+			f.assumeRole(w, r)
+		default:
+			http.Error(w, "unsupported action "+r.Form.Get("Action"), http.StatusBadRequest)
+		}
+	})
+}
+
+func (f *fakeAWSAPI) describeInstances(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.describeInstancesCalls++
+	filters := getQueryFilters(r)
+
+	for _, group := range filters["tag:aws:autoscaling:groupName"] {
+		f.regions[group] = getRequestRegion(r)
+	}
+
+	var instances []fakeEC2Instance
+	for _, group := range slices.Sorted(maps.Keys(f.instances)) {
+		for _, ins := range f.instances[group] {
+			if (ins.region == "" || ins.region == getRequestRegion(r)) && matchesFakeEC2Filters(ins, group, filters) {
+				instances = append(instances, ins)
+			}
+		}
+	}
+
+	start, _ := strconv.Atoi(r.Form.Get("NextToken"))
+	end := min(start+f.pageSize, len(instances))
+
+	var body strings.Builder
+	body.WriteString(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>fake</requestId><reservationSet>`)
+	for _, ins := range instances[start:end] {
+		fmt.Fprintf(&body, `<item><reservationId>r-%v</reservationId><instancesSet><item><instanceId>%v</instanceId>`+
+			`<instanceState><name>%v</name></instanceState><networkInterfaceSet><item>`, ins.id, ins.id, ins.state)
+		if ins.ip != "" {
+			fmt.Fprintf(&body, `<privateIpAddress>%v</privateIpAddress>`, ins.ip)
+		}
+		if ins.ipv6 != "" {
+			fmt.Fprintf(&body, `<ipv6AddressesSet><item><ipv6Address>%v</ipv6Address><isPrimaryIpv6>true</isPrimaryIpv6></item></ipv6AddressesSet>`, ins.ipv6)
+		}
+		body.WriteString(`</item></networkInterfaceSet><tagSet>`)
+		for k, v := range ins.tags {
+			fmt.Fprintf(&body, `<item><key>%v</key><value>%v</value></item>`, k, v)
+		}
+		body.WriteString(`</tagSet></item></instancesSet></item>`)
+	}
+	body.WriteString(`</reservationSet>`)
+	if end < len(instances) {
+		fmt.Fprintf(&body, `<nextToken>%v</nextToken>`, end)
+	}
+	body.WriteString(`</DescribeInstancesResponse>`)
+
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = w.Write([]byte(body.String()))
+}
+
+func (f *fakeAWSAPI) describeAutoScalingGroups(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body strings.Builder
+	body.WriteString(`<DescribeAutoScalingGroupsResponse xmlns="http://autoscaling.amazonaws.com/doc/2011-01-01/">` +
+		`<DescribeAutoScalingGroupsResult><AutoScalingGroups>`)
+	// all the Auto Scaling groups are returned if the request has no names
+	names := slices.Sorted(maps.Keys(f.instances))
+	if r.Form.Has("AutoScalingGroupNames.member.1") {
+		names = nil
+		for i := 1; r.Form.Has(fmt.Sprintf("AutoScalingGroupNames.member.%d", i)); i++ {
+			names = append(names, r.Form.Get(fmt.Sprintf("AutoScalingGroupNames.member.%d", i)))
+		}
+	}
+	for _, name := range names {
+		if _, ok := f.instances[name]; ok && name != "" {
+			fmt.Fprintf(&body, `<member><AutoScalingGroupName>%v</AutoScalingGroupName></member>`, name)
+		}
+	}
+	body.WriteString(`</AutoScalingGroups></DescribeAutoScalingGroupsResult>` +
+		`<ResponseMetadata><RequestId>fake</RequestId></ResponseMetadata></DescribeAutoScalingGroupsResponse>`)
+
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = w.Write([]byte(body.String()))
+}
+
+func (f *fakeAWSAPI) describeAutoScalingInstances(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body strings.Builder
+	body.WriteString(`<DescribeAutoScalingInstancesResponse xmlns="http://autoscaling.amazonaws.com/doc/2011-01-01/">` +
+		`<DescribeAutoScalingInstancesResult><AutoScalingInstances>`)
+	for i := 1; r.Form.Has(fmt.Sprintf("InstanceIds.member.%d", i)); i++ {
+		id := r.Form.Get(fmt.Sprintf("InstanceIds.member.%d", i))
+		for group, instances := range f.instances {
+			for _, ins := range instances {
+				if ins.id != id {
+					continue
+				}
+				lifecycleState := ins.lifecycleState
+				if lifecycleState == "" {
+					lifecycleState = "InService"
+				}
+				fmt.Fprintf(&body, `<member><InstanceId>%v</InstanceId><AutoScalingGroupName>%v</AutoScalingGroupName>`+
+					`<LifecycleState>%v</LifecycleState></member>`, id, group, lifecycleState)
+			}
+		}
+	}
+	body.WriteString(`</AutoScalingInstances></DescribeAutoScalingInstancesResult>` +
+		`<ResponseMetadata><RequestId>fake</RequestId></ResponseMetadata></DescribeAutoScalingInstancesResponse>`)
+
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = w.Write([]byte(body.String()))
+}
+
+func (f *fakeAWSAPI) completeLifecycleAction(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.completedLifecycleActions = append(f.completedLifecycleActions, r.Form.Get("InstanceId"))
+
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = w.Write([]byte(`<CompleteLifecycleActionResponse xmlns="http://autoscaling.amazonaws.com/doc/2011-01-01/">` +
+		`<CompleteLifecycleActionResult/><ResponseMetadata><RequestId>fake</RequestId></ResponseMetadata></CompleteLifecycleActionResponse>`))
+}
+
+func (f *fakeAWSAPI) assumeRole(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.assumedRoles = append(f.assumedRoles, awsServicesKey{
+		roleARN:     r.Form.Get("RoleArn"),
+		externalID:  r.Form.Get("ExternalId"),
+		sessionName: r.Form.Get("RoleSessionName"),
+	})
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+		`<AccessKeyId>AKIAFAKE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>`+
+		`<Expiration>%v</Expiration></Credentials></AssumeRoleResult><ResponseMetadata><RequestId>fake</RequestId>`+
+		`</ResponseMetadata></AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+}
+
+// getAssumedRoles returns the roles of the AssumeRole requests.
+func (f *fakeAWSAPI) getAssumedRoles() []awsServicesKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.assumedRoles)
+}
+
+// getCompletedLifecycleActions returns the instance IDs of the completed lifecycle actions.
+func (f *fakeAWSAPI) getCompletedLifecycleActions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.completedLifecycleActions)
+}
+
+// getRegions returns the regions of the DescribeInstances requests by Auto Scaling group name.
+func (f *fakeAWSAPI) getRegions() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return maps.Clone(f.regions)
+}
+
+// getRequestRegion returns the region of the credential scope of the signature of a request.
+func getRequestRegion(r *http.Request) string {
+	_, credential, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+	scope := strings.Split(credential, "/")
+	if len(scope) < 3 {
+		return ""
+	}
+
+	return scope[2]
+}
+
+// matchesFakeEC2Filters returns true if the instance of the Auto Scaling group matches the tag: and instance-state-name
+// filters.
+func matchesFakeEC2Filters(ins fakeEC2Instance, group string, filters map[string][]string) bool {
+	for name, values := range filters {
+		var value string
+		var ok bool
+		switch {
+		case name == "instance-state-name":
+			value, ok = ins.state, true
+		case name == "tag:aws:autoscaling:groupName":
+			value, ok = group, group != ""
+		case strings.HasPrefix(name, "tag:"):
+			value, ok = ins.tags[strings.TrimPrefix(name, "tag:")]
+		default:
+			continue
+		}
+		if !ok || !slices.Contains(values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getQueryFilters returns the values of the Filter.N.Name and Filter.N.Value.M parameters of a query API request by name.
+func getQueryFilters(r *http.Request) map[string][]string {
+	filters := make(map[string][]string)
+	for i := 1; r.Form.Has(fmt.Sprintf("Filter.%d.Name", i)); i++ {
+		name := r.Form.Get(fmt.Sprintf("Filter.%d.Name", i))
+		for j := 1; r.Form.Has(fmt.Sprintf("Filter.%d.Value.%d", i, j)); j++ {
+			filters[name] = append(filters[name], r.Form.Get(fmt.Sprintf("Filter.%d.Value.%d", i, j)))
+		}
+	}
+
+	return filters
+}
+
+// newTestAWSClient starts the fake AWS API and returns an AWSClient for the config connected to it.
+func newTestAWSClient(t *testing.T, api *fakeAWSAPI, cfg *awsConfig) *AWSClient {
+	t.Helper()
+	server := httptest.NewServer(api.handler())
+	t.Cleanup(server.Close)
+
+	return &AWSClient{
+		config:           cfg,
+		lifecycleActions: &lifecycleActions{actions: make(map[string]*lifecycleAction)},
+		services:         make(map[awsServicesKey]*awsServices),
+		awsConfig: aws.Config{
+			BaseEndpoint: aws.String(server.URL),
+			// the requests are signed for the fake API to know their region
+			Credentials: credentials.NewStaticCredentialsProvider("AKIAFAKE", "secret", ""),
+			HTTPClient:  server.Client(),
+		},
+	}
+}
+
+// fakeSQSMessage is a message of the queue of the fakeSQSAPI.
+type fakeSQSMessage struct {
+	body string
+	// received is true if the message was received and is invisible until it is deleted.
+	received bool
+}
+
+// fakeSQSAPI is an in-process stand-in for the SQS JSON API with a single queue, serving ReceiveMessage and DeleteMessage.
+type fakeSQSAPI struct {
+	// messages maps the receipt handle of a message to the message.
+	messages map[string]*fakeSQSMessage
+	nextID   int
+	mu       sync.Mutex
+}
+
+func newFakeSQSAPI() *fakeSQSAPI {
+	return &fakeSQSAPI{messages: make(map[string]*fakeSQSMessage)}
+}
+
+func (f *fakeSQSAPI) sendMessage(body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	f.messages[strconv.Itoa(f.nextID)] = &fakeSQSMessage{body: body}
+}
+
+// messageCount returns the number of messages in the queue that weren't deleted.
+func (f *fakeSQSAPI) messageCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.messages)
+}
+
+func (f *fakeSQSAPI) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var output any
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			messages := f.receiveMessages()
+			if len(messages) == 0 {
+				// a short long polling keeps the consumer from spinning
+				time.Sleep(10 * time.Millisecond)
+			}
+			output = map[string]any{"Messages": messages}
+		case "AmazonSQS.DeleteMessage":
+			f.deleteMessage(fmt.Sprint(input["ReceiptHandle"]))
+			output = map[string]any{}
+		default:
+			http.Error(w, "unsupported target "+r.Header.Get("X-Amz-Target"), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_ = json.NewEncoder(w).Encode(output)
+	})
+}
+
+// receiveMessages returns the messages that weren't received yet and makes them invisible.
+func (f *fakeSQSAPI) receiveMessages() []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := []map[string]string{}
+	for _, handle := range slices.Sorted(maps.Keys(f.messages)) {
+		if m := f.messages[handle]; !m.received {
+			m.received = true
+			messages = append(messages, map[string]string{"MessageId": handle, "ReceiptHandle": handle, "Body": m.body})
+		}
+	}
+
+	return messages
+}
+
+func (f *fakeSQSAPI) deleteMessage(handle string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.messages, handle)
+}
+
+// newTestSQSClient starts the fake SQS API and returns an SQS client connected to it.
+func newTestSQSClient(t *testing.T, api *fakeSQSAPI) *sqs.Client {
+	t.Helper()
+	server := httptest.NewServer(api.handler())
+	t.Cleanup(server.Close)
+
+	return sqs.New(sqs.Options{
+		Region:                           "us-west-2",
+		BaseEndpoint:                     aws.String(server.URL),
+		Credentials:                      aws.AnonymousCredentials{},
+		HTTPClient:                       server.Client(),
+		DisableMessageChecksumValidation: true,
+	})
+}
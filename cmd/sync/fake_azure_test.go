@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	computefake "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	networkfake "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6/fake"
+)
+
+// fakeAzureVM is a Virtual Machine in the fakeAzureAPI.
+type fakeAzureVM struct {
+	tags map[string]string
+	// nicTags are the tags of the network interface of the Virtual Machine.
+	nicTags map[string]string
+	// health is the code of the status of the Application Health extension of the Virtual Machine, if it is installed.
+	health string
+	ip     string
+	// ipv6 is the address of the IPv6 configuration of the network interface of the Virtual Machine, if it has one.
+	ipv6 string
+	// statuses are the codes of the statuses of the instance view of the Virtual Machine.
+	statuses []string
+}
+
+// newFakeAzureInstanceView returns the instance view of the Virtual Machine.
+func newFakeAzureInstanceView(vm fakeAzureVM) *armcompute.VirtualMachineScaleSetVMInstanceView {
+	instanceView := &armcompute.VirtualMachineScaleSetVMInstanceView{}
+	for _, code := range vm.statuses {
+		instanceView.Statuses = append(instanceView.Statuses, &armcompute.InstanceViewStatus{Code: to.Ptr(code)})
+	}
+	if vm.health != "" {
+		instanceView.VMHealth = &armcompute.VirtualMachineHealthStatus{Status: &armcompute.InstanceViewStatus{Code: to.Ptr(vm.health)}}
+	}
+
+	return instanceView
+}
+
+// fakeAzureAPI is an in-process stand-in for the Azure compute and network APIs, built on the fake servers of the SDK.
+type fakeAzureAPI struct {
+	// scaleSets maps a Virtual Machine Scale Set name to its Virtual Machines.
+	scaleSets map[string][]fakeAzureVM
+	// flexibleScaleSets are the names of the Virtual Machine Scale Sets in the Flexible orchestration mode.
+	flexibleScaleSets map[string]bool
+	// subscriptions maps a resource group name to the subscription ID of the requests for its resources.
+	subscriptions map[string]string
+	// vms are the Virtual Machines that aren't in a Virtual Machine Scale Set.
+	vms []fakeAzureVM
+	// vmListCalls is the number of calls to list the Virtual Machines of a Virtual Machine Scale Set.
+	vmListCalls int
+	// scaleSetGetCalls is the number of calls to get a Virtual Machine Scale Set.
+	scaleSetGetCalls int
+}
+
+func newFakeAzureAPI() *fakeAzureAPI {
+	return &fakeAzureAPI{
+		scaleSets:         make(map[string][]fakeAzureVM),
+		flexibleScaleSets: make(map[string]bool),
+		subscriptions:     make(map[string]string),
+	}
+}
+
+func getFakeAzureScaleSetID(resourceGroupName string, vmssName string) string {
+	return fmt.Sprintf("/subscriptions/sub/resourceGroups/%v/providers/Microsoft.Compute/virtualMachineScaleSets/%v", resourceGroupName, vmssName)
+}
+
+func getFakeAzureVMID(resourceGroupName string, vmssName string, i int) string {
+	return fmt.Sprintf("/subscriptions/sub/resourceGroups/%v/providers/Microsoft.Compute/virtualMachineScaleSets/%v/virtualMachines/%d",
+		resourceGroupName, vmssName, i)
+}
+
+func getFakeAzureStandaloneVMID(resourceGroupName string, i int) string {
+	return fmt.Sprintf("/subscriptions/sub/resourceGroups/%v/providers/Microsoft.Compute/virtualMachines/vm-%d", resourceGroupName, i)
+}
+
+// getFakeAzureFlexibleVMID returns the ID of a Virtual Machine of a Virtual Machine Scale Set in the Flexible
+// orchestration mode, which is the ID of a Virtual Machine that isn't in a Virtual Machine Scale Set.
+func getFakeAzureFlexibleVMID(resourceGroupName string, vmssName string, i int) string {
+	return fmt.Sprintf("/subscriptions/sub/resourceGroups/%v/providers/Microsoft.Compute/virtualMachines/%v_%d", resourceGroupName, vmssName, i)
+}
+
+func getFakeAzureTags(tags map[string]string) map[string]*string {
+	result := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		result[k] = to.Ptr(v)
+	}
+
+	return result
+}
+
+// newFakeAzureInterface returns the network interface of the Virtual Machine with the ID.
+func newFakeAzureInterface(vmID string, vm fakeAzureVM) *armnetwork.Interface {
+	iFace := &armnetwork.Interface{
+		Tags: getFakeAzureTags(vm.nicTags),
+		Properties: &armnetwork.InterfacePropertiesFormat{
+			// the IDs in the network API don't always have the same case as in the compute API
+			VirtualMachine: &armnetwork.SubResource{ID: to.Ptr(strings.ToLower(vmID))},
+			IPConfigurations: []*armnetwork.InterfaceIPConfiguration{{
+				Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+					Primary:                 to.Ptr(true),
+					PrivateIPAddress:        to.Ptr(vm.ip),
+					PrivateIPAddressVersion: to.Ptr(armnetwork.IPVersionIPv4),
+				},
+			}},
+		},
+	}
+	if vm.ipv6 != "" {
+		iFace.Properties.IPConfigurations = append(iFace.Properties.IPConfigurations, &armnetwork.InterfaceIPConfiguration{
+			Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+				Primary:                 to.Ptr(false),
+				PrivateIPAddress:        to.Ptr(vm.ipv6),
+				PrivateIPAddressVersion: to.Ptr(armnetwork.IPVersionIPv6),
+			},
+		})
+	}
+
+	return iFace
+}
+
+func (f *fakeAzureAPI) getScaleSet(_ context.Context, resourceGroupName string, vmssName string, _ *armcompute.VirtualMachineScaleSetsClientGetOptions) (azfake.Responder[armcompute.VirtualMachineScaleSetsClientGetResponse], azfake.ErrorResponder) {
+	f.scaleSetGetCalls++
+
+	var resp azfake.Responder[armcompute.VirtualMachineScaleSetsClientGetResponse]
+	var errResp azfake.ErrorResponder
+
+	if _, ok := f.scaleSets[vmssName]; !ok {
+		errResp.SetResponseError(http.StatusNotFound, "ResourceNotFound")
+		return resp, errResp
+	}
+
+	mode := armcompute.OrchestrationModeUniform
+	if f.flexibleScaleSets[vmssName] {
+		mode = armcompute.OrchestrationModeFlexible
+	}
+	resp.SetResponse(http.StatusOK, armcompute.VirtualMachineScaleSetsClientGetResponse{
+		VirtualMachineScaleSet: armcompute.VirtualMachineScaleSet{
+			ID:         to.Ptr(getFakeAzureScaleSetID(resourceGroupName, vmssName)),
+			Name:       &vmssName,
+			Properties: &armcompute.VirtualMachineScaleSetProperties{OrchestrationMode: &mode},
+		},
+	}, nil)
+	return resp, errResp
+}
+
+func (f *fakeAzureAPI) listScaleSetVMs(resourceGroupName string, vmssName string, options *armcompute.VirtualMachineScaleSetVMsClientListOptions) azfake.PagerResponder[armcompute.VirtualMachineScaleSetVMsClientListResponse] {
+	f.vmListCalls++
+	instanceView := options != nil && options.Expand != nil && *options.Expand == "instanceView"
+
+	var resp azfake.PagerResponder[armcompute.VirtualMachineScaleSetVMsClientListResponse]
+	for i, vm := range f.scaleSets[vmssName] {
+		scaleSetVM := &armcompute.VirtualMachineScaleSetVM{ID: to.Ptr(getFakeAzureVMID(resourceGroupName, vmssName, i)), Tags: getFakeAzureTags(vm.tags)}
+		if instanceView {
+			scaleSetVM.Properties = &armcompute.VirtualMachineScaleSetVMProperties{InstanceView: newFakeAzureInstanceView(vm)}
+		}
+		resp.AddPage(http.StatusOK, armcompute.VirtualMachineScaleSetVMsClientListResponse{
+			VirtualMachineScaleSetVMListResult: armcompute.VirtualMachineScaleSetVMListResult{
+				Value: []*armcompute.VirtualMachineScaleSetVM{scaleSetVM},
+			},
+		}, nil)
+	}
+
+	return resp
+}
+
+func (f *fakeAzureAPI) listScaleSetNetworkInterfaces(resourceGroupName string, vmssName string, _ *armnetwork.InterfacesClientListVirtualMachineScaleSetNetworkInterfacesOptions) azfake.PagerResponder[armnetwork.InterfacesClientListVirtualMachineScaleSetNetworkInterfacesResponse] {
+	var resp azfake.PagerResponder[armnetwork.InterfacesClientListVirtualMachineScaleSetNetworkInterfacesResponse]
+	if f.flexibleScaleSets[vmssName] {
+		// the network interfaces of a Virtual Machine Scale Set in the Flexible orchestration mode aren't listed
+		resp.AddPage(http.StatusOK, armnetwork.InterfacesClientListVirtualMachineScaleSetNetworkInterfacesResponse{}, nil)
+		return resp
+	}
+	for i, vm := range f.scaleSets[vmssName] {
+		resp.AddPage(http.StatusOK, armnetwork.InterfacesClientListVirtualMachineScaleSetNetworkInterfacesResponse{
+			InterfaceListResult: armnetwork.InterfaceListResult{
+				Value: []*armnetwork.Interface{newFakeAzureInterface(getFakeAzureVMID(resourceGroupName, vmssName, i), vm)},
+			},
+		}, nil)
+	}
+
+	return resp
+}
+
+// listVMs lists the Virtual Machines of the resource group, or of the Virtual Machine Scale Set in the Flexible
+// orchestration mode of the filter.
+func (f *fakeAzureAPI) listVMs(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) azfake.PagerResponder[armcompute.VirtualMachinesClientListResponse] {
+	var resp azfake.PagerResponder[armcompute.VirtualMachinesClientListResponse]
+	var vms []*armcompute.VirtualMachine
+	if options == nil || options.Filter == nil {
+		for i, vm := range f.vms {
+			vms = append(vms, &armcompute.VirtualMachine{ID: to.Ptr(getFakeAzureStandaloneVMID(resourceGroupName, i)), Tags: getFakeAzureTags(vm.tags)})
+		}
+	}
+	for vmssName := range f.flexibleScaleSets {
+		if options != nil && options.Filter != nil && *options.Filter != fmt.Sprintf("'virtualMachineScaleSet/id' eq '%v'", getFakeAzureScaleSetID(resourceGroupName, vmssName)) {
+			continue
+		}
+		for i, vm := range f.scaleSets[vmssName] {
+			flexibleVM := &armcompute.VirtualMachine{ID: to.Ptr(getFakeAzureFlexibleVMID(resourceGroupName, vmssName, i)), Tags: getFakeAzureTags(vm.tags)}
+			if options != nil && options.Expand != nil && *options.Expand == armcompute.ExpandTypeForListVMsInstanceView {
+				instanceView := newFakeAzureInstanceView(vm)
+				flexibleVM.Properties = &armcompute.VirtualMachineProperties{
+					InstanceView: &armcompute.VirtualMachineInstanceView{Statuses: instanceView.Statuses, VMHealth: instanceView.VMHealth},
+				}
+			}
+			vms = append(vms, flexibleVM)
+		}
+	}
+	resp.AddPage(http.StatusOK, armcompute.VirtualMachinesClientListResponse{
+		VirtualMachineListResult: armcompute.VirtualMachineListResult{Value: vms},
+	}, nil)
+
+	return resp
+}
+
+func (f *fakeAzureAPI) listNetworkInterfaces(resourceGroupName string, _ *armnetwork.InterfacesClientListOptions) azfake.PagerResponder[armnetwork.InterfacesClientListResponse] {
+	var resp azfake.PagerResponder[armnetwork.InterfacesClientListResponse]
+	var iFaces []*armnetwork.Interface
+	for i, vm := range f.vms {
+		iFaces = append(iFaces, newFakeAzureInterface(getFakeAzureStandaloneVMID(resourceGroupName, i), vm))
+	}
+	for vmssName := range f.flexibleScaleSets {
+		for i, vm := range f.scaleSets[vmssName] {
+			iFaces = append(iFaces, newFakeAzureInterface(getFakeAzureFlexibleVMID(resourceGroupName, vmssName, i), vm))
+		}
+	}
+	resp.AddPage(http.StatusOK, armnetwork.InterfacesClientListResponse{
+		InterfaceListResult: armnetwork.InterfaceListResult{Value: iFaces},
+	}, nil)
+
+	return resp
+}
+
+// newTestAzureClient returns an AzureClient for the config connected to the fake Azure API.
+func newTestAzureClient(t *testing.T, api *fakeAzureAPI, cfg *azureConfig) *AzureClient {
+	t.Helper()
+
+	computeTransport := computefake.NewServerFactoryTransport(&computefake.ServerFactory{
+		VirtualMachineScaleSetsServer:   computefake.VirtualMachineScaleSetsServer{Get: api.getScaleSet},
+		VirtualMachineScaleSetVMsServer: computefake.VirtualMachineScaleSetVMsServer{NewListPager: api.listScaleSetVMs},
+		VirtualMachinesServer:           computefake.VirtualMachinesServer{NewListPager: api.listVMs},
+	})
+	networkTransport := networkfake.NewInterfacesServerTransport(&networkfake.InterfacesServer{
+		NewListPager: api.listNetworkInterfaces,
+		NewListVirtualMachineScaleSetNetworkInterfacesPager: api.listScaleSetNetworkInterfaces,
+	})
+
+	client := &AzureClient{config: cfg}
+	err := client.createClients(&azfake.TokenCredential{}, &arm.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: &fakeAzureTransport{api: api, compute: computeTransport, network: networkTransport}},
+	})
+	if err != nil {
+		t.Fatalf("couldn't create the Azure clients: %v", err)
+	}
+
+	return client
+}
+
+// fakeAzureTransport routes the requests of the compute and network clients to their fake servers. The network
+// interfaces of a Virtual Machine Scale Set are listed under the Microsoft.Compute provider, so the routing uses the path.
+type fakeAzureTransport struct {
+	api     *fakeAzureAPI
+	compute *computefake.ServerFactoryTransport
+	network *networkfake.InterfacesServerTransport
+}
+
+func (f *fakeAzureTransport) Do(req *http.Request) (*http.Response, error) {
+	// the path starts with /subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}
+	if parts := strings.Split(req.URL.Path, "/"); len(parts) > 4 {
+		f.api.subscriptions[parts[4]] = parts[2]
+	}
+
+	var resp *http.Response
+	var err error
+	if strings.Contains(req.URL.Path, "/networkInterfaces") {
+		resp, err = f.network.Do(req)
+	} else {
+		resp, err = f.compute.Do(req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fake Azure API: %w", err)
+	}
+
+	return resp, nil
+}
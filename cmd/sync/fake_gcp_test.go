@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeComputeAPI is a minimal in-process stand-in for the parts of the Compute Engine API used by GCPClient.
+// Managed instances are listed one per page to exercise paging. The instances are in the zone us-central1-a.
+type fakeComputeAPI struct {
+	// groups maps a Managed Instance Group name to its instance names.
+	groups map[string][]string
+	// ips maps an instance name to its primary private IP.
+	ips map[string]string
+	// ipv6s maps an instance name to the internal IPv6 address of its first network interface.
+	ipv6s map[string]string
+	// actions maps an instance name to the current action of its managed instance.
+	actions map[string]string
+	// listCalls is the number of requests to list instances.
+	listCalls int
+	mu        sync.Mutex
+}
+
+func (f *fakeComputeAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /compute/v1/projects/{project}/zones/{zone}/instanceGroupManagers/{mig}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := f.groups[r.PathValue("mig")]; !ok {
+			writeGoogleAPIError(w, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, &compute.InstanceGroupManager{Name: r.PathValue("mig")})
+	})
+	mux.HandleFunc("POST /compute/v1/projects/{project}/zones/{zone}/instanceGroupManagers/{mig}/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		managedInstances, nextPageToken, ok := f.listManagedInstances(r)
+		if !ok {
+			writeGoogleAPIError(w, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, &compute.InstanceGroupManagersListManagedInstancesResponse{ManagedInstances: managedInstances, NextPageToken: nextPageToken})
+	})
+	mux.HandleFunc("POST /compute/v1/projects/{project}/regions/{region}/instanceGroupManagers/{mig}/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		managedInstances, nextPageToken, ok := f.listManagedInstances(r)
+		if !ok {
+			writeGoogleAPIError(w, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, &compute.RegionInstanceGroupManagersListInstancesResponse{ManagedInstances: managedInstances, NextPageToken: nextPageToken})
+	})
+	mux.HandleFunc("GET /compute/v1/projects/{project}/zones/{zone}/instances", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &compute.InstanceList{Items: f.listInstances(r)})
+	})
+	mux.HandleFunc("GET /compute/v1/projects/{project}/aggregated/instances", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &compute.InstanceAggregatedList{Items: map[string]compute.InstancesScopedList{
+			"zones/us-central1-a": {Instances: f.listInstances(r)},
+			"zones/us-central1-b": {},
+		}})
+	})
+	return mux
+}
+
+// listManagedInstances returns the page of the managed instances of the group of the request.
+func (f *fakeComputeAPI) listManagedInstances(r *http.Request) ([]*compute.ManagedInstance, string, bool) {
+	instances, ok := f.groups[r.PathValue("mig")]
+	if !ok {
+		return nil, "", false
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	var managedInstances []*compute.ManagedInstance
+	if page < len(instances) {
+		managedInstances = []*compute.ManagedInstance{{
+			Instance: fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%v/zones/us-central1-a/instances/%v",
+				r.PathValue("project"), instances[page]),
+			CurrentAction: f.actions[instances[page]],
+		}}
+	}
+	var nextPageToken string
+	if page+1 < len(instances) {
+		nextPageToken = strconv.Itoa(page + 1)
+	}
+
+	return managedInstances, nextPageToken, true
+}
+
+// listInstances returns the instances that match the name filter of the request.
+func (f *fakeComputeAPI) listInstances(r *http.Request) []*compute.Instance {
+	f.mu.Lock()
+	f.listCalls++
+	f.mu.Unlock()
+
+	pattern, err := strconv.Unquote(strings.TrimPrefix(r.URL.Query().Get("filter"), "name eq "))
+	if err != nil {
+		return nil
+	}
+	re := regexp.MustCompile("^(?:" + pattern + ")$")
+
+	var instances []*compute.Instance
+	for name, ip := range f.ips {
+		if re.MatchString(name) {
+			instances = append(instances, &compute.Instance{
+				Name:              name,
+				NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: ip, Ipv6Address: f.ipv6s[name]}},
+			})
+		}
+	}
+
+	return instances
+}
+
+func (f *fakeComputeAPI) getListCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.listCalls
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeGoogleAPIError(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(w, `{"error":{"code":%d,"message":"%v"}}`, code, http.StatusText(code))
+}
+
+func newTestGCPClient(t *testing.T, api *fakeComputeAPI) *GCPClient {
+	t.Helper()
+	server := httptest.NewServer(api.handler())
+	t.Cleanup(server.Close)
+
+	client := &GCPClient{config: getValidGCPConfig()}
+	err := client.configure(context.Background(), option.WithEndpoint(server.URL+"/compute/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("configure() failed: %v", err)
+	}
+
+	return client
+}
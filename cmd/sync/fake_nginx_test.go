@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v2/client"
+)
+
+// fakeNginxPlusAPI is an in-process stand-in for the upstream servers endpoints of the NGINX Plus API.
+// HTTP and stream servers are both stored as nginx.UpstreamServer, the stream API simply ignores the HTTP only fields.
+type fakeNginxPlusAPI struct {
+	// upstreams maps the kind of an upstream (http or stream) and its name to its servers.
+	upstreams map[string]map[string][]nginx.UpstreamServer
+	// active maps a server address to its number of active connections reported in the upstream stats.
+	active map[string]uint64
+	nextID int
+	mu     sync.Mutex
+}
+
+func newFakeNginxPlusAPI() *fakeNginxPlusAPI {
+	return &fakeNginxPlusAPI{
+		upstreams: map[string]map[string][]nginx.UpstreamServer{
+			"http":   {},
+			"stream": {},
+		},
+		active: make(map[string]uint64),
+	}
+}
+
+// addUpstream creates an upstream of the kind with the servers.
+func (f *fakeNginxPlusAPI) addUpstream(kind string, name string, servers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.upstreams[kind][name] = []nginx.UpstreamServer{}
+	for _, s := range servers {
+		f.nextID++
+		f.upstreams[kind][name] = append(f.upstreams[kind][name], nginx.UpstreamServer{ID: f.nextID, Server: s})
+	}
+}
+
+// addServers adds the servers with their parameters to the upstream of the kind.
+func (f *fakeNginxPlusAPI) addServers(kind string, name string, servers ...nginx.UpstreamServer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range servers {
+		f.nextID++
+		s.ID = f.nextID
+		f.upstreams[kind][name] = append(f.upstreams[kind][name], s)
+	}
+}
+
+// servers returns the sorted addresses of the servers of the upstream.
+func (f *fakeNginxPlusAPI) servers(kind string, name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	addresses := make([]string, 0, len(f.upstreams[kind][name]))
+	for _, s := range f.upstreams[kind][name] {
+		addresses = append(addresses, s.Server)
+	}
+	slices.Sort(addresses)
+
+	return addresses
+}
+
+// setActiveConnections sets the number of active connections of the server.
+func (f *fakeNginxPlusAPI) setActiveConnections(address string, active uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.active[address] = active
+}
+
+// server returns the server of the upstream with the address.
+func (f *fakeNginxPlusAPI) server(kind string, name string, address string) (nginx.UpstreamServer, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.upstreams[kind][name] {
+		if s.Server == address {
+			return s, true
+		}
+	}
+
+	return nginx.UpstreamServer{}, false
+}
+
+func (f *fakeNginxPlusAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/{$}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, []int{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	})
+	mux.HandleFunc("GET /api/{version}/http/upstreams", f.getUpstreams)
+	mux.HandleFunc("GET /api/{version}/{kind}/upstreams/{name}/servers", f.getServers)
+	mux.HandleFunc("POST /api/{version}/{kind}/upstreams/{name}/servers/{$}", f.addServer)
+	mux.HandleFunc("PATCH /api/{version}/{kind}/upstreams/{name}/servers/{id}/{$}", f.updateServer)
+	mux.HandleFunc("DELETE /api/{version}/{kind}/upstreams/{name}/servers/{id}/{$}", f.deleteServer)
+	return mux
+}
+
+func (f *fakeNginxPlusAPI) getUpstreams(w http.ResponseWriter, _ *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upstreams := make(nginx.Upstreams)
+	for name, servers := range f.upstreams["http"] {
+		peers := make([]nginx.Peer, 0, len(servers))
+		for _, s := range servers {
+			state := "up"
+			if s.Drain {
+				state = "draining"
+			}
+			peers = append(peers, nginx.Peer{ID: s.ID, Server: s.Server, State: state, Active: f.active[s.Server]})
+		}
+		upstreams[name] = nginx.Upstream{Zone: name, Peers: peers}
+	}
+
+	writeJSON(w, upstreams)
+}
+
+func (f *fakeNginxPlusAPI) getServers(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	servers, ok := f.upstreams[r.PathValue("kind")][r.PathValue("name")]
+	if !ok {
+		writeNginxAPIError(w, http.StatusNotFound, "UpstreamNotFound")
+		return
+	}
+
+	writeJSON(w, servers)
+}
+
+func (f *fakeNginxPlusAPI) addServer(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	kind, name := r.PathValue("kind"), r.PathValue("name")
+	if _, ok := f.upstreams[kind][name]; !ok {
+		writeNginxAPIError(w, http.StatusNotFound, "UpstreamNotFound")
+		return
+	}
+
+	var server nginx.UpstreamServer
+	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+		writeNginxAPIError(w, http.StatusBadRequest, "UpstreamConfFormatError")
+		return
+	}
+
+	f.nextID++
+	server.ID = f.nextID
+	f.upstreams[kind][name] = append(f.upstreams[kind][name], server)
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakeNginxPlusAPI) updateServer(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	kind, name := r.PathValue("kind"), r.PathValue("name")
+	i := f.indexOfServer(kind, name, r.PathValue("id"))
+	if i == -1 {
+		writeNginxAPIError(w, http.StatusNotFound, "UpstreamServerNotFound")
+		return
+	}
+
+	// the client always sends every parameter of the server, so the server is replaced rather than merged
+	var server nginx.UpstreamServer
+	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+		writeNginxAPIError(w, http.StatusBadRequest, "UpstreamConfFormatError")
+		return
+	}
+	server.ID = f.upstreams[kind][name][i].ID
+	f.upstreams[kind][name][i] = server
+
+	writeJSON(w, server)
+}
+
+func (f *fakeNginxPlusAPI) deleteServer(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	kind, name := r.PathValue("kind"), r.PathValue("name")
+	i := f.indexOfServer(kind, name, r.PathValue("id"))
+	if i == -1 {
+		writeNginxAPIError(w, http.StatusNotFound, "UpstreamServerNotFound")
+		return
+	}
+	f.upstreams[kind][name] = slices.Delete(f.upstreams[kind][name], i, i+1)
+
+	writeJSON(w, f.upstreams[kind][name])
+}
+
+func (f *fakeNginxPlusAPI) indexOfServer(kind string, name string, id string) int {
+	serverID, err := strconv.Atoi(id)
+	if err != nil {
+		return -1
+	}
+
+	return slices.IndexFunc(f.upstreams[kind][name], func(s nginx.UpstreamServer) bool {
+		return s.ID == serverID
+	})
+}
+
+func writeNginxAPIError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `{"error":{"status":%d,"text":"%v","code":"%v"},"request_id":"fake","href":"https://nginx.org/en/docs/http/ngx_http_api_module.html"}`,
+		status, http.StatusText(status), code)
+}
+
+// newTestNginxClient starts the fake NGINX Plus API and returns a client connected to it.
+func newTestNginxClient(t *testing.T, api *fakeNginxPlusAPI) *nginx.NginxClient {
+	t.Helper()
+	server := httptest.NewServer(api.handler())
+	t.Cleanup(server.Close)
+
+	client, err := nginx.NewNginxClient(server.URL+"/api", nginx.WithHTTPClient(server.Client()), nginx.WithCheckAPI())
+	if err != nil {
+		t.Fatalf("couldn't create the NGINX client: %v", err)
+	}
+
+	return client
+}
+
+// newTestNginxEndpoints starts a fake NGINX Plus API for each of the apis and returns the endpoints connected to them,
+// with the addresses nginx-1, nginx-2 and so on.
+func newTestNginxEndpoints(t *testing.T, apis ...*fakeNginxPlusAPI) []NginxEndpoint {
+	t.Helper()
+	endpoints := make([]NginxEndpoint, 0, len(apis))
+	for i, api := range apis {
+		endpoints = append(endpoints, NginxEndpoint{Client: newTestNginxClient(t, api), Address: fmt.Sprintf("nginx-%d", i+1)})
+	}
+
+	return endpoints
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// The current actions of the managed instances that are being removed from a Managed Instance Group.
+const (
+	gcpActionDeleting   = "DELETING"
+	gcpActionAbandoning = "ABANDONING"
+)
+
+// gcpInstanceNamesPerFilter is the maximum number of instance names in the filter of a list of instances, which keeps
+// the URL of the request short. The instances of a larger group are listed with several requests.
+const gcpInstanceNamesPerFilter = 100
+
+// GCPClient allows you to get the list of IP addresses of instances of a Managed Instance Group. It implements the CloudProvider interface.
+type GCPClient struct {
+	svcCompute *compute.Service
+	config     *gcpConfig
+}
+
+// NewGCPClient creates and configures a GCPClient.
+func NewGCPClient(ctx context.Context, data []byte) (*GCPClient, error) {
+	gcpClient := &GCPClient{}
+	cfg, err := parseGCPConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("error validating config: %w", err)
+	}
+	gcpClient.config = cfg
+
+	err = gcpClient.configure(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring GCP Client: %w", err)
+	}
+
+	return gcpClient, nil
+}
+
+// parseGCPConfig parses and validates GCPClient config.
+func parseGCPConfig(data []byte) (*gcpConfig, error) {
+	cfg := &gcpConfig{}
+	err := yaml.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal GCP config: %w", err)
+	}
+
+	err = validateGCPConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// configure configures the GCPClient with necessary parameters. The calls time out like those of the other cloud
+// providers, with the HTTP client authenticated with the options.
+func (client *GCPClient) configure(ctx context.Context, opts ...option.ClientOption) error {
+	opts = append([]option.ClientOption{option.WithScopes(compute.ComputeReadonlyScope)}, opts...)
+	httpClient, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("couldn't create the HTTP client of the compute service: %w", err)
+	}
+	httpClient.Timeout = connTimeoutInSecs * time.Second
+
+	svc, err := compute.NewService(ctx, append(opts, option.WithHTTPClient(httpClient))...)
+	if err != nil {
+		return fmt.Errorf("couldn't create compute service: %w", err)
+	}
+	client.svcCompute = svc
+
+	return nil
+}
+
+// GetUpstreams returns the Upstreams list.
+func (client *GCPClient) GetUpstreams() []Upstream {
+	upstreams := make([]Upstream, 0, len(client.config.Upstreams))
+	for i := range len(client.config.Upstreams) {
+		u := Upstream{
+			Name:                     client.config.Upstreams[i].Name,
+			Port:                     client.config.Upstreams[i].Port,
+			Kind:                     client.config.Upstreams[i].Kind,
+			ScalingGroups:            []ScalingGroup{{Name: client.config.Upstreams[i].ManagedInstanceGroup}},
+			MaxConns:                 &client.config.Upstreams[i].MaxConns,
+			MaxFails:                 &client.config.Upstreams[i].MaxFails,
+			FailTimeout:              getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
+			SlowStart:                getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			DrainTimeout:             client.config.Upstreams[i].DrainTimeout,
+			StaleAfter:               client.config.Upstreams[i].StaleAfter,
+			StalePolicy:              client.config.Upstreams[i].StalePolicy,
+			MinServers:               client.config.Upstreams[i].MinServers,
+			MaxRemovalPercent:        client.config.Upstreams[i].MaxRemovalPercent,
+			ServerParametersFromTags: client.config.Upstreams[i].ServerParametersFromTags,
+			AddressFamily:            getAddressFamilyOrDefault(client.config.Upstreams[i].AddressFamily),
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams
+}
+
+// CheckIfScalingGroupExists checks if the Managed Instance Group exists.
+func (client *GCPClient) CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error) {
+	var err error
+	if client.config.Zone != "" {
+		_, err = client.svcCompute.InstanceGroupManagers.Get(client.config.ProjectID, client.config.Zone, name).Context(ctx).Do()
+	} else {
+		_, err = client.svcCompute.RegionInstanceGroupManagers.Get(client.config.ProjectID, client.config.Region, name).Context(ctx).Do()
+	}
+
+	if isGoogleAPINotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("couldn't check if a Managed Instance Group exists: %w", err)
+	}
+
+	return true, nil
+}
+
+// GetScalingGroupLocation returns the project and the zone or the region in which the Managed Instance Group is looked up.
+func (client *GCPClient) GetScalingGroupLocation(_ string) string {
+	return client.config.ProjectID + "/" + client.config.Zone + client.config.Region
+}
+
+// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Managed Instance Group, with their labels as tags.
+func (client *GCPClient) GetPrivateIPsForScalingGroup(ctx context.Context, name string) ([]Instance, error) {
+	managedInstances, err := client.listManagedInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the instances are identified by their zone and name, as the instances of a regional group are in several zones
+	var keys, names []string
+	for _, ins := range managedInstances {
+		if ins.Instance == "" {
+			// the instance hasn't been created yet
+			continue
+		}
+		if ins.CurrentAction == gcpActionDeleting || ins.CurrentAction == gcpActionAbandoning {
+			// the instance is being removed from the group
+			continue
+		}
+
+		zone, instanceName, err := parseInstanceURL(ins.Instance)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, zone+"/"+instanceName)
+		names = append(names, instanceName)
+	}
+
+	found := make(map[string]*compute.Instance)
+	for chunk := range slices.Chunk(names, gcpInstanceNamesPerFilter) {
+		err = client.listInstances(ctx, name, getInstanceNamesFilter(chunk), func(zone string, instances []*compute.Instance) {
+			for _, instance := range instances {
+				found[zone+"/"+instance.Name] = instance
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var instances []Instance
+	for _, key := range keys {
+		instance, ok := found[key]
+		if !ok {
+			// the instance was deleted after the group was listed
+			continue
+		}
+
+		ip, ipv6 := getPrimaryIPFromNetworkInterfaces(instance.NetworkInterfaces), getIPv6FromNetworkInterfaces(instance.NetworkInterfaces)
+		if ip == "" && ipv6 == "" {
+			continue
+		}
+		ins := newInstance(ip, instance.Labels)
+		ins.IPv6 = ipv6
+		instances = append(instances, ins)
+	}
+
+	return instances, nil
+}
+
+// listInstances lists the instances of the Managed Instance Group that match the filter in the zone, or in every zone,
+// and calls add with the instances of each zone.
+func (client *GCPClient) listInstances(ctx context.Context, name string, filter string, add func(zone string, instances []*compute.Instance)) error {
+	var err error
+	if client.config.Zone != "" {
+		err = client.svcCompute.Instances.List(client.config.ProjectID, client.config.Zone).Filter(filter).
+			Pages(ctx, func(resp *compute.InstanceList) error {
+				add(client.config.Zone, resp.Items)
+				return nil
+			})
+	} else {
+		err = client.svcCompute.Instances.AggregatedList(client.config.ProjectID).Filter(filter).
+			Pages(ctx, func(resp *compute.InstanceAggregatedList) error {
+				for scope, list := range resp.Items {
+					// the instances are listed per scope, e.g. zones/us-central1-a
+					if zone, ok := strings.CutPrefix(scope, "zones/"); ok {
+						add(zone, list.Instances)
+					}
+				}
+				return nil
+			})
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't list instances of %v: %w", name, err)
+	}
+
+	return nil
+}
+
+// getInstanceNamesFilter returns the filter of a list of instances that matches the instances with the names.
+func getInstanceNamesFilter(names []string) string {
+	patterns := make([]string, 0, len(names))
+	for _, name := range names {
+		patterns = append(patterns, regexp.QuoteMeta(name))
+	}
+
+	return fmt.Sprintf("name eq %q", strings.Join(patterns, "|"))
+}
+
+func (client *GCPClient) listManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	var result []*compute.ManagedInstance
+
+	var err error
+	if client.config.Zone != "" {
+		err = client.svcCompute.InstanceGroupManagers.ListManagedInstances(client.config.ProjectID, client.config.Zone, name).
+			Pages(ctx, func(resp *compute.InstanceGroupManagersListManagedInstancesResponse) error {
+				result = append(result, resp.ManagedInstances...)
+				return nil
+			})
+	} else {
+		err = client.svcCompute.RegionInstanceGroupManagers.ListManagedInstances(client.config.ProjectID, client.config.Region, name).
+			Pages(ctx, func(resp *compute.RegionInstanceGroupManagersListInstancesResponse) error {
+				result = append(result, resp.ManagedInstances...)
+				return nil
+			})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list managed instances of %v: %w", name, err)
+	}
+
+	return result, nil
+}
+
+func getPrimaryIPFromNetworkInterfaces(nics []*compute.NetworkInterface) string {
+	if len(nics) == 0 || nics[0] == nil {
+		return ""
+	}
+
+	return nics[0].NetworkIP
+}
+
+// getIPv6FromNetworkInterfaces returns the internal IPv6 address of the first network interface, if it has one.
+func getIPv6FromNetworkInterfaces(nics []*compute.NetworkInterface) string {
+	if len(nics) == 0 || nics[0] == nil {
+		return ""
+	}
+
+	return nics[0].Ipv6Address
+}
+
+// parseInstanceURL returns the zone and the name of an instance from its URL,
+// e.g. https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/instances/my-instance.
+func parseInstanceURL(url string) (string, string, error) {
+	parts := strings.Split(url, "/")
+	var zone, name string
+	for i := 0; i < len(parts)-1; i++ {
+		switch parts[i] {
+		case "zones":
+			zone = parts[i+1]
+		case "instances":
+			name = parts[i+1]
+		}
+	}
+
+	if zone == "" || name == "" {
+		return "", "", fmt.Errorf("couldn't parse the instance URL %v", url)
+	}
+
+	return zone, name, nil
+}
+
+func isGoogleAPINotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// Configuration for GCP Cloud Provider.
+type gcpConfig struct {
+	ProjectID string        `yaml:"project_id"`
+	Zone      string        `yaml:"zone"`
+	Region    string        `yaml:"region"`
+	Upstreams []gcpUpstream `yaml:"upstreams"`
+}
+
+type gcpUpstream struct {
+	// StaleAfter and StalePolicy override the top-level ones for the upstream.
+	StaleAfter               *time.Duration `yaml:"stale_after"`
+	Name                     string         `yaml:"name"`
+	ManagedInstanceGroup     string         `yaml:"managed_instance_group"`
+	Kind                     string         `yaml:"kind"`
+	FailTimeout              string         `yaml:"fail_timeout"`
+	SlowStart                string         `yaml:"slow_start"`
+	AddressFamily            string         `yaml:"address_family"`
+	StalePolicy              string         `yaml:"stale_policy"`
+	Port                     int            `yaml:"port"`
+	MaxConns                 int            `yaml:"max_conns"`
+	MaxFails                 int            `yaml:"max_fails"`
+	MinServers               int            `yaml:"min_servers"`
+	MaxRemovalPercent        int            `yaml:"max_removal_percent"`
+	DrainTimeout             time.Duration  `yaml:"drain_timeout"`
+	ServerParametersFromTags bool           `yaml:"server_parameters_from_tags"`
+}
+
+func validateGCPConfig(cfg *gcpConfig) error {
+	var errs []error
+
+	if cfg.ProjectID == "" {
+		errs = append(errs, fmt.Errorf(errorMsgFormat, "project_id"))
+	}
+
+	if (cfg.Zone == "") == (cfg.Region == "") {
+		errs = append(errs, errors.New(gcpLocationErrorMsg))
+	}
+
+	if len(cfg.Upstreams) == 0 {
+		errs = append(errs, errors.New("there are no upstreams found in the config file"))
+	}
+
+	for i, ups := range cfg.Upstreams {
+		if ups.Name == "" {
+			errs = append(errs, errors.New(upstreamNameErrorMsg))
+		}
+		if slices.ContainsFunc(cfg.Upstreams[:i], func(u gcpUpstream) bool { return u.Name == ups.Name }) {
+			errs = append(errs, fmt.Errorf(upstreamDuplicateNameErrorMsgFmt, ups.Name))
+		}
+		if ups.ManagedInstanceGroup == "" {
+			errs = append(errs, fmt.Errorf(upstreamErrorMsgFormat, "managed_instance_group", ups.Name))
+		}
+		if ups.Port == 0 {
+			errs = append(errs, fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name))
+		}
+		if ups.Kind == "" || !(ups.Kind == "http" || ups.Kind == "stream") {
+			errs = append(errs, fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name))
+		}
+		if ups.MaxConns < 0 {
+			errs = append(errs, fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns))
+		}
+		if ups.MaxFails < 0 {
+			errs = append(errs, fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails))
+		}
+		if !isValidTime(ups.FailTimeout) {
+			errs = append(errs, fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout))
+		}
+		if !isValidTime(ups.SlowStart) {
+			errs = append(errs, fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart))
+		}
+		if !isValidAddressFamily(ups.AddressFamily) {
+			errs = append(errs, fmt.Errorf(upstreamAddressFamilyErrorMsgFmt, ups.AddressFamily, ups.Name))
+		}
+		if ups.MinServers < 0 {
+			errs = append(errs, fmt.Errorf(upstreamMinServersErrorMsgFmt, ups.MinServers))
+		}
+		if ups.MaxRemovalPercent < 0 || ups.MaxRemovalPercent > 100 {
+			errs = append(errs, fmt.Errorf(upstreamMaxRemovalPercentErrorMsgFmt, ups.MaxRemovalPercent))
+		}
+		if ups.DrainTimeout < 0 {
+			errs = append(errs, fmt.Errorf(upstreamDrainTimeoutErrorMsgFmt, ups.DrainTimeout))
+		}
+		if ups.DrainTimeout > 0 && ups.Kind == "stream" {
+			errs = append(errs, fmt.Errorf(upstreamDrainTimeoutKindErrorMsgFmt, ups.Name))
+		}
+		if ups.StaleAfter != nil && *ups.StaleAfter < 0 {
+			errs = append(errs, fmt.Errorf(upstreamStaleAfterErrorMsgFmt, *ups.StaleAfter, ups.Name))
+		}
+		if ups.StalePolicy != "" && ups.StalePolicy != stalePolicyKeep && ups.StalePolicy != stalePolicyEmpty {
+			errs = append(errs, fmt.Errorf(upstreamStalePolicyErrorMsgFmt, ups.StalePolicy, ups.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testInputGCP struct {
+	cfg *gcpConfig
+	msg string
+}
+
+func getValidGCPConfig() *gcpConfig {
+	upstreams := []gcpUpstream{
+		{
+			Name:                 "backend1",
+			ManagedInstanceGroup: "backend-group",
+			Port:                 80,
+			Kind:                 "http",
+		},
+	}
+	cfg := gcpConfig{
+		ProjectID: "my-project",
+		Zone:      "us-central1-a",
+		Upstreams: upstreams,
+	}
+
+	return &cfg
+}
+
+func getInvalidGCPConfigInput() []*testInputGCP {
+	var input []*testInputGCP
+
+	invalidProjectCfg := getValidGCPConfig()
+	invalidProjectCfg.ProjectID = ""
+	input = append(input, &testInputGCP{invalidProjectCfg, "invalid project id"})
+
+	invalidMissingLocationCfg := getValidGCPConfig()
+	invalidMissingLocationCfg.Zone = ""
+	input = append(input, &testInputGCP{invalidMissingLocationCfg, "no zone or region"})
+
+	invalidZoneAndRegionCfg := getValidGCPConfig()
+	invalidZoneAndRegionCfg.Region = "us-central1"
+	input = append(input, &testInputGCP{invalidZoneAndRegionCfg, "both zone and region"})
+
+	invalidMissingUpstreamsCfg := getValidGCPConfig()
+	invalidMissingUpstreamsCfg.Upstreams = nil
+	input = append(input, &testInputGCP{invalidMissingUpstreamsCfg, "no upstreams"})
+
+	invalidUpstreamNameCfg := getValidGCPConfig()
+	invalidUpstreamNameCfg.Upstreams[0].Name = ""
+	input = append(input, &testInputGCP{invalidUpstreamNameCfg, "invalid name of the upstream"})
+
+	invalidUpstreamDuplicateNameCfg := getValidGCPConfig()
+	invalidUpstreamDuplicateNameCfg.Upstreams = append(invalidUpstreamDuplicateNameCfg.Upstreams, invalidUpstreamDuplicateNameCfg.Upstreams[0])
+	input = append(input, &testInputGCP{invalidUpstreamDuplicateNameCfg, "duplicate name of the upstream"})
+
+	invalidUpstreamMIGCfg := getValidGCPConfig()
+	invalidUpstreamMIGCfg.Upstreams[0].ManagedInstanceGroup = ""
+	input = append(input, &testInputGCP{invalidUpstreamMIGCfg, "invalid managed_instance_group of the upstream"})
+
+	invalidUpstreamPortCfg := getValidGCPConfig()
+	invalidUpstreamPortCfg.Upstreams[0].Port = 0
+	input = append(input, &testInputGCP{invalidUpstreamPortCfg, "invalid port of the upstream"})
+
+	invalidUpstreamKindCfg := getValidGCPConfig()
+	invalidUpstreamKindCfg.Upstreams[0].Kind = ""
+	input = append(input, &testInputGCP{invalidUpstreamKindCfg, "invalid kind of the upstream"})
+
+	invalidUpstreamMaxConnsCfg := getValidGCPConfig()
+	invalidUpstreamMaxConnsCfg.Upstreams[0].MaxConns = -10
+	input = append(input, &testInputGCP{invalidUpstreamMaxConnsCfg, "invalid max_conns of the upstream"})
+
+	invalidUpstreamMaxFailsCfg := getValidGCPConfig()
+	invalidUpstreamMaxFailsCfg.Upstreams[0].MaxFails = -10
+	input = append(input, &testInputGCP{invalidUpstreamMaxFailsCfg, "invalid max_fails of the upstream"})
+
+	invalidUpstreamFailTimeoutCfg := getValidGCPConfig()
+	invalidUpstreamFailTimeoutCfg.Upstreams[0].FailTimeout = "-10s"
+	input = append(input, &testInputGCP{invalidUpstreamFailTimeoutCfg, "invalid fail_timeout of the upstream"})
+
+	invalidUpstreamSlowStartCfg := getValidGCPConfig()
+	invalidUpstreamSlowStartCfg.Upstreams[0].SlowStart = "-10s"
+	input = append(input, &testInputGCP{invalidUpstreamSlowStartCfg, "invalid slow_start of the upstream"})
+
+	invalidUpstreamStaleAfter := -time.Minute
+	invalidUpstreamStaleAfterCfg := getValidGCPConfig()
+	invalidUpstreamStaleAfterCfg.Upstreams[0].StaleAfter = &invalidUpstreamStaleAfter
+	input = append(input, &testInputGCP{invalidUpstreamStaleAfterCfg, "invalid stale_after of the upstream"})
+
+	invalidUpstreamStalePolicyCfg := getValidGCPConfig()
+	invalidUpstreamStalePolicyCfg.Upstreams[0].StalePolicy = "remove"
+	input = append(input, &testInputGCP{invalidUpstreamStalePolicyCfg, "invalid stale_policy of the upstream"})
+
+	invalidUpstreamAddressFamilyCfg := getValidGCPConfig()
+	invalidUpstreamAddressFamilyCfg.Upstreams[0].AddressFamily = "ipv5"
+	input = append(input, &testInputGCP{invalidUpstreamAddressFamilyCfg, "invalid address_family of the upstream"})
+
+	invalidUpstreamMinServersCfg := getValidGCPConfig()
+	invalidUpstreamMinServersCfg.Upstreams[0].MinServers = -1
+	input = append(input, &testInputGCP{invalidUpstreamMinServersCfg, "invalid min_servers of the upstream"})
+
+	invalidUpstreamMaxRemovalPercentCfg := getValidGCPConfig()
+	invalidUpstreamMaxRemovalPercentCfg.Upstreams[0].MaxRemovalPercent = 101
+	input = append(input, &testInputGCP{invalidUpstreamMaxRemovalPercentCfg, "invalid max_removal_percent of the upstream"})
+
+	invalidUpstreamDrainTimeoutCfg := getValidGCPConfig()
+	invalidUpstreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = -10 * time.Second
+	input = append(input, &testInputGCP{invalidUpstreamDrainTimeoutCfg, "invalid drain_timeout of the upstream"})
+
+	invalidUpstreamDrainTimeoutKindCfg := getValidGCPConfig()
+	invalidUpstreamDrainTimeoutKindCfg.Upstreams[0].Kind = "stream"
+	invalidUpstreamDrainTimeoutKindCfg.Upstreams[0].DrainTimeout = 10 * time.Second
+	input = append(input, &testInputGCP{invalidUpstreamDrainTimeoutKindCfg, "drain_timeout for a stream upstream"})
+
+	return input
+}
+
+func TestValidateGCPConfigNotValid(t *testing.T) {
+	t.Parallel()
+	input := getInvalidGCPConfigInput()
+
+	for _, item := range input {
+		err := validateGCPConfig(item.cfg)
+		if err == nil {
+			t.Errorf("validateGCPConfig() didn't fail for the invalid config file with %v", item.msg)
+		}
+	}
+}
+
+func TestValidateGCPConfigValid(t *testing.T) {
+	t.Parallel()
+	cfg := getValidGCPConfig()
+
+	err := validateGCPConfig(cfg)
+	if err != nil {
+		t.Errorf("validateGCPConfig() failed for the valid config: %v", err)
+	}
+}
+
+func TestGetUpstreamsGCP(t *testing.T) {
+	t.Parallel()
+	cfg := getValidGCPConfig()
+	upstreams := []gcpUpstream{
+		{
+			Name:        "127.0.0.1",
+			Port:        80,
+			MaxFails:    1,
+			MaxConns:    2,
+			SlowStart:   "5s",
+			FailTimeout: "10s",
+		},
+		{
+			Name:        "127.0.0.2",
+			Port:        80,
+			MaxFails:    2,
+			MaxConns:    3,
+			SlowStart:   "6s",
+			FailTimeout: "11s",
+		},
+	}
+	cfg.Upstreams = upstreams
+	c := GCPClient{config: cfg}
+
+	ups := c.GetUpstreams()
+	for _, u := range ups {
+		found := false
+		for _, cfgU := range cfg.Upstreams {
+			if u.Name == cfgU.Name {
+				if !areEqualUpstreamsGCP(cfgU, u) {
+					t.Errorf("GetUpstreams() returned a wrong Upstream %+v for the configuration %+v", u, cfgU)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Upstream %+v not found in configuration.", u)
+		}
+	}
+}
+
+func areEqualUpstreamsGCP(u1 gcpUpstream, u2 Upstream) bool {
+	if u1.Port != u2.Port {
+		return false
+	}
+
+	if u1.FailTimeout != u2.FailTimeout {
+		return false
+	}
+
+	if u1.SlowStart != u2.SlowStart {
+		return false
+	}
+
+	if u1.MaxConns != *u2.MaxConns {
+		return false
+	}
+
+	if u1.MaxFails != *u2.MaxFails {
+		return false
+	}
+
+	return true
+}
+
+func TestParseInstanceURL(t *testing.T) {
+	t.Parallel()
+	zone, name, err := parseInstanceURL("https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/instances/instance-1")
+	if err != nil {
+		t.Fatalf("parseInstanceURL() failed for a valid URL: %v", err)
+	}
+	if zone != "us-central1-a" || name != "instance-1" {
+		t.Errorf("parseInstanceURL() returned zone %q and name %q, expected us-central1-a and instance-1", zone, name)
+	}
+
+	_, _, err = parseInstanceURL("projects/my-project/global/networks/default")
+	if err == nil {
+		t.Error("parseInstanceURL() didn't fail for a URL that isn't an instance")
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupGCP(t *testing.T) {
+	t.Parallel()
+	api := &fakeComputeAPI{
+		groups: map[string][]string{
+			"backend-group": {"instance-1", "instance-2", "instance-deleted"},
+		},
+		ips: map[string]string{
+			"instance-1": "10.0.0.1",
+			"instance-2": "10.0.0.2",
+		},
+	}
+	client := newTestGCPClient(t, api)
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed: %v", err)
+	}
+	ips := getInstanceIPs(instances)
+
+	expected := []string{"10.0.0.1", "10.0.0.2"}
+	if !slices.Equal(ips, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %v, expected %v", ips, expected)
+	}
+	if calls := api.getListCalls(); calls != 1 {
+		t.Errorf("GetPrivateIPsForScalingGroup() listed the instances %v times, expected once", calls)
+	}
+
+	_, err = client.GetPrivateIPsForScalingGroup(context.Background(), "missing-group")
+	if err == nil {
+		t.Error("GetPrivateIPsForScalingGroup() didn't fail for a group that doesn't exist")
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupGCPRegional(t *testing.T) {
+	t.Parallel()
+	api := &fakeComputeAPI{
+		groups: map[string][]string{
+			"backend-group": {"instance-1", "instance-2", "instance-deleting", "instance-abandoning"},
+		},
+		ips: map[string]string{
+			"instance-1":          "10.0.0.1",
+			"instance-2":          "10.0.0.2",
+			"instance-deleting":   "10.0.0.3",
+			"instance-abandoning": "10.0.0.4",
+			"other-instance":      "10.0.0.5",
+		},
+		actions: map[string]string{
+			"instance-1":          "NONE",
+			"instance-2":          "VERIFYING",
+			"instance-deleting":   gcpActionDeleting,
+			"instance-abandoning": gcpActionAbandoning,
+		},
+	}
+	client := newTestGCPClient(t, api)
+	client.config.Zone = ""
+	client.config.Region = "us-central1"
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed: %v", err)
+	}
+	ips := getInstanceIPs(instances)
+
+	expected := []string{"10.0.0.1", "10.0.0.2"}
+	if !slices.Equal(ips, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %v, expected %v", ips, expected)
+	}
+	if calls := api.getListCalls(); calls != 1 {
+		t.Errorf("GetPrivateIPsForScalingGroup() listed the instances %v times, expected once", calls)
+	}
+}
+
+func TestGetPrivateIPsForScalingGroupGCPIPv6(t *testing.T) {
+	t.Parallel()
+	api := &fakeComputeAPI{
+		groups: map[string][]string{
+			"backend-group": {"instance-1", "instance-2", "instance-3"},
+		},
+		// the IPv6 only instance-3 has no private IPv4 address
+		ips: map[string]string{
+			"instance-1": "10.0.0.1",
+			"instance-2": "10.0.0.2",
+			"instance-3": "",
+		},
+		ipv6s: map[string]string{
+			"instance-1": "fd20::1",
+			"instance-3": "fd20::3",
+		},
+	}
+	client := newTestGCPClient(t, api)
+
+	instances, err := client.GetPrivateIPsForScalingGroup(context.Background(), "backend-group")
+	if err != nil {
+		t.Fatalf("GetPrivateIPsForScalingGroup() failed for the IPv6 addresses: %v", err)
+	}
+
+	expected := []Instance{{IP: "10.0.0.1", IPv6: "fd20::1"}, {IP: "10.0.0.2"}, {IPv6: "fd20::3"}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("GetPrivateIPsForScalingGroup() returned %+v for the IPv6 addresses, expected %+v", instances, expected)
+	}
+}
+
+func TestGetInstanceNamesFilter(t *testing.T) {
+	t.Parallel()
+	filter := getInstanceNamesFilter([]string{"instance-1", "instance.2"})
+	expected := `name eq "instance-1|instance\\.2"`
+	if filter != expected {
+		t.Errorf("getInstanceNamesFilter() returned %v, expected %v", filter, expected)
+	}
+}
+
+func TestCheckIfScalingGroupExistsGCP(t *testing.T) {
+	t.Parallel()
+	api := &fakeComputeAPI{
+		groups: map[string][]string{
+			"backend-group": nil,
+		},
+	}
+	client := newTestGCPClient(t, api)
+
+	exists, err := client.CheckIfScalingGroupExists(context.Background(), "backend-group")
+	if err != nil || !exists {
+		t.Errorf("CheckIfScalingGroupExists() returned %v, %v for an existing group", exists, err)
+	}
+
+	exists, err = client.CheckIfScalingGroupExists(context.Background(), "missing-group")
+	if err != nil || exists {
+		t.Errorf("CheckIfScalingGroupExists() returned %v, %v for a group that doesn't exist", exists, err)
+	}
+}
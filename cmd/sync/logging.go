@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// logLevel is the level of the default logger. It is a variable so that a reload of the config can change it.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel returns the level for debug, info, warn or error.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch level {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+
+	return 0, fmt.Errorf("unknown log level %v", level)
+}
+
+// setLogLevel changes the level of the default logger.
+func setLogLevel(level string) error {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+
+	return nil
+}
+
+// setupLogging makes the default logger write records of the level and above in the format to w.
+// The format is either text or json. The standard log package writes to the default logger too.
+func setupLogging(w io.Writer, format string, level string) error {
+	err := setLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	handler, err := newLogHandler(w, format, logLevel)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// newLogHandler creates a handler that writes records of the level and above in the format, text or json, to w.
+func newLogHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %v", format)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		level    string
+		expected slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"info", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{"error", slog.LevelError},
+	}
+
+	for _, test := range tests {
+		level, err := parseLogLevel(test.level)
+		if err != nil {
+			t.Errorf("parseLogLevel(%v) returned an unexpected error: %v", test.level, err)
+		}
+		if level != test.expected {
+			t.Errorf("parseLogLevel(%v) returned %v, expected %v", test.level, level, test.expected)
+		}
+	}
+
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel() didn't fail for an unknown level")
+	}
+}
+
+func TestLogSyncResultJSON(t *testing.T) {
+	t.Parallel()
+	upstream := getTestUpstreams()[0]
+	tests := []struct {
+		expected map[string]any
+		msg      string
+		result   SyncResult
+		dryRun   bool
+	}{
+		{
+			result: SyncResult{Upstream: upstream, Endpoint: "nginx-1", Added: []string{"10.0.0.2:80"}, Removed: []string{"10.0.0.9:80"}},
+			expected: map[string]any{
+				"level": "INFO", "upstream": "backend-http", "scaling_group": "group-http", "kind": "http", "endpoint": "nginx-1",
+				"added": []any{"10.0.0.2:80"}, "removed": []any{"10.0.0.9:80"},
+			},
+			msg: "changes",
+		},
+		{
+			result: SyncResult{Upstream: upstream, Endpoint: "nginx-1", Err: errors.New("throttled")},
+			expected: map[string]any{
+				"level": "ERROR", "upstream": "backend-http", "scaling_group": "group-http", "error": "throttled",
+			},
+			msg: "error",
+		},
+		{
+			result:   SyncResult{Upstream: upstream, Endpoint: "nginx-1", Err: fmt.Errorf("%w: too many", errUpdateRefused)},
+			expected: map[string]any{"level": "WARN", "upstream": "backend-http"},
+			msg:      "refusal",
+		},
+		{
+			result:   SyncResult{Upstream: upstream, Endpoint: "nginx-1", Added: []string{"10.0.0.2:80"}},
+			expected: map[string]any{"level": "DEBUG", "upstream": "backend-http"},
+			dryRun:   true,
+			msg:      "changes of a dry run",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		handler, err := newLogHandler(&buf, "json", slog.LevelDebug)
+		if err != nil {
+			t.Fatalf("newLogHandler() failed: %v", err)
+		}
+
+		logSyncResult(slog.New(handler), test.result, test.dryRun)
+
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Errorf("logSyncResult() wrote an invalid JSON record %q for %v: %v", buf.String(), test.msg, err)
+			continue
+		}
+		for key, expected := range test.expected {
+			if fmt.Sprint(record[key]) != fmt.Sprint(expected) {
+				t.Errorf("logSyncResult() wrote %v=%v for %v, expected %v", key, record[key], test.msg, expected)
+			}
+		}
+	}
+}
+
+func TestNewLogHandlerUnknownFormat(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	if _, err := newLogHandler(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("newLogHandler() didn't fail for an unknown format")
+	}
+}
@@ -3,189 +3,292 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
-	nginx "github.com/nginx/nginx-plus-go-client/v2/client"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	configFile = flag.String("config_path", "/etc/nginx/config.yaml", "Path to the config file")
-	logFile    = flag.String("log_path", "", "Path to the log file. If the file doesn't exist, it will be created")
-	version    string
+	configFile   = flag.String("config_path", "/etc/nginx/config.yaml", "Path to the config file")
+	logFile      = flag.String("log_path", "", "Path to the log file. If the file doesn't exist, it will be created")
+	validateOnly = flag.Bool("validate", false, "Validate the config file, print all the errors found and exit")
+	dryRun       = flag.Bool("dry-run", false, "Print the changes to the NGINX Plus upstreams for the current scaling groups without applying them and exit")
+	watchConfig  = flag.Bool("watch_config", false, "Reload the config file when it changes, in addition to on SIGHUP")
+	version      string
 )
 
-const connTimeoutInSecs = 10
+const (
+	connTimeoutInSecs   = 10
+	configWatchInterval = 5 * time.Second
+	maxLookupBackoff    = 5 * time.Minute
+	// scalingGroupChangedBuffer is the number of notifications of changed scaling groups queued during a synchronization.
+	scalingGroupChangedBuffer = 100
+)
 
 func main() {
 	flag.Parse()
 
+	logWriter := io.Writer(os.Stderr)
 	if *logFile != "" {
 		logF, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
 		if err != nil {
-			log.Printf("Couldn't open the log file: %v", err)
+			slog.Error("Couldn't open the log file", "path", *logFile, "error", err)
 			os.Exit(10)
 		}
-		log.SetOutput(io.MultiWriter(logF, os.Stderr))
+		logWriter = io.MultiWriter(logF, os.Stderr)
 	}
 
-	log.Printf("nginx-asg-sync version %s", version)
+	// the config sets the format and the level of the logs once it is loaded
+	_ = setupLogging(logWriter, defaultLogFormat, defaultLogLevel)
 
-	cfgData, err := os.ReadFile(*configFile)
-	if err != nil {
-		log.Printf("Couldn't read the config file %v: %v", *configFile, err)
-		os.Exit(10)
-	}
+	slog.Info("Starting nginx-asg-sync", "version", version)
 
-	commonConfig, err := parseCommonConfig(cfgData)
-	if err != nil {
-		log.Printf("Couldn't parse the config: %v", err)
-		os.Exit(10)
-	}
+	if *validateOnly {
+		cfgData, err := os.ReadFile(*configFile)
+		if err != nil {
+			slog.Error("Couldn't read the config file", "path", *configFile, "error", err)
+			os.Exit(10)
+		}
 
-	var cloudProviderClient CloudProvider
+		err = validateConfig(cfgData)
+		if err != nil {
+			for _, msg := range strings.Split(err.Error(), "\n") {
+				slog.Error("The config file is invalid", "path", *configFile, "error", msg)
+			}
+			os.Exit(10)
+		}
 
-	switch commonConfig.CloudProvider {
-	case "AWS":
-		cloudProviderClient, err = NewAWSClient(cfgData)
-	case "Azure":
-		cloudProviderClient, err = NewAzureClient(cfgData)
+		slog.Info("The config file is valid", "path", *configFile)
+		return
 	}
 
-	if err != nil {
-		log.Printf("Couldn't create cloud provider client for %v: %v", commonConfig.CloudProvider, err)
-		os.Exit(10)
-	}
+	// SIGTERM cancels the context, which stops the requests in flight to the cloud provider and NGINX Plus
+	ctx, cancel := context.WithCancel(context.Background())
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGTERM)
+	go func() {
+		<-sigterm
+		slog.Info("Terminating")
+		cancel()
+	}()
 
-	httpClient := &http.Client{Timeout: connTimeoutInSecs * time.Second}
-	nginxClient, err := nginx.NewNginxClient(commonConfig.APIEndpoint, nginx.WithHTTPClient(httpClient))
+	// SIGHUP is registered before the start, which can take a while, so that it reloads the config once nginx-asg-sync
+	// started instead of terminating it
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	cfg, err := loadConfig(ctx, *configFile, nil)
 	if err != nil {
-		log.Printf("Couldn't create NGINX client: %v", err)
+		slog.Error("Couldn't load the config", "error", err)
 		os.Exit(10)
 	}
 
-	upstreams := cloudProviderClient.GetUpstreams()
+	err = setupLogging(logWriter, cfg.common.LogFormat, cfg.common.LogLevel)
 	if err != nil {
-		log.Printf("Couldn't get Upstreams: %v", err)
+		slog.Error("Couldn't set up the logging", "error", err)
 		os.Exit(10)
 	}
 
-	for _, ups := range upstreams {
-		ctx := context.TODO()
-		if ups.Kind == "http" {
-			err = nginxClient.CheckIfUpstreamExists(ctx, ups.Name)
-		} else {
-			err = nginxClient.CheckIfStreamUpstreamExists(ctx, ups.Name)
-		}
+	if *dryRun {
+		syncer := NewDryRunSyncer(cfg.cloudProvider, cfg.endpoints, NewMetrics(prometheus.NewRegistry()))
 
+		err = syncer.CheckUpstreams(ctx)
 		if err != nil {
-			log.Printf("Problem with the NGINX configuration: %v", err)
+			slog.Error("Couldn't check the upstreams", "error", err)
 			os.Exit(10)
 		}
 
-		exists, err := cloudProviderClient.CheckIfScalingGroupExists(ups.ScalingGroup)
+		if !printDryRunResults(os.Stdout, syncer.SyncOnce(ctx)) {
+			os.Exit(10)
+		}
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	status := NewStatus(getStatusMaxAge(cfg.common))
+
+	// the metrics and the health endpoints share a server if they have the same listen address
+	muxes := make(map[string]*http.ServeMux)
+	getMux := func(address string) *http.ServeMux {
+		if _, ok := muxes[address]; !ok {
+			muxes[address] = http.NewServeMux()
+		}
+		return muxes[address]
+	}
+	if cfg.common.MetricsListenAddress != "" {
+		registerMetricsHandler(getMux(cfg.common.MetricsListenAddress), registry)
+	}
+	if cfg.common.HealthListenAddress != "" {
+		status.registerHandlers(getMux(cfg.common.HealthListenAddress))
+	}
+	for address, mux := range muxes {
+		err = startHTTPServer(address, mux)
 		if err != nil {
-			log.Printf("Couldn't check if Scaling group exists: %v", err)
+			slog.Error("Couldn't start the HTTP server", "error", err)
 			os.Exit(10)
-		} else if !exists {
-			log.Printf("Warning: Scaling group '%v' doesn't exist in the cloud provider", ups.ScalingGroup)
 		}
 	}
 
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGTERM)
+	syncer := NewSyncer(cfg.cloudProvider, cfg.endpoints, metrics)
+	syncer.SetMaxConcurrency(cfg.common.MaxConcurrency)
+	syncer.SetLookupOptions(getLookupOptions(cfg.common))
 
-	for {
-		for _, upstream := range upstreams {
-			ips, err := cloudProviderClient.GetPrivateIPsForScalingGroup(upstream.ScalingGroup)
-			if err != nil {
-				log.Printf("Couldn't get the IP addresses for %v: %v", upstream.ScalingGroup, err)
-				continue
-			}
-			ctx := context.TODO()
-
-			if upstream.Kind == "http" {
-				var upsServers []nginx.UpstreamServer
-				for _, ip := range ips {
-					backend := fmt.Sprintf("%v:%v", ip, upstream.Port)
-					upsServers = append(upsServers, nginx.UpstreamServer{
-						Server:      backend,
-						MaxConns:    upstream.MaxConns,
-						MaxFails:    upstream.MaxFails,
-						FailTimeout: upstream.FailTimeout,
-						SlowStart:   upstream.SlowStart,
-					})
-				}
+	err = syncer.CheckUpstreams(ctx)
+	if err != nil {
+		slog.Error("Couldn't check the upstreams", "error", err)
+		os.Exit(10)
+	}
 
-				added, removed, updated, err := nginxClient.UpdateHTTPServers(ctx, upstream.Name, upsServers)
-				if err != nil {
-					log.Printf("Couldn't update HTTP servers in NGINX: %v", err)
-					continue
-				}
+	configChanged := make(chan struct{}, 1)
+	if *watchConfig {
+		go watchConfigFile(ctx, *configFile, configWatchInterval, configChanged)
+	}
 
-				if len(added) > 0 || len(removed) > 0 || len(updated) > 0 {
-					addedAddresses := getUpstreamServerAddresses(added)
-					removedAddresses := getUpstreamServerAddresses(removed)
-					updatedAddresses := getUpstreamServerAddresses(updated)
-					log.Printf("Updated HTTP servers of %v for group %v ; Added: %+v, Removed: %+v, Updated: %+v",
-						upstream.Name, upstream.ScalingGroup, addedAddresses, removedAddresses, updatedAddresses)
-				}
-			} else {
-				var upsServers []nginx.StreamUpstreamServer
-				for _, ip := range ips {
-					backend := fmt.Sprintf("%v:%v", ip, upstream.Port)
-					upsServers = append(upsServers, nginx.StreamUpstreamServer{
-						Server:      backend,
-						MaxConns:    upstream.MaxConns,
-						MaxFails:    upstream.MaxFails,
-						FailTimeout: upstream.FailTimeout,
-						SlowStart:   upstream.SlowStart,
-					})
-				}
+	scalingGroupChanged := make(chan string, scalingGroupChangedBuffer)
+	stopWatching := watchScalingGroups(ctx, cfg.cloudProvider, scalingGroupChanged)
+	reload := func() {
+		reloaded := reloadConfig(ctx, syncer, cfg)
+		if reloaded == cfg {
+			return
+		}
 
-				added, removed, updated, err := nginxClient.UpdateStreamServers(ctx, upstream.Name, upsServers)
-				if err != nil {
-					log.Printf("Couldn't update Steam servers in NGINX: %v", err)
-					continue
-				}
+		cfg = reloaded
+		status.setMaxAge(getStatusMaxAge(cfg.common))
+		// the cloud provider is created again from the reloaded config
+		stopWatching()
+		stopWatching = watchScalingGroups(ctx, cfg.cloudProvider, scalingGroupChanged)
+	}
 
-				if len(added) > 0 || len(removed) > 0 || len(updated) > 0 {
-					addedAddresses := getStreamUpstreamServerAddresses(added)
-					removedAddresses := getStreamUpstreamServerAddresses(removed)
-					updatedAddresses := getStreamUpstreamServerAddresses(updated)
-					log.Printf("Updated Stream servers of %v for group %v ; Added: %+v, Removed: %+v, Updated: %+v",
-						upstream.Name, upstream.ScalingGroup, addedAddresses, removedAddresses, updatedAddresses)
+	for {
+		results := syncer.SyncOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		status.observeSyncs(results, time.Now())
+		completeLifecycleActions(ctx, cfg.cloudProvider, results)
+
+		nextSync := time.After(cfg.common.SyncInterval)
+	wait:
+		for {
+			select {
+			case <-nextSync:
+				break wait
+			case scalingGroup := <-scalingGroupChanged:
+				partialResults := syncer.SyncScalingGroups(ctx, receiveScalingGroups(scalingGroup, scalingGroupChanged))
+				if ctx.Err() != nil {
+					return
 				}
+				status.observePartialSyncs(partialResults, time.Now())
+				completeLifecycleActions(ctx, cfg.cloudProvider, partialResults)
+			case <-sighup:
+				reload()
+				break wait
+			case <-configChanged:
+				reload()
+				break wait
+			case <-ctx.Done():
+				return
 			}
 		}
+	}
+}
 
-		select {
-		case <-time.After(commonConfig.SyncInterval):
-		case <-sigterm:
-			log.Println("Terminating...")
-			return
-		}
+// reloadConfig loads the config file again and swaps it into the syncer.
+// If the new config is invalid, the current config is kept and returned.
+func reloadConfig(ctx context.Context, syncer *Syncer, current *loadedConfig) *loadedConfig {
+	slog.Info("Reloading the config file", "path", *configFile)
+
+	cfg, err := loadConfig(ctx, *configFile, current)
+	if err != nil {
+		slog.Error("Couldn't reload the config, keeping the previous one", "error", err)
+		return current
+	}
+
+	err = syncer.Reload(ctx, cfg.cloudProvider, cfg.endpoints)
+	if err != nil {
+		slog.Error("Couldn't check the upstreams of the reloaded config, keeping the previous one", "error", err)
+		return current
+	}
+	syncer.SetMaxConcurrency(cfg.common.MaxConcurrency)
+	syncer.SetLookupOptions(getLookupOptions(cfg.common))
+
+	if cfg.common.MetricsListenAddress != current.common.MetricsListenAddress {
+		slog.Warn("The change of metrics_listen_address requires a restart")
+	}
+	if cfg.common.HealthListenAddress != current.common.HealthListenAddress {
+		slog.Warn("The change of health_listen_address requires a restart")
+	}
+	if cfg.common.LogFormat != current.common.LogFormat {
+		slog.Warn("The change of log_format requires a restart")
+	}
+	err = setLogLevel(cfg.common.LogLevel)
+	if err != nil {
+		slog.Error("Couldn't change the log level", "error", err)
+	}
+
+	slog.Info("Reloaded the config file", "path", *configFile)
+	return cfg
+}
+
+// getStatusMaxAge returns the time within which every upstream must be synchronized successfully to be ready.
+func getStatusMaxAge(cfg *commonConfig) time.Duration {
+	return time.Duration(cfg.ReadySyncIntervals) * cfg.SyncInterval
+}
+
+// getLookupOptions returns how the syncer handles the failed lookups of the scaling groups. The lookups back off from
+// half a sync interval up to maxLookupBackoff, or the sync interval if it is longer. With the jitter, the first backoff
+// is shorter than a sync interval, so the next regular synchronization retries the lookup after a single failure.
+func getLookupOptions(cfg *commonConfig) LookupOptions {
+	return LookupOptions{
+		StalePolicy: cfg.StalePolicy,
+		StaleAfter:  cfg.StaleAfter,
+		BackoffBase: cfg.SyncInterval / 2,
+		BackoffMax:  max(maxLookupBackoff, cfg.SyncInterval),
 	}
 }
 
-func getUpstreamServerAddresses(server []nginx.UpstreamServer) []string {
-	upstreamServerAddr := make([]string, 0, len(server))
-	for _, s := range server {
-		upstreamServerAddr = append(upstreamServerAddr, s.Server)
+// watchScalingGroups sends the scaling groups that changed to the channel in the background, if the cloud provider
+// notifies of them, until the returned function is called or the context is done.
+func watchScalingGroups(ctx context.Context, cloudProvider CloudProvider, changed chan<- string) context.CancelFunc {
+	watcher, ok := cloudProvider.(ScalingGroupWatcher)
+	if !ok {
+		return func() {}
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	go watcher.WatchScalingGroups(watchCtx, changed)
+
+	return cancel
+}
+
+// receiveScalingGroups returns the scaling group with the other scaling groups already queued in the channel, without
+// duplicates, so that a burst of notifications triggers one synchronization.
+func receiveScalingGroups(scalingGroup string, changed <-chan string) []string {
+	scalingGroups := []string{scalingGroup}
+	for {
+		select {
+		case g := <-changed:
+			if !slices.Contains(scalingGroups, g) {
+				scalingGroups = append(scalingGroups, g)
+			}
+		default:
+			return scalingGroups
+		}
 	}
-	return upstreamServerAddr
 }
 
-func getStreamUpstreamServerAddresses(server []nginx.StreamUpstreamServer) []string {
-	streamUpstreamServerAddr := make([]string, 0, len(server))
-	for _, s := range server {
-		streamUpstreamServerAddr = append(streamUpstreamServerAddr, s.Server)
+// completeLifecycleActions completes the lifecycle actions of the cloud provider, if it has any, after a synchronization.
+func completeLifecycleActions(ctx context.Context, cloudProvider CloudProvider, results []SyncResult) {
+	if completer, ok := cloudProvider.(LifecycleActionCompleter); ok {
+		completer.CompleteLifecycleActions(ctx, results)
 	}
-	return streamUpstreamServerAddr
 }
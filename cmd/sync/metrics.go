@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "nginx_asg_sync"
+
+// Metrics holds the Prometheus metrics of the synchronization of the upstreams.
+type Metrics struct {
+	syncDuration   *prometheus.HistogramVec
+	syncErrors     *prometheus.CounterVec
+	syncRefusals   *prometheus.CounterVec
+	callDuration   *prometheus.HistogramVec
+	callErrors     *prometheus.CounterVec
+	servers        *prometheus.GaugeVec
+	serversAdded   *prometheus.CounterVec
+	serversRemoved *prometheus.CounterVec
+	serversUpdated *prometheus.CounterVec
+}
+
+// NewMetrics creates the Metrics and registers them with the registerer.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_sync_duration_seconds",
+			Help:      "Duration of the synchronization of an upstream.",
+		}, []string{"upstream", "endpoint"}),
+		syncErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_sync_errors_total",
+			Help:      "Number of failed synchronizations of an upstream.",
+		}, []string{"upstream", "endpoint"}),
+		syncRefusals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_sync_refusals_total",
+			Help:      "Number of updates of an upstream refused because they exceed min_servers or max_removal_percent.",
+		}, []string{"upstream", "endpoint"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "call_duration_seconds",
+			Help:      "Duration of the calls to the cloud provider and NGINX Plus APIs.",
+		}, []string{"call"}),
+		callErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "call_errors_total",
+			Help:      "Number of failed calls to the cloud provider and NGINX Plus APIs.",
+		}, []string{"call"}),
+		servers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_servers",
+			Help:      "Number of servers of an upstream after its last synchronization that updated them.",
+		}, []string{"upstream", "endpoint"}),
+		serversAdded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_servers_added_total",
+			Help:      "Number of servers added to an upstream.",
+		}, []string{"upstream", "endpoint"}),
+		serversRemoved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_servers_removed_total",
+			Help:      "Number of servers removed from an upstream.",
+		}, []string{"upstream", "endpoint"}),
+		serversUpdated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_servers_updated_total",
+			Help:      "Number of servers of an upstream with updated parameters.",
+		}, []string{"upstream", "endpoint"}),
+	}
+
+	registerer.MustRegister(
+		m.syncDuration,
+		m.syncErrors,
+		m.syncRefusals,
+		m.callDuration,
+		m.callErrors,
+		m.servers,
+		m.serversAdded,
+		m.serversRemoved,
+		m.serversUpdated,
+	)
+
+	return m
+}
+
+// observeSync records the result of the synchronization of an upstream in an NGINX Plus instance that took duration.
+func (m *Metrics) observeSync(result SyncResult, duration time.Duration) {
+	labels := prometheus.Labels{"upstream": result.Upstream.Name, "endpoint": result.Endpoint}
+	m.syncDuration.With(labels).Observe(duration.Seconds())
+
+	if errors.Is(result.Err, errUpdateRefused) {
+		m.syncRefusals.With(labels).Inc()
+		return
+	}
+	if result.Err != nil {
+		m.syncErrors.With(labels).Inc()
+		if result.Fallback == "" {
+			return
+		}
+	}
+
+	m.servers.With(labels).Set(float64(len(result.Servers)))
+	m.serversAdded.With(labels).Add(float64(len(result.Added)))
+	m.serversRemoved.With(labels).Add(float64(len(result.Removed)))
+	m.serversUpdated.With(labels).Add(float64(len(result.Updated)))
+}
+
+// forget deletes the metrics of the upstreams and NGINX Plus instances that match the labels and are no longer synchronized.
+func (m *Metrics) forget(labels prometheus.Labels) {
+	for _, vec := range []*prometheus.MetricVec{
+		m.syncDuration.MetricVec,
+		m.syncErrors.MetricVec,
+		m.syncRefusals.MetricVec,
+		m.servers.MetricVec,
+		m.serversAdded.MetricVec,
+		m.serversRemoved.MetricVec,
+		m.serversUpdated.MetricVec,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// observeCall records a call to the cloud provider or NGINX Plus API that started at start and returned err.
+func (m *Metrics) observeCall(call string, start time.Time, err error) {
+	m.callDuration.WithLabelValues(call).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		m.callErrors.WithLabelValues(call).Inc()
+	}
+}
+
+// registerMetricsHandler registers the /metrics endpoint serving the metrics of the registry in the mux.
+func registerMetricsHandler(mux *http.ServeMux, registry *prometheus.Registry) {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+// startHTTPServer serves the mux on the address in the background.
+func startHTTPServer(address string, mux *http.ServeMux) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("couldn't listen on %v: %w", address, err)
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: connTimeoutInSecs * time.Second,
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		slog.Error("The HTTP server stopped", "address", address, "error", err)
+	}()
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsObserveSync(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.9:80")
+	api.addUpstream("stream", "backend-stream")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1", "10.0.0.2"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), metrics)
+
+	syncer.SyncOnce(context.Background())
+
+	tests := []struct {
+		collector prometheus.Collector
+		msg       string
+		expected  float64
+	}{
+		{metrics.servers.WithLabelValues("backend-http", "nginx-1"), "servers of backend-http", 2},
+		{metrics.servers.WithLabelValues("backend-stream", "nginx-1"), "servers of backend-stream", 1},
+		{metrics.serversAdded.WithLabelValues("backend-http", "nginx-1"), "servers added to backend-http", 2},
+		{metrics.serversRemoved.WithLabelValues("backend-http", "nginx-1"), "servers removed from backend-http", 1},
+		{metrics.serversUpdated.WithLabelValues("backend-http", "nginx-1"), "servers updated in backend-http", 0},
+		{metrics.syncErrors.WithLabelValues("backend-http", "nginx-1"), "sync errors of backend-http", 0},
+	}
+	for _, test := range tests {
+		if got := testutil.ToFloat64(test.collector); got != test.expected {
+			t.Errorf("the metric of the %v is %v, expected %v", test.msg, got, test.expected)
+		}
+	}
+
+	if got := testutil.CollectAndCount(metrics.callDuration); got != 3 {
+		t.Errorf("the call duration is recorded for %v calls, expected 3", got)
+	}
+}
+
+func TestMetricsObserveSyncError(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80")
+	api.addUpstream("stream", "backend-stream")
+
+	cloud := &fakeCloudProvider{
+		err:       errors.New("throttled"),
+		upstreams: getTestUpstreams(),
+	}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), metrics)
+
+	syncer.SyncOnce(context.Background())
+
+	if got := testutil.ToFloat64(metrics.syncErrors.WithLabelValues("backend-http", "nginx-1")); got != 1 {
+		t.Errorf("the sync errors of backend-http are %v, expected 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.callErrors.WithLabelValues("GetPrivateIPsForScalingGroup")); got != 2 {
+		t.Errorf("the errors of GetPrivateIPsForScalingGroup are %v, expected 2", got)
+	}
+	if got := testutil.CollectAndCount(metrics.servers); got != 0 {
+		t.Errorf("the servers are recorded for %v upstreams after failed synchronizations, expected 0", got)
+	}
+}
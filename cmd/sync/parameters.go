@@ -1,8 +1,9 @@
 package main
 
 const (
-	defaultFailTimeout = "10s"
-	defaultSlowStart   = "0s"
+	defaultFailTimeout   = "10s"
+	defaultSlowStart     = "0s"
+	defaultAddressFamily = addressFamilyIPv4
 )
 
 func getFailTimeoutOrDefault(failTimeout string) string {
@@ -20,3 +21,11 @@ func getSlowStartOrDefault(slowStart string) string {
 
 	return slowStart
 }
+
+func getAddressFamilyOrDefault(addressFamily string) string {
+	if addressFamily == "" {
+		return defaultAddressFamily
+	}
+
+	return addressFamily
+}
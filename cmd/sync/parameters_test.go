@@ -51,3 +51,27 @@ func TestGetSlowStartOrDefault(t *testing.T) {
 		}
 	}
 }
+
+func TestGetAddressFamilyOrDefault(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "",
+			expected: defaultAddressFamily,
+		},
+		{
+			input:    addressFamilyDual,
+			expected: addressFamilyDual,
+		},
+	}
+
+	for _, test := range tests {
+		result := getAddressFamilyOrDefault(test.input)
+		if result != test.expected {
+			t.Errorf("getAddressFamilyOrDefault(%v) returned %v but expected %v", test.input, result, test.expected)
+		}
+	}
+}
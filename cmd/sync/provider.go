@@ -1,17 +1,114 @@
 package main
 
+import (
+	"context"
+	"log/slog"
+	"strconv"
+)
+
+// The tags of an instance that set the parameters of its server.
+const (
+	weightTag = "nginx-weight"
+	backupTag = "nginx-backup"
+	routeTag  = "nginx-route"
+)
+
 // CloudProvider is the interface to connect with any cloud provider.
 type CloudProvider interface {
-	GetPrivateIPsForScalingGroup(name string) ([]string, error)
-	CheckIfScalingGroupExists(name string) (bool, error)
+	GetPrivateIPsForScalingGroup(ctx context.Context, name string) ([]Instance, error)
+	CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error)
 	GetUpstreams() []Upstream
 }
 
+// ScalingGroupWatcher is implemented by the cloud providers that can notify of the changes of the scaling groups, so
+// that their upstreams are synchronized without waiting for the next sync interval.
+type ScalingGroupWatcher interface {
+	// WatchScalingGroups sends the names of the scaling groups that changed to the channel until the context is done.
+	// It returns immediately if the notifications aren't configured.
+	WatchScalingGroups(ctx context.Context, changed chan<- string)
+}
+
+// LifecycleActionCompleter is implemented by the cloud providers that hold the instances of the scaling groups in
+// lifecycle hooks until they are added to or removed from the upstreams.
+type LifecycleActionCompleter interface {
+	// CompleteLifecycleActions completes the lifecycle actions of the instances that were added to or removed from the
+	// upstreams according to the results of a synchronization.
+	CompleteLifecycleActions(ctx context.Context, results []SyncResult)
+}
+
+// ScalingGroupLocator is implemented by the cloud providers whose scaling groups are looked up in a location that the
+// config sets, so that a scaling group that moves to another location isn't confused with the group of the same name.
+type ScalingGroupLocator interface {
+	// GetScalingGroupLocation returns where the scaling group is looked up, for example its region and role.
+	GetScalingGroupLocation(name string) string
+}
+
 func validateCloudProvider(provider string) bool {
 	providers := map[string]bool{
 		"AWS":   true,
 		"Azure": true,
+		"GCP":   true,
 	}
 
 	return providers[provider]
 }
+
+// The address families of an upstream, which select the IP addresses of the instances used for its servers.
+const (
+	addressFamilyIPv4 = "ipv4"
+	addressFamilyIPv6 = "ipv6"
+	addressFamilyDual = "dual"
+)
+
+// Instance is an instance of a scaling group with the parameters of its server set by the instance tags.
+type Instance struct {
+	// Weight is nil if the instance doesn't have a valid nginx-weight tag.
+	Weight *int
+	// Backup is nil if the instance doesn't have a valid nginx-backup tag.
+	Backup *bool
+	// IP is the IPv4 address of the instance, empty if it only has an IPv6 address.
+	IP string
+	// IPv6 is the IPv6 address of the instance, empty if it doesn't have one.
+	IPv6  string
+	Route string
+}
+
+// getAddresses returns the IP addresses of the instance of the address family: both the IPv4 and the IPv6 address for
+// dual, or none if the instance doesn't have an address of the family.
+func (ins Instance) getAddresses(addressFamily string) []string {
+	var addresses []string
+	if ins.IP != "" && addressFamily != addressFamilyIPv6 {
+		addresses = append(addresses, ins.IP)
+	}
+	if ins.IPv6 != "" && (addressFamily == addressFamilyIPv6 || addressFamily == addressFamilyDual) {
+		addresses = append(addresses, ins.IPv6)
+	}
+
+	return addresses
+}
+
+// newInstance creates an Instance with the IP address and the server parameters from the tags.
+// Tags with invalid values are ignored.
+func newInstance(ip string, tags map[string]string) Instance {
+	instance := Instance{IP: ip, Route: tags[routeTag]}
+
+	if value, ok := tags[weightTag]; ok {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 {
+			slog.Warn("Ignoring the invalid tag of the instance", "tag", weightTag, "value", value, "ip", ip)
+		} else {
+			instance.Weight = &weight
+		}
+	}
+
+	if value, ok := tags[backupTag]; ok {
+		backup, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Warn("Ignoring the invalid tag of the instance", "tag", backupTag, "value", value, "ip", ip)
+		} else {
+			instance.Backup = &backup
+		}
+	}
+
+	return instance
+}
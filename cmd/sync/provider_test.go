@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
 
 func TestValidateCloudProviderValid(t *testing.T) {
 	t.Parallel()
@@ -19,3 +23,76 @@ func TestValidateCloudProviderInvalid(t *testing.T) {
 		t.Errorf("validateCloudProvider(%v) returned valid for an invalid case", provider)
 	}
 }
+
+func TestNewInstance(t *testing.T) {
+	t.Parallel()
+	weight := 5
+	backup := true
+	tests := []struct {
+		tags     map[string]string
+		expected Instance
+		msg      string
+	}{
+		{
+			tags:     nil,
+			expected: Instance{IP: "10.0.0.1"},
+			msg:      "no tags",
+		},
+		{
+			tags:     map[string]string{weightTag: "5", backupTag: "true", routeTag: "a", "Name": "canary"},
+			expected: Instance{IP: "10.0.0.1", Weight: &weight, Backup: &backup, Route: "a"},
+			msg:      "all the tags",
+		},
+		{
+			tags:     map[string]string{weightTag: "0", backupTag: "maybe"},
+			expected: Instance{IP: "10.0.0.1"},
+			msg:      "invalid tags",
+		},
+	}
+
+	for _, test := range tests {
+		instance := newInstance("10.0.0.1", test.tags)
+		if !reflect.DeepEqual(instance, test.expected) {
+			t.Errorf("newInstance() returned %+v for %v, expected %+v", instance, test.msg, test.expected)
+		}
+	}
+}
+
+// getInstanceIPs returns the sorted IP addresses of the instances.
+func getInstanceIPs(instances []Instance) []string {
+	ips := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		ips = append(ips, ins.IP)
+	}
+	slices.Sort(ips)
+
+	return ips
+}
+
+func TestInstanceGetAddresses(t *testing.T) {
+	t.Parallel()
+	dualStack := Instance{IP: "10.0.0.1", IPv6: "fd00::1"}
+	ipv4Only := Instance{IP: "10.0.0.1"}
+	ipv6Only := Instance{IPv6: "fd00::1"}
+
+	tests := []struct {
+		instance      Instance
+		msg           string
+		addressFamily string
+		expected      []string
+	}{
+		{msg: "ipv4 of a dual-stack instance", instance: dualStack, addressFamily: addressFamilyIPv4, expected: []string{"10.0.0.1"}},
+		{msg: "ipv6 of a dual-stack instance", instance: dualStack, addressFamily: addressFamilyIPv6, expected: []string{"fd00::1"}},
+		{msg: "dual of a dual-stack instance", instance: dualStack, addressFamily: addressFamilyDual, expected: []string{"10.0.0.1", "fd00::1"}},
+		{msg: "ipv6 of an IPv4-only instance", instance: ipv4Only, addressFamily: addressFamilyIPv6},
+		{msg: "dual of an IPv4-only instance", instance: ipv4Only, addressFamily: addressFamilyDual, expected: []string{"10.0.0.1"}},
+		{msg: "ipv4 of an IPv6-only instance", instance: ipv6Only, addressFamily: addressFamilyIPv4},
+		{msg: "dual of an IPv6-only instance", instance: ipv6Only, addressFamily: addressFamilyDual, expected: []string{"fd00::1"}},
+	}
+
+	for _, test := range tests {
+		if got := test.instance.getAddresses(test.addressFamily); !slices.Equal(got, test.expected) {
+			t.Errorf("getAddresses() returned %v for %v, expected %v", got, test.msg, test.expected)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v2/client"
+)
+
+// loadedConfig is the config file with the clients created from it.
+type loadedConfig struct {
+	common        *commonConfig
+	cloudProvider CloudProvider
+	endpoints     []NginxEndpoint
+}
+
+// loadConfig reads and validates the config file and creates the cloud provider and NGINX clients for it.
+// The NGINX clients of the previous config, if any, are reused for the API endpoints that haven't changed.
+func loadConfig(ctx context.Context, path string, previous *loadedConfig) (*loadedConfig, error) {
+	cfgData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the config file %v: %w", path, err)
+	}
+
+	commonConfig, err := parseCommonConfig(cfgData)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the config: %w", err)
+	}
+
+	var cloudProviderClient CloudProvider
+
+	switch commonConfig.CloudProvider {
+	case "AWS":
+		var awsClient *AWSClient
+		awsClient, err = NewAWSClient(ctx, cfgData)
+		if err == nil {
+			// the lifecycle actions waiting for the synchronizations are kept across the reloads
+			if previousClient, ok := previous.getCloudProvider().(*AWSClient); ok {
+				awsClient.lifecycleActions = previousClient.lifecycleActions
+			}
+			cloudProviderClient = awsClient
+		}
+	case "Azure":
+		cloudProviderClient, err = NewAzureClient(ctx, cfgData)
+	case "GCP":
+		cloudProviderClient, err = NewGCPClient(ctx, cfgData)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create cloud provider client for %v: %w", commonConfig.CloudProvider, err)
+	}
+
+	endpoints := make([]NginxEndpoint, 0, len(commonConfig.APIEndpoints))
+	for _, address := range commonConfig.APIEndpoints {
+		if previous != nil {
+			i := slices.IndexFunc(previous.endpoints, func(e NginxEndpoint) bool { return e.Address == address })
+			if i != -1 {
+				endpoints = append(endpoints, previous.endpoints[i])
+				continue
+			}
+		}
+
+		httpClient := &http.Client{Timeout: connTimeoutInSecs * time.Second}
+		nginxClient, err := nginx.NewNginxClient(address, nginx.WithHTTPClient(httpClient))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create NGINX client for %v: %w", address, err)
+		}
+		endpoints = append(endpoints, NginxEndpoint{Client: nginxClient, Address: address})
+	}
+
+	return &loadedConfig{common: commonConfig, cloudProvider: cloudProviderClient, endpoints: endpoints}, nil
+}
+
+// getCloudProvider returns the cloud provider of the config, or nil if there is no config.
+func (c *loadedConfig) getCloudProvider() CloudProvider {
+	if c == nil {
+		return nil
+	}
+
+	return c.cloudProvider
+}
+
+// watchConfigFile checks the modification time of the config file every interval and
+// notifies the changed channel when it changes, until the context is done.
+func watchConfigFile(ctx context.Context, path string, interval time.Duration, changed chan<- struct{}) {
+	var lastModTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Error("Couldn't check the config file for changes", "path", path, "error", err)
+			continue
+		}
+		if info.ModTime().Equal(lastModTime) {
+			continue
+		}
+		lastModTime = info.ModTime()
+
+		select {
+		case changed <- struct{}{}:
+		default:
+			// a reload is already pending
+		}
+	}
+}
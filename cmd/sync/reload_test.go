@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigNotValid(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("sync_interval: 5s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.yaml")} {
+		cfg, err := loadConfig(context.Background(), path, nil)
+		if err == nil {
+			t.Errorf("loadConfig() didn't return an error for %v: %+v", path, cfg)
+		}
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("sync_interval: 5s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go watchConfigFile(ctx, path, 10*time.Millisecond, changed)
+
+	// the file is modified until the watcher notices, as the watcher might read the initial modification time late
+	timeout := time.After(5 * time.Second)
+	for i := 1; ; i++ {
+		modTime := time.Now().Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-changed:
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("watchConfigFile() didn't notify the change of the config file")
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status tracks the synchronizations of the upstreams for the health, readiness and status endpoints.
+type Status struct {
+	lastTick time.Time
+	// upstreams maps the name of an upstream and the address of an NGINX Plus instance to the status of the upstream in it.
+	upstreams map[upstreamEndpoint]*UpstreamStatus
+	// maxAge is the time within which the synchronization loop must tick and every upstream must be synchronized successfully.
+	maxAge time.Duration
+	mu     sync.Mutex
+}
+
+type upstreamEndpoint struct {
+	upstream string
+	endpoint string
+}
+
+// UpstreamStatus is the status of the synchronization of an upstream in an NGINX Plus instance.
+type UpstreamStatus struct {
+	LastSync    time.Time  `json:"last_sync"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Name        string     `json:"name"`
+	Endpoint    string     `json:"endpoint"`
+	// ScalingGroup is the comma-separated names of the scaling groups of the upstream.
+	ScalingGroup string `json:"scaling_group"`
+	LastError    string `json:"last_error,omitempty"`
+	// Fallback is how the servers were synchronized in the last synchronization although the lookup failed.
+	Fallback string `json:"fallback,omitempty"`
+	// Servers are the addresses of the servers of the upstream after its last synchronization that updated them.
+	Servers []string `json:"servers"`
+}
+
+// NewStatus creates a Status for which the synchronization loop must tick and the upstreams must be synchronized within maxAge.
+func NewStatus(maxAge time.Duration) *Status {
+	return &Status{
+		lastTick:  time.Now(),
+		upstreams: make(map[upstreamEndpoint]*UpstreamStatus),
+		maxAge:    maxAge,
+	}
+}
+
+// setMaxAge changes the time within which the synchronization loop must tick and the upstreams must be synchronized.
+func (s *Status) setMaxAge(maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxAge = maxAge
+}
+
+// observeSyncs records the results of a synchronization of all the upstreams that finished at now.
+// The upstreams and NGINX Plus instances that are not in the results are no longer synchronized and are forgotten.
+func (s *Status) observeSyncs(results []SyncResult, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upstreams := make(map[upstreamEndpoint]*UpstreamStatus, len(results))
+	for _, result := range results {
+		s.observeSync(upstreams, result, now)
+	}
+
+	s.upstreams = upstreams
+	s.lastTick = now
+}
+
+// observePartialSyncs records the results of a synchronization of some of the upstreams that finished at now.
+// The other upstreams are kept as they are.
+func (s *Status) observePartialSyncs(results []SyncResult, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, result := range results {
+		s.observeSync(s.upstreams, result, now)
+	}
+}
+
+// observeSync records the result of the synchronization of an upstream in upstreams, starting from its previous status.
+func (s *Status) observeSync(upstreams map[upstreamEndpoint]*UpstreamStatus, result SyncResult, now time.Time) {
+	key := upstreamEndpoint{upstream: result.Upstream.Name, endpoint: result.Endpoint}
+	status, ok := s.upstreams[key]
+	if !ok {
+		status = &UpstreamStatus{Name: result.Upstream.Name, Endpoint: result.Endpoint, Servers: []string{}}
+	}
+
+	status.ScalingGroup = result.Upstream.getScalingGroupNames()
+	status.LastSync = now
+	status.LastError = ""
+	status.Fallback = result.Fallback
+	if result.Err != nil {
+		status.LastError = result.Err.Error()
+	} else {
+		status.LastSuccess = &now
+	}
+	if result.Err == nil || result.Fallback != "" {
+		status.Servers = slices.Sorted(slices.Values(result.Servers))
+	}
+
+	upstreams[key] = status
+}
+
+// isHealthy returns an error if the synchronization loop didn't tick within the max age.
+func (s *Status) isHealthy(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastTick) > s.maxAge {
+		return fmt.Errorf("the last synchronization finished at %v, more than %v ago", s.lastTick.Format(time.RFC3339), s.maxAge)
+	}
+
+	return nil
+}
+
+// isReady returns an error if any upstream wasn't synchronized successfully within the max age,
+// or if no synchronization has finished yet.
+func (s *Status) isReady(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.upstreams) == 0 {
+		return errors.New("no upstream has been synchronized yet")
+	}
+
+	var notReady []string
+	for _, status := range s.upstreams {
+		if status.LastSuccess == nil || now.Sub(*status.LastSuccess) > s.maxAge {
+			notReady = append(notReady, fmt.Sprintf("%v in %v", status.Name, status.Endpoint))
+		}
+	}
+
+	if len(notReady) > 0 {
+		slices.Sort(notReady)
+		return fmt.Errorf("the upstreams %v weren't synchronized successfully in the last %v", strings.Join(notReady, ", "), s.maxAge)
+	}
+
+	return nil
+}
+
+// getUpstreams returns the status of every upstream in every NGINX Plus instance, sorted by upstream and endpoint.
+func (s *Status) getUpstreams() []UpstreamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upstreams := make([]UpstreamStatus, 0, len(s.upstreams))
+	for _, status := range s.upstreams {
+		upstreams = append(upstreams, *status)
+	}
+
+	slices.SortFunc(upstreams, func(a, b UpstreamStatus) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Endpoint, b.Endpoint)
+	})
+
+	return upstreams
+}
+
+// registerHandlers registers the /healthz, /readyz and /status endpoints of the Status in the mux.
+func (s *Status) registerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeCheck(w, s.isHealthy(time.Now()))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		writeCheck(w, s.isReady(time.Now()))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(struct {
+			Upstreams []UpstreamStatus `json:"upstreams"`
+		}{s.getUpstreams()})
+		if err != nil {
+			slog.Error("Couldn't write the status", "error", err)
+		}
+	})
+}
+
+// writeCheck writes ok, or the error of the failed check with the status 503.
+func writeCheck(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatusIsReady(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	status := NewStatus(time.Minute)
+
+	if err := status.isReady(start); err == nil {
+		t.Error("isReady() didn't fail before the first synchronization")
+	}
+
+	upstreams := getTestUpstreams()
+	status.observeSyncs([]SyncResult{
+		{Upstream: upstreams[0], Endpoint: "nginx-1", Servers: []string{"10.0.0.1:80"}},
+		{Upstream: upstreams[1], Endpoint: "nginx-1", Servers: []string{"10.0.1.1:5432"}},
+	}, start)
+	if err := status.isReady(start.Add(time.Second)); err != nil {
+		t.Errorf("isReady() failed after a successful synchronization: %v", err)
+	}
+
+	// the stream upstream keeps failing
+	status.observeSyncs([]SyncResult{
+		{Upstream: upstreams[0], Endpoint: "nginx-1", Servers: []string{"10.0.0.1:80"}},
+		{Upstream: upstreams[1], Endpoint: "nginx-1", Err: errors.New("throttled")},
+	}, start.Add(30*time.Second))
+	if err := status.isReady(start.Add(45 * time.Second)); err != nil {
+		t.Errorf("isReady() failed for an upstream synchronized successfully within the max age: %v", err)
+	}
+	if err := status.isReady(start.Add(90 * time.Second)); err == nil {
+		t.Error("isReady() didn't fail for an upstream not synchronized successfully within the max age")
+	}
+
+	// the stream upstream was removed from the config
+	status.observeSyncs([]SyncResult{
+		{Upstream: upstreams[0], Endpoint: "nginx-1", Servers: []string{"10.0.0.1:80"}},
+	}, start.Add(60*time.Second))
+	if err := status.isReady(start.Add(90 * time.Second)); err != nil {
+		t.Errorf("isReady() failed for an upstream that is no longer synchronized: %v", err)
+	}
+}
+
+func TestStatusObservePartialSyncs(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	status := NewStatus(time.Minute)
+
+	upstreams := getTestUpstreams()
+	status.observeSyncs([]SyncResult{
+		{Upstream: upstreams[0], Endpoint: "nginx-1", Servers: []string{"10.0.0.1:80"}},
+		{Upstream: upstreams[1], Endpoint: "nginx-1", Servers: []string{"10.0.1.1:5432"}},
+	}, start)
+	status.observePartialSyncs([]SyncResult{
+		{Upstream: upstreams[0], Endpoint: "nginx-1", Servers: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+	}, start.Add(time.Second))
+
+	got := status.getUpstreams()
+	if len(got) != 2 {
+		t.Fatalf("getUpstreams() returned %v upstreams after a partial synchronization, expected 2", len(got))
+	}
+	if !slices.Equal(got[0].Servers, []string{"10.0.0.1:80", "10.0.0.2:80"}) || !got[0].LastSync.Equal(start.Add(time.Second)) {
+		t.Errorf("observePartialSyncs() didn't record the synchronization of the HTTP upstream: %+v", got[0])
+	}
+	if !got[1].LastSync.Equal(start) {
+		t.Errorf("observePartialSyncs() changed the stream upstream that wasn't synchronized: %+v", got[1])
+	}
+}
+
+func TestStatusIsHealthy(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	status := NewStatus(time.Minute)
+
+	if err := status.isHealthy(start); err != nil {
+		t.Errorf("isHealthy() failed at start: %v", err)
+	}
+
+	status.observeSyncs(nil, start.Add(30*time.Second))
+	if err := status.isHealthy(start.Add(80 * time.Second)); err != nil {
+		t.Errorf("isHealthy() failed within the max age of the last synchronization: %v", err)
+	}
+	if err := status.isHealthy(start.Add(100 * time.Second)); err == nil {
+		t.Error("isHealthy() didn't fail after the max age of the last synchronization")
+	}
+}
+
+func TestStatusHandlers(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.9:80")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.2", "10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+	status := NewStatus(time.Minute)
+	// the stream upstream doesn't exist in NGINX, so its synchronization fails
+	status.observeSyncs(syncer.SyncOnce(context.Background()), time.Now())
+
+	mux := http.NewServeMux()
+	status.registerHandlers(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+		{"/status", http.StatusOK},
+	}
+	for _, test := range tests {
+		code, _ := getHTTP(t, server, test.path)
+		if code != test.expected {
+			t.Errorf("%v returned the status %v, expected %v", test.path, code, test.expected)
+		}
+	}
+
+	_, data := getHTTP(t, server, "/status")
+	var body struct {
+		Upstreams []UpstreamStatus `json:"upstreams"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("couldn't decode /status: %v", err)
+	}
+	if len(body.Upstreams) != 2 {
+		t.Fatalf("/status returned %v upstreams, expected 2", len(body.Upstreams))
+	}
+
+	httpStatus, streamStatus := body.Upstreams[0], body.Upstreams[1]
+	if httpStatus.Name != "backend-http" || httpStatus.ScalingGroup != "group-http" || httpStatus.Endpoint != "nginx-1" ||
+		httpStatus.LastSuccess == nil || httpStatus.LastError != "" || !slices.Equal(httpStatus.Servers, []string{"10.0.0.1:80", "10.0.0.2:80"}) {
+		t.Errorf("/status returned %+v for backend-http", httpStatus)
+	}
+	if streamStatus.Name != "backend-stream" || streamStatus.LastSuccess != nil || streamStatus.LastError == "" || len(streamStatus.Servers) != 0 {
+		t.Errorf("/status returned %+v for backend-stream", streamStatus)
+	}
+}
+
+// getHTTP returns the status code and the body of the response of the server to a GET request of the path.
+func getHTTP(t *testing.T, server *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("couldn't create the request of %v: %v", path, err)
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("couldn't get %v: %v", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("couldn't read the response of %v: %v", path, err)
+	}
+
+	return resp.StatusCode, body
+}
@@ -0,0 +1,794 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v2/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NginxClient is the interface to the NGINX Plus API used to update the servers of upstreams.
+type NginxClient interface {
+	CheckIfUpstreamExists(ctx context.Context, upstream string) error
+	CheckIfStreamUpstreamExists(ctx context.Context, upstream string) error
+	GetHTTPServers(ctx context.Context, upstream string) ([]nginx.UpstreamServer, error)
+	GetStreamServers(ctx context.Context, upstream string) ([]nginx.StreamUpstreamServer, error)
+	GetUpstreams(ctx context.Context) (*nginx.Upstreams, error)
+	UpdateHTTPServers(ctx context.Context, upstream string, servers []nginx.UpstreamServer) ([]nginx.UpstreamServer, []nginx.UpstreamServer, []nginx.UpstreamServer, error)
+	UpdateStreamServers(ctx context.Context, upstream string, servers []nginx.StreamUpstreamServer) ([]nginx.StreamUpstreamServer, []nginx.StreamUpstreamServer, []nginx.StreamUpstreamServer, error)
+}
+
+// errUpdateRefused is returned when an update of the servers of an upstream exceeds its removal limits.
+var errUpdateRefused = errors.New("the update of the servers exceeds the removal limits")
+
+// SyncResult is the result of the synchronization of a single upstream in a single NGINX Plus instance.
+type SyncResult struct {
+	Err error
+	// Endpoint is the address of the API of the NGINX Plus instance.
+	Endpoint string
+	Added    []string
+	Removed  []string
+	Updated  []string
+	Draining []string
+	// Fallback is how the servers were synchronized although the lookup of the scaling group failed, with Err set to
+	// the error of the lookup: "cache" with the last instances looked up, "empty" without instances because they are
+	// stale. It is empty if the lookup succeeded or the servers weren't synchronized.
+	Fallback string
+	// Servers are the addresses of the servers of the upstream after the synchronization.
+	Servers  []string
+	Upstream Upstream
+}
+
+// Changed returns true if any server of the upstream was added, removed or updated.
+func (r SyncResult) Changed() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0 || len(r.Updated) > 0
+}
+
+// Syncer synchronizes the servers of NGINX Plus upstreams with the instances of the cloud provider scaling groups.
+// The instances of every scaling group are looked up once per synchronization and applied to every NGINX Plus instance.
+type Syncer struct {
+	cloudProvider CloudProvider
+	metrics       *Metrics
+	endpoints     []*nginxEndpoint
+	upstreams     []Upstream
+	// cache maps the name of a scaling group to its last instances looked up and the state of its lookups.
+	cache         map[string]*scalingGroupCache
+	lookupOptions LookupOptions
+	// maxConcurrency is the maximum number of upstreams synchronized at the same time.
+	maxConcurrency int
+	// dryRun is true if the Syncer only reports the changes it would make to NGINX Plus.
+	dryRun bool
+}
+
+// NginxEndpoint is an NGINX Plus instance synchronized by the Syncer.
+type NginxEndpoint struct {
+	Client NginxClient
+	// Address is the address of the NGINX Plus API, it identifies the instance in the logs and the results.
+	Address string
+}
+
+// nginxEndpoint is an NginxEndpoint with its synchronization state.
+type nginxEndpoint struct {
+	// draining maps the name of an upstream to its servers in drain mode and the time their draining started.
+	draining map[string]map[string]time.Time
+	NginxEndpoint
+	// mu protects draining, the upstreams of the endpoint are synchronized concurrently.
+	mu sync.Mutex
+}
+
+// getDraining returns the servers of the upstream in drain mode and the time their draining started.
+func (e *nginxEndpoint) getDraining(upstream string) map[string]time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.draining[upstream]
+}
+
+// setDraining sets the servers of the upstream in drain mode and the time their draining started.
+func (e *nginxEndpoint) setDraining(upstream string, servers map[string]time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.draining[upstream] = servers
+}
+
+// NewSyncer creates a Syncer for the upstreams of the cloud provider in the NGINX Plus instances.
+func NewSyncer(cloudProvider CloudProvider, endpoints []NginxEndpoint, metrics *Metrics) *Syncer {
+	upstreams := cloudProvider.GetUpstreams()
+	return &Syncer{
+		cloudProvider:  cloudProvider,
+		metrics:        metrics,
+		endpoints:      newNginxEndpoints(endpoints, nil, upstreams),
+		upstreams:      upstreams,
+		cache:          make(map[string]*scalingGroupCache),
+		lookupOptions:  LookupOptions{StalePolicy: stalePolicyKeep},
+		maxConcurrency: defaultMaxConcurrency,
+	}
+}
+
+// The stale policies of LookupOptions and the fallbacks of SyncResult.
+const (
+	stalePolicyKeep  = "keep"
+	stalePolicyEmpty = "empty"
+	fallbackCache    = "cache"
+	fallbackEmpty    = "empty"
+)
+
+// LookupOptions configures how the Syncer handles the failed lookups of the instances of the scaling groups.
+type LookupOptions struct {
+	// StalePolicy is what happens to the servers of the upstreams of a scaling group whose last instances looked up
+	// are stale: keep leaves the servers as they are, empty removes them.
+	StalePolicy string
+	// StaleAfter is how long the last instances looked up are used when the lookups fail. Zero means forever.
+	StaleAfter time.Duration
+	// BackoffBase is the delay before the lookup after a failure. It doubles with every failure in a row up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+// SetLookupOptions sets how the Syncer handles the failed lookups of the instances of the scaling groups.
+// SetLookupOptions must not be called concurrently with SyncOnce.
+func (s *Syncer) SetLookupOptions(options LookupOptions) {
+	s.lookupOptions = options
+}
+
+// SetMaxConcurrency sets the maximum number of upstreams synchronized at the same time.
+// SetMaxConcurrency must not be called concurrently with SyncOnce.
+func (s *Syncer) SetMaxConcurrency(maxConcurrency int) {
+	s.maxConcurrency = max(maxConcurrency, 1)
+}
+
+// NewDryRunSyncer creates a Syncer for the upstreams of the cloud provider that doesn't change NGINX Plus.
+// Its results report the servers it would add, remove and update.
+func NewDryRunSyncer(cloudProvider CloudProvider, endpoints []NginxEndpoint, metrics *Metrics) *Syncer {
+	dryRunEndpoints := make([]NginxEndpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		dryRunEndpoints = append(dryRunEndpoints, NginxEndpoint{Client: dryRunNginxClient{e.Client}, Address: e.Address})
+	}
+
+	s := NewSyncer(cloudProvider, dryRunEndpoints, metrics)
+	s.dryRun = true
+	return s
+}
+
+// newNginxEndpoints creates the state of the endpoints, keeping the drain state of the previous endpoints with the same
+// address for the upstreams that still drain their servers.
+func newNginxEndpoints(endpoints []NginxEndpoint, previous []*nginxEndpoint, upstreams []Upstream) []*nginxEndpoint {
+	result := make([]*nginxEndpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		draining := make(map[string]map[string]time.Time)
+		i := slices.IndexFunc(previous, func(p *nginxEndpoint) bool { return p.Address == e.Address })
+		if i != -1 {
+			for _, ups := range upstreams {
+				if servers, ok := previous[i].draining[ups.Name]; ok && ups.DrainTimeout > 0 {
+					draining[ups.Name] = servers
+				}
+			}
+		}
+
+		result = append(result, &nginxEndpoint{NginxEndpoint: e, draining: draining})
+	}
+
+	return result
+}
+
+// CheckUpstreams checks that every upstream exists in NGINX Plus and warns about the scaling groups that don't exist in the cloud provider.
+// It fails only if the upstreams can't be checked in any NGINX Plus instance.
+func (s *Syncer) CheckUpstreams(ctx context.Context) error {
+	return checkUpstreams(ctx, s.cloudProvider, s.endpoints)
+}
+
+// Reload replaces the cloud provider and the NGINX Plus instances of the Syncer after checking their upstreams.
+// If the check fails, the Syncer keeps its previous ones. The drain state of the upstreams that remain is preserved.
+// Reload must not be called concurrently with SyncOnce.
+func (s *Syncer) Reload(ctx context.Context, cloudProvider CloudProvider, endpoints []NginxEndpoint) error {
+	upstreams := cloudProvider.GetUpstreams()
+	newEndpoints := newNginxEndpoints(endpoints, s.endpoints, upstreams)
+
+	err := checkUpstreams(ctx, cloudProvider, newEndpoints)
+	if err != nil {
+		return err
+	}
+
+	for _, previous := range s.upstreams {
+		if !slices.ContainsFunc(upstreams, func(ups Upstream) bool { return ups.Name == previous.Name }) {
+			s.metrics.forget(prometheus.Labels{"upstream": previous.Name})
+		}
+	}
+	for _, previous := range s.endpoints {
+		if !slices.ContainsFunc(endpoints, func(e NginxEndpoint) bool { return e.Address == previous.Address }) {
+			s.metrics.forget(prometheus.Labels{"endpoint": previous.Address})
+		}
+	}
+
+	// the last instances of a scaling group now looked up elsewhere, for example in another region, are dropped
+	for group := range s.cache {
+		if !slices.ContainsFunc(upstreams, func(ups Upstream) bool { return ups.hasScalingGroup(group) }) ||
+			getScalingGroupLocation(s.cloudProvider, group) != getScalingGroupLocation(cloudProvider, group) {
+			delete(s.cache, group)
+		}
+	}
+
+	s.cloudProvider = cloudProvider
+	s.endpoints = newEndpoints
+	s.upstreams = upstreams
+
+	return nil
+}
+
+// getScalingGroupLocation returns where the cloud provider looks up the scaling group: the type of the cloud provider,
+// followed by the location of the scaling group if the cloud provider is a ScalingGroupLocator.
+func getScalingGroupLocation(cloudProvider CloudProvider, name string) string {
+	location := fmt.Sprintf("%T", cloudProvider)
+	if locator, ok := cloudProvider.(ScalingGroupLocator); ok {
+		location += "/" + locator.GetScalingGroupLocation(name)
+	}
+
+	return location
+}
+
+func checkUpstreams(ctx context.Context, cloudProvider CloudProvider, endpoints []*nginxEndpoint) error {
+	upstreams := cloudProvider.GetUpstreams()
+	var checked []string
+	for _, ups := range upstreams {
+		for _, group := range ups.ScalingGroups {
+			if slices.Contains(checked, group.Name) {
+				continue
+			}
+			checked = append(checked, group.Name)
+
+			exists, err := cloudProvider.CheckIfScalingGroupExists(ctx, group.Name)
+			if err != nil {
+				// the cloud provider might be unavailable for a while, the synchronizations back off and retry
+				slog.Warn("Couldn't check if the scaling group exists", "scaling_group", group.Name, "error", err)
+			} else if !exists {
+				slog.Warn("Scaling group doesn't exist in the cloud provider", "scaling_group", group.Name)
+			}
+		}
+	}
+
+	var errs []error
+	for _, e := range endpoints {
+		err := e.checkUpstreams(ctx, upstreams)
+		if err != nil {
+			slog.Warn("Couldn't check the upstreams", "endpoint", e.Address, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(endpoints) {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// checkUpstreams checks that every upstream exists in the NGINX Plus instance.
+func (e *nginxEndpoint) checkUpstreams(ctx context.Context, upstreams []Upstream) error {
+	for _, ups := range upstreams {
+		var err error
+		if ups.Kind == "http" {
+			err = e.Client.CheckIfUpstreamExists(ctx, ups.Name)
+		} else {
+			err = e.Client.CheckIfStreamUpstreamExists(ctx, ups.Name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("problem with the NGINX configuration of %v: %w", e.Address, err)
+		}
+	}
+
+	return nil
+}
+
+// SyncOnce synchronizes every upstream in every NGINX Plus instance once and returns the result for each of them.
+// Up to maxConcurrency upstreams are synchronized at the same time and the instances of a scaling group shared by several
+// upstreams are looked up once. An error of an NGINX Plus instance doesn't affect the synchronization of the other instances.
+// The results are in the order of the upstreams, then of the NGINX Plus instances.
+func (s *Syncer) SyncOnce(ctx context.Context) []SyncResult {
+	return s.syncUpstreams(ctx, s.upstreams)
+}
+
+// SyncScalingGroups synchronizes the upstreams of the scaling groups in every NGINX Plus instance once, like SyncOnce,
+// and returns the result for each of them. The upstreams with several scaling groups are synchronized with all of them.
+// The scaling groups that no upstream uses are ignored. SyncScalingGroups must not be called concurrently with SyncOnce.
+func (s *Syncer) SyncScalingGroups(ctx context.Context, scalingGroups []string) []SyncResult {
+	var upstreams []Upstream
+	for _, upstream := range s.upstreams {
+		if slices.ContainsFunc(scalingGroups, upstream.hasScalingGroup) {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+
+	return s.syncUpstreams(ctx, upstreams)
+}
+
+func (s *Syncer) syncUpstreams(ctx context.Context, upstreams []Upstream) []SyncResult {
+	lookups := make(map[string]*scalingGroupLookup)
+	for _, upstream := range upstreams {
+		for _, group := range upstream.ScalingGroups {
+			if _, ok := lookups[group.Name]; !ok {
+				if _, ok := s.cache[group.Name]; !ok {
+					s.cache[group.Name] = &scalingGroupCache{}
+				}
+				lookups[group.Name] = &scalingGroupLookup{cache: s.cache[group.Name]}
+			}
+		}
+	}
+
+	results := make([]SyncResult, len(upstreams)*len(s.endpoints))
+	sem := make(chan struct{}, s.maxConcurrency)
+	var wg sync.WaitGroup
+	for i, upstream := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var l *upstreamLookup
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				l = s.lookupUpstream(ctx, upstream, lookups)
+			case <-ctx.Done():
+				l = &upstreamLookup{err: fmt.Errorf("the synchronization was canceled: %w", ctx.Err())}
+			}
+
+			s.syncUpstreamInEndpoints(ctx, upstream, l, results[i*len(s.endpoints):(i+1)*len(s.endpoints)])
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// scalingGroupLookup is the lookup of the instances of a scaling group, shared by the upstreams of the group in a synchronization.
+type scalingGroupLookup struct {
+	// start is the time of the lookup, the age of the last instances looked up is measured from it.
+	start time.Time
+	err   error
+	cache *scalingGroupCache
+	// instances are the instances looked up, nil if the lookup failed.
+	instances []Instance
+	duration  time.Duration
+	once      sync.Once
+}
+
+// getFallback returns how an upstream with the lookup options is synchronized after the lookup failed, see
+// SyncResult.Fallback, and the instances it is synchronized with.
+func (l *scalingGroupLookup) getFallback(options LookupOptions) (string, []Instance) {
+	if l.cache.updated.IsZero() {
+		return "", nil
+	}
+
+	switch {
+	case options.StaleAfter == 0 || l.start.Sub(l.cache.updated) <= options.StaleAfter:
+		return fallbackCache, l.cache.instances
+	case options.StalePolicy == stalePolicyEmpty:
+		return fallbackEmpty, nil
+	}
+
+	return "", nil
+}
+
+// upstreamLookup is the lookup of the instances of the scaling groups of an upstream in a synchronization.
+type upstreamLookup struct {
+	err error
+	// fallback is how the upstream is synchronized if the lookup failed, see SyncResult.Fallback.
+	fallback  string
+	instances []upstreamInstance
+	duration  time.Duration
+}
+
+// upstreamInstance is an instance of a scaling group of an upstream.
+type upstreamInstance struct {
+	// weight is the weight of the scaling group in the upstream, nil if the group doesn't set the weight.
+	weight *int
+	Instance
+}
+
+// lookupUpstream looks up the instances of the scaling groups of the upstream and merges them. An instance in several
+// groups is kept once, with the weight of the first group. If the lookup of a group fails, the upstream is only
+// synchronized if every failed group has a fallback, and it is synchronized without instances if any of them is empty.
+func (s *Syncer) lookupUpstream(ctx context.Context, upstream Upstream, lookups map[string]*scalingGroupLookup) *upstreamLookup {
+	result := &upstreamLookup{}
+	options := s.getLookupOptions(upstream)
+	var errs []error
+	withoutFallback := false
+	seen := make(map[string]bool)
+	for _, group := range upstream.ScalingGroups {
+		l := lookups[group.Name]
+		s.lookup(ctx, l, group.Name)
+		result.duration += l.duration
+
+		instances := l.instances
+		if l.err != nil {
+			var fallback string
+			fallback, instances = l.getFallback(options)
+			errs = append(errs, l.err)
+			withoutFallback = withoutFallback || fallback == ""
+			if result.fallback != fallbackEmpty {
+				result.fallback = fallback
+			}
+		}
+
+		for _, ins := range instances {
+			// an instance may only have an IPv6 address
+			if key := ins.IP + "," + ins.IPv6; !seen[key] {
+				seen[key] = true
+				result.instances = append(result.instances, upstreamInstance{Instance: ins, weight: group.Weight})
+			}
+		}
+	}
+
+	result.err = errors.Join(errs...)
+	switch {
+	case withoutFallback:
+		result.fallback = ""
+	case result.fallback == fallbackEmpty:
+		result.instances = nil
+	}
+
+	return result
+}
+
+// scalingGroupCache is the last instances of a scaling group looked up and the state of the failed lookups since then.
+type scalingGroupCache struct {
+	updated time.Time
+	// nextLookup is the time before which the group isn't looked up, after failed lookups.
+	nextLookup time.Time
+	// lastErr is the error of the last failed lookup.
+	lastErr   error
+	instances []Instance
+	failures  int
+}
+
+// getLookupOptions returns the lookup options of the upstream: those of the Syncer, with the stale_after and the
+// stale_policy of the upstream if it sets them.
+func (s *Syncer) getLookupOptions(upstream Upstream) LookupOptions {
+	options := s.lookupOptions
+	if upstream.StaleAfter != nil {
+		options.StaleAfter = *upstream.StaleAfter
+	}
+	if upstream.StalePolicy != "" {
+		options.StalePolicy = upstream.StalePolicy
+	}
+
+	return options
+}
+
+// lookup looks up the instances of the scaling group, unless another upstream of the group already did.
+// After failed lookups, the group isn't looked up again until the backoff delay passes. If the lookup fails, the
+// upstreams of the group fall back to the last instances looked up until they are stale, see getFallback.
+func (s *Syncer) lookup(ctx context.Context, l *scalingGroupLookup, scalingGroup string) {
+	l.once.Do(func() {
+		start := time.Now()
+		l.start = start
+		c := l.cache
+
+		if start.Before(c.nextLookup) {
+			l.err = fmt.Errorf("couldn't get the IP addresses for %v, backing off until %v after %v failures: %w",
+				scalingGroup, c.nextLookup.Format(time.RFC3339), c.failures, c.lastErr)
+		} else {
+			instances, err := s.cloudProvider.GetPrivateIPsForScalingGroup(ctx, scalingGroup)
+			s.metrics.observeCall("GetPrivateIPsForScalingGroup", start, err)
+			if err != nil {
+				c.failures++
+				c.lastErr = err
+				c.nextLookup = start.Add(getLookupBackoff(s.lookupOptions.BackoffBase, s.lookupOptions.BackoffMax, c.failures))
+				l.err = fmt.Errorf("couldn't get the IP addresses for %v: %w", scalingGroup, err)
+			} else {
+				*c = scalingGroupCache{updated: start, instances: instances}
+				l.instances = instances
+			}
+		}
+
+		l.duration = time.Since(start)
+	})
+}
+
+// getLookupBackoff returns the delay before the next lookup of a scaling group after the failures in a row: the base
+// delay doubled with every failure after the first, up to the max, with a random jitter of ±50%.
+func getLookupBackoff(base time.Duration, maxDelay time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	// the jitter spreads the lookups of the scaling groups that failed at the same time
+	return time.Duration(float64(delay) * (0.5 + rand.Float64())) //nolint:gosec // the jitter doesn't need a secure random number
+}
+
+// syncUpstreamInEndpoints synchronizes the upstream with the instances of the lookup in every NGINX Plus instance and
+// stores the result for each of them in results. If the lookup failed, the upstream is only synchronized if the lookup
+// has a fallback.
+func (s *Syncer) syncUpstreamInEndpoints(ctx context.Context, upstream Upstream, l *upstreamLookup, results []SyncResult) {
+	for i, e := range s.endpoints {
+		start := time.Now()
+		result := SyncResult{Upstream: upstream, Endpoint: e.Address, Err: l.err}
+		if l.err == nil || l.fallback != "" {
+			result = s.syncUpstream(ctx, e, upstream, l.instances)
+			if result.Err == nil && l.err != nil {
+				result.Err = l.err
+				result.Fallback = l.fallback
+			}
+		}
+		s.metrics.observeSync(result, l.duration+time.Since(start))
+		logSyncResult(slog.Default(), result, s.dryRun)
+		results[i] = result
+	}
+}
+
+func (s *Syncer) syncUpstream(ctx context.Context, e *nginxEndpoint, upstream Upstream, instances []upstreamInstance) SyncResult {
+	result := SyncResult{Upstream: upstream, Endpoint: e.Address}
+
+	if upstream.Kind == "http" {
+		var upsServers []nginx.UpstreamServer
+		for _, ins := range instances {
+			for _, ip := range ins.getAddresses(upstream.AddressFamily) {
+				backend := net.JoinHostPort(ip, strconv.Itoa(upstream.Port))
+				server := nginx.UpstreamServer{
+					Server:      backend,
+					MaxConns:    upstream.MaxConns,
+					MaxFails:    upstream.MaxFails,
+					FailTimeout: upstream.FailTimeout,
+					SlowStart:   upstream.SlowStart,
+				}
+				if upstream.ServerParametersFromTags {
+					server.Weight = ins.Weight
+					server.Backup = ins.Backup
+					server.Route = ins.Route
+				}
+				if ins.weight != nil {
+					server.Weight = ins.weight
+				}
+				upsServers = append(upsServers, server)
+			}
+		}
+
+		if upstream.DrainTimeout > 0 || hasRemovalLimits(upstream) {
+			serversInNginx, err := e.Client.GetHTTPServers(ctx, upstream.Name)
+			if err != nil {
+				result.Err = fmt.Errorf("couldn't get HTTP servers from NGINX: %w", err)
+				return result
+			}
+
+			err = e.checkRemovalLimits(upstream, getUpstreamServerAddresses(serversInNginx), getUpstreamServerAddresses(upsServers))
+			if err != nil {
+				result.Err = err
+				return result
+			}
+
+			if upstream.DrainTimeout > 0 {
+				upsServers, result.Draining, err = e.keepDrainingServers(ctx, upstream, upsServers, serversInNginx)
+				if err != nil {
+					result.Err = fmt.Errorf("couldn't drain HTTP servers in NGINX: %w", err)
+					return result
+				}
+			}
+		}
+
+		start := time.Now()
+		added, removed, updated, err := e.Client.UpdateHTTPServers(ctx, upstream.Name, upsServers)
+		s.metrics.observeCall("UpdateHTTPServers", start, err)
+		if err != nil {
+			result.Err = fmt.Errorf("couldn't update HTTP servers in NGINX: %w", err)
+			return result
+		}
+
+		result.Servers = getUpstreamServerAddresses(upsServers)
+		result.Added = getUpstreamServerAddresses(added)
+		result.Removed = getUpstreamServerAddresses(removed)
+		result.Updated = getUpstreamServerAddresses(updated)
+	} else {
+		var upsServers []nginx.StreamUpstreamServer
+		for _, ins := range instances {
+			for _, ip := range ins.getAddresses(upstream.AddressFamily) {
+				backend := net.JoinHostPort(ip, strconv.Itoa(upstream.Port))
+				server := nginx.StreamUpstreamServer{
+					Server:      backend,
+					MaxConns:    upstream.MaxConns,
+					MaxFails:    upstream.MaxFails,
+					FailTimeout: upstream.FailTimeout,
+					SlowStart:   upstream.SlowStart,
+				}
+				if upstream.ServerParametersFromTags {
+					server.Weight = ins.Weight
+					server.Backup = ins.Backup
+				}
+				if ins.weight != nil {
+					server.Weight = ins.weight
+				}
+				upsServers = append(upsServers, server)
+			}
+		}
+
+		if hasRemovalLimits(upstream) {
+			serversInNginx, err := e.Client.GetStreamServers(ctx, upstream.Name)
+			if err != nil {
+				result.Err = fmt.Errorf("couldn't get Stream servers from NGINX: %w", err)
+				return result
+			}
+
+			err = e.checkRemovalLimits(upstream, getStreamUpstreamServerAddresses(serversInNginx), getStreamUpstreamServerAddresses(upsServers))
+			if err != nil {
+				result.Err = err
+				return result
+			}
+		}
+
+		start := time.Now()
+		added, removed, updated, err := e.Client.UpdateStreamServers(ctx, upstream.Name, upsServers)
+		s.metrics.observeCall("UpdateStreamServers", start, err)
+		if err != nil {
+			result.Err = fmt.Errorf("couldn't update Stream servers in NGINX: %w", err)
+			return result
+		}
+
+		result.Servers = getStreamUpstreamServerAddresses(upsServers)
+		result.Added = getStreamUpstreamServerAddresses(added)
+		result.Removed = getStreamUpstreamServerAddresses(removed)
+		result.Updated = getStreamUpstreamServerAddresses(updated)
+	}
+
+	return result
+}
+
+// logSyncResult logs the result of the synchronization of an upstream in an NGINX Plus instance as a structured record.
+// The changes of a dry run are not logged, they are printed instead.
+func logSyncResult(logger *slog.Logger, result SyncResult, dryRun bool) {
+	attrs := []any{
+		"upstream", result.Upstream.Name,
+		"scaling_group", result.Upstream.getScalingGroupNames(),
+		"kind", result.Upstream.Kind,
+		"endpoint", result.Endpoint,
+	}
+
+	switch {
+	case result.Fallback != "":
+		logger.Warn("Couldn't get the instances, synchronized the upstream with the fallback", append(attrs, "fallback", result.Fallback,
+			"error", result.Err, "added", result.Added, "removed", result.Removed, "updated", result.Updated)...)
+	case errors.Is(result.Err, errUpdateRefused):
+		logger.Warn("Refused to update the servers", append(attrs, "error", result.Err)...)
+	case result.Err != nil:
+		logger.Error("Couldn't synchronize the upstream", append(attrs, "error", result.Err)...)
+	case result.Changed() && !dryRun:
+		logger.Info("Updated the servers", append(attrs, "added", result.Added, "removed", result.Removed, "updated", result.Updated)...)
+	default:
+		logger.Debug("Synchronized the upstream", append(attrs, "servers", result.Servers)...)
+	}
+}
+
+func hasRemovalLimits(upstream Upstream) bool {
+	return upstream.MinServers > 0 || upstream.MaxRemovalPercent > 0
+}
+
+// checkRemovalLimits returns errUpdateRefused if replacing the servers of the upstream with the servers of the scaling group
+// would drop the upstream below its min_servers or remove more than its max_removal_percent of the servers.
+// Servers that are already being drained don't count as removed.
+func (e *nginxEndpoint) checkRemovalLimits(upstream Upstream, serversInNginx []string, serversInScalingGroup []string) error {
+	inScalingGroup := make(map[string]bool, len(serversInScalingGroup))
+	for _, server := range serversInScalingGroup {
+		inScalingGroup[server] = true
+	}
+
+	draining := e.getDraining(upstream.Name)
+	current := 0
+	removed := 0
+	for _, server := range serversInNginx {
+		if _, ok := draining[server]; ok {
+			continue
+		}
+		current++
+		if !inScalingGroup[server] {
+			removed++
+		}
+	}
+
+	if len(serversInScalingGroup) < upstream.MinServers && len(serversInScalingGroup) < current {
+		return fmt.Errorf("%w: %v servers is fewer than min_servers %v", errUpdateRefused, len(serversInScalingGroup), upstream.MinServers)
+	}
+
+	if upstream.MaxRemovalPercent > 0 && removed*100 > upstream.MaxRemovalPercent*current {
+		return fmt.Errorf("%w: removing %v of %v servers is more than max_removal_percent %v", errUpdateRefused, removed, current, upstream.MaxRemovalPercent)
+	}
+
+	return nil
+}
+
+// keepDrainingServers adds to the servers the servers of the upstream that are no longer in the scaling group, in drain mode.
+// A server is kept until it has no active connections or the drain timeout of the upstream expires, then it is removed.
+func (e *nginxEndpoint) keepDrainingServers(ctx context.Context, upstream Upstream, servers []nginx.UpstreamServer, serversInNginx []nginx.UpstreamServer) ([]nginx.UpstreamServer, []string, error) {
+	var err error
+	previouslyDraining := e.getDraining(upstream.Name)
+
+	inScalingGroup := make(map[string]bool, len(servers))
+	for i := range servers {
+		inScalingGroup[servers[i].Server] = true
+		if _, ok := previouslyDraining[servers[i].Server]; ok {
+			// the server came back to the scaling group, bring it out of drain mode
+			down := false
+			servers[i].Down = &down
+		}
+	}
+
+	var removedFromScalingGroup []nginx.UpstreamServer
+	checkConnections := false
+	for _, server := range serversInNginx {
+		if !inScalingGroup[server.Server] {
+			removedFromScalingGroup = append(removedFromScalingGroup, server)
+			_, ok := previouslyDraining[server.Server]
+			checkConnections = checkConnections || ok
+		}
+	}
+
+	var activeConnections map[string]uint64
+	if checkConnections {
+		activeConnections, err = getActiveConnections(ctx, e.Client, upstream.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	now := time.Now()
+	draining := make(map[string]time.Time)
+	var drainingAddresses []string
+	for _, server := range removedFromScalingGroup {
+		start, ok := previouslyDraining[server.Server]
+		if !ok {
+			start = now
+			slog.Info("Draining the server", "server", server.Server, "upstream", upstream.Name, "scaling_group", upstream.getScalingGroupNames(),
+				"endpoint", e.Address)
+		} else if activeConnections[server.Server] == 0 || now.Sub(start) >= upstream.DrainTimeout {
+			continue
+		}
+
+		draining[server.Server] = start
+		drainingAddresses = append(drainingAddresses, server.Server)
+		server.Drain = true
+		servers = append(servers, server)
+	}
+	e.setDraining(upstream.Name, draining)
+
+	return servers, drainingAddresses, nil
+}
+
+// getActiveConnections returns the number of active connections of every server of the HTTP upstream.
+func getActiveConnections(ctx context.Context, nginxClient NginxClient, name string) (map[string]uint64, error) {
+	upstreams, err := nginxClient.GetUpstreams(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the active connections of %v: %w", name, err)
+	}
+
+	activeConnections := make(map[string]uint64)
+	for _, peer := range (*upstreams)[name].Peers {
+		activeConnections[peer.Server] = peer.Active
+	}
+
+	return activeConnections, nil
+}
+
+func getUpstreamServerAddresses(server []nginx.UpstreamServer) []string {
+	upstreamServerAddr := make([]string, 0, len(server))
+	for _, s := range server {
+		upstreamServerAddr = append(upstreamServerAddr, s.Server)
+	}
+	return upstreamServerAddr
+}
+
+func getStreamUpstreamServerAddresses(server []nginx.StreamUpstreamServer) []string {
+	streamUpstreamServerAddr := make([]string, 0, len(server))
+	for _, s := range server {
+		streamUpstreamServerAddr = append(streamUpstreamServerAddr, s.Server)
+	}
+	return streamUpstreamServerAddr
+}
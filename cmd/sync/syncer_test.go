@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeCloudProvider is a CloudProvider that returns predefined instances for its scaling groups.
+type fakeCloudProvider struct {
+	err error
+	// ips maps a scaling group name to the IP addresses of its instances without tags.
+	ips map[string][]string
+	// instances maps a scaling group name to its instances, it takes precedence over ips.
+	instances map[string][]Instance
+	// groupErrs maps a scaling group name to the error of its lookups.
+	groupErrs map[string]error
+	// locations maps a scaling group name to its location.
+	locations map[string]string
+	upstreams []Upstream
+	// lookups is the number of calls to GetPrivateIPsForScalingGroup.
+	lookups int
+	// maxInFlight is the maximum number of calls to GetPrivateIPsForScalingGroup in progress at the same time.
+	maxInFlight int
+	inFlight    int
+	// delay is the duration of a call to GetPrivateIPsForScalingGroup.
+	delay time.Duration
+	mu    sync.Mutex
+}
+
+func (f *fakeCloudProvider) GetPrivateIPsForScalingGroup(ctx context.Context, name string) ([]Instance, error) {
+	f.mu.Lock()
+	f.lookups++
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if err := f.groupErrs[name]; err != nil {
+		return nil, err
+	}
+
+	if instances, ok := f.instances[name]; ok {
+		return instances, nil
+	}
+
+	instances := make([]Instance, 0, len(f.ips[name]))
+	for _, ip := range f.ips[name] {
+		instances = append(instances, Instance{IP: ip})
+	}
+
+	return instances, nil
+}
+
+func (f *fakeCloudProvider) CheckIfScalingGroupExists(_ context.Context, name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return false, f.err
+	}
+
+	_, ok := f.ips[name]
+	return ok, nil
+}
+
+func (f *fakeCloudProvider) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func (f *fakeCloudProvider) GetUpstreams() []Upstream {
+	return f.upstreams
+}
+
+func (f *fakeCloudProvider) GetScalingGroupLocation(name string) string {
+	return f.locations[name]
+}
+
+func getTestUpstreams() []Upstream {
+	maxConns := 0
+	maxFails := 1
+	return []Upstream{
+		{
+			Name:          "backend-http",
+			ScalingGroups: []ScalingGroup{{Name: "group-http"}},
+			Kind:          "http",
+			Port:          80,
+			MaxConns:      &maxConns,
+			MaxFails:      &maxFails,
+			FailTimeout:   defaultFailTimeout,
+			SlowStart:     defaultSlowStart,
+		},
+		{
+			Name:          "backend-stream",
+			ScalingGroups: []ScalingGroup{{Name: "group-stream"}},
+			Kind:          "stream",
+			Port:          5432,
+			MaxConns:      &maxConns,
+			MaxFails:      &maxFails,
+			FailTimeout:   defaultFailTimeout,
+			SlowStart:     defaultSlowStart,
+		},
+	}
+}
+
+func TestSyncOnce(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.9:80")
+	api.addUpstream("stream", "backend-stream")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1", "10.0.0.2"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	results := syncer.SyncOnce(context.Background())
+	if len(results) != 2 {
+		t.Fatalf("SyncOnce() returned %v results, expected 2", len(results))
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("SyncOnce() returned an error for the upstream %v: %v", result.Upstream.Name, result.Err)
+		}
+	}
+
+	httpResult := results[0]
+	if !slices.Equal(httpResult.Added, []string{"10.0.0.2:80"}) || !slices.Equal(httpResult.Removed, []string{"10.0.0.9:80"}) {
+		t.Errorf("SyncOnce() returned added %v and removed %v, expected [10.0.0.2:80] and [10.0.0.9:80]", httpResult.Added, httpResult.Removed)
+	}
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.1:80", "10.0.0.2:80"}) {
+		t.Errorf("the HTTP upstream has servers %v after SyncOnce(), expected [10.0.0.1:80 10.0.0.2:80]", got)
+	}
+	if got := api.servers("stream", "backend-stream"); !slices.Equal(got, []string{"10.0.1.1:5432"}) {
+		t.Errorf("the stream upstream has servers %v after SyncOnce(), expected [10.0.1.1:5432]", got)
+	}
+
+	results = syncer.SyncOnce(context.Background())
+	for _, result := range results {
+		if result.Changed() {
+			t.Errorf("SyncOnce() changed the upstream %v although the scaling group didn't change: %+v", result.Upstream.Name, result)
+		}
+	}
+}
+
+func TestSyncOnceServerParametersFromTags(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+
+	weight := 5
+	backup := true
+	cloud := &fakeCloudProvider{
+		instances: map[string][]Instance{
+			"group-http":   {{IP: "10.0.0.1", Weight: &weight, Route: "a"}, {IP: "10.0.0.2", Backup: &backup}},
+			"group-stream": {{IP: "10.0.1.1", Weight: &weight, Route: "a"}},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	cloud.upstreams[0].ServerParametersFromTags = true
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	for _, result := range syncer.SyncOnce(context.Background()) {
+		if result.Err != nil {
+			t.Errorf("SyncOnce() returned an error for the upstream %v: %v", result.Upstream.Name, result.Err)
+		}
+	}
+
+	server, _ := api.server("http", "backend-http", "10.0.0.1:80")
+	if server.Weight == nil || *server.Weight != weight || server.Route != "a" {
+		t.Errorf("the HTTP server 10.0.0.1:80 has weight %v and route %q, expected weight 5 and route a", server.Weight, server.Route)
+	}
+	server, _ = api.server("http", "backend-http", "10.0.0.2:80")
+	if server.Backup == nil || !*server.Backup {
+		t.Errorf("the HTTP server 10.0.0.2:80 has backup %v, expected true", server.Backup)
+	}
+	// the stream upstream doesn't set server_parameters_from_tags
+	server, _ = api.server("stream", "backend-stream", "10.0.1.1:5432")
+	if server.Weight != nil || server.Route != "" {
+		t.Errorf("the stream server 10.0.1.1:5432 has weight %v and route %q, expected no parameters from the tags", server.Weight, server.Route)
+	}
+
+	weight = 2
+	results := syncer.SyncOnce(context.Background())
+	if !slices.Equal(results[0].Updated, []string{"10.0.0.1:80"}) {
+		t.Errorf("SyncOnce() updated %v after the weight tag changed, expected [10.0.0.1:80]", results[0].Updated)
+	}
+}
+
+func TestSyncOnceSharedScalingGroup(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+
+	upstreams := getTestUpstreams()
+	upstreams[1].ScalingGroups = []ScalingGroup{{Name: "group-http"}}
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+		upstreams: upstreams,
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	for _, result := range syncer.SyncOnce(context.Background()) {
+		if result.Err != nil {
+			t.Errorf("SyncOnce() returned an error for the upstream %v: %v", result.Upstream.Name, result.Err)
+		}
+	}
+
+	if cloud.lookups != 1 {
+		t.Errorf("SyncOnce() looked up the shared scaling group %v times, expected 1", cloud.lookups)
+	}
+	if got := api.servers("stream", "backend-stream"); !slices.Equal(got, []string{"10.0.0.1:5432"}) {
+		t.Errorf("the stream upstream has servers %v after SyncOnce(), expected [10.0.0.1:5432]", got)
+	}
+}
+
+func TestSyncOnceMultipleScalingGroups(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.9:80")
+	api.addUpstream("stream", "backend-stream")
+
+	weight := 3
+	upstreams := getTestUpstreams()
+	upstreams[0].ScalingGroups = []ScalingGroup{{Name: "group-blue"}, {Name: "group-green", Weight: &weight}}
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-blue":   {"10.0.0.1", "10.0.0.2"},
+			"group-green":  {"10.0.0.2", "10.0.0.3"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: upstreams,
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	results := syncer.SyncOnce(context.Background())
+	if results[0].Err != nil {
+		t.Fatalf("SyncOnce() returned an error for the upstream with several scaling groups: %v", results[0].Err)
+	}
+
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}) {
+		t.Errorf("the HTTP upstream has servers %v after SyncOnce(), expected [10.0.0.1:80 10.0.0.2:80 10.0.0.3:80]", got)
+	}
+	// the instance in both groups has the weight of the first one
+	for server, expected := range map[string]*int{"10.0.0.1:80": nil, "10.0.0.2:80": nil, "10.0.0.3:80": &weight} {
+		got, _ := api.server("http", "backend-http", server)
+		if (got.Weight == nil) != (expected == nil) || (expected != nil && *got.Weight != *expected) {
+			t.Errorf("the HTTP server %v has weight %v, expected %v", server, got.Weight, expected)
+		}
+	}
+
+	cloud.mu.Lock()
+	cloud.groupErrs = map[string]error{"group-green": errors.New("the cloud provider is unavailable")}
+	cloud.mu.Unlock()
+	syncer.SetLookupOptions(LookupOptions{StalePolicy: stalePolicyKeep, StaleAfter: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+
+	results = syncer.SyncOnce(context.Background())
+	if results[0].Err == nil || results[0].Fallback != "" || results[0].Changed() {
+		t.Errorf("SyncOnce() returned %+v after the lookup of a scaling group failed without fallback, expected an error without changes", results[0])
+	}
+}
+
+func TestSyncOnceAddressFamily(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+
+	upstreams := getTestUpstreams()
+	upstreams[0].AddressFamily = addressFamilyDual
+	upstreams[1].AddressFamily = addressFamilyIPv6
+	cloud := &fakeCloudProvider{
+		instances: map[string][]Instance{
+			"group-http":   {{IP: "10.0.0.1", IPv6: "fd00::1"}, {IPv6: "fd00::2"}},
+			"group-stream": {{IP: "10.0.1.1", IPv6: "fd00:1::1"}, {IP: "10.0.1.2"}},
+		},
+		upstreams: upstreams,
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	for _, result := range syncer.SyncOnce(context.Background()) {
+		if result.Err != nil {
+			t.Fatalf("SyncOnce() returned an error for the upstream %v: %v", result.Upstream.Name, result.Err)
+		}
+	}
+
+	expected := []string{"10.0.0.1:80", "[fd00::1]:80", "[fd00::2]:80"}
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, expected) {
+		t.Errorf("the dual-stack HTTP upstream has servers %v after SyncOnce(), expected %v", got, expected)
+	}
+	expected = []string{"[fd00:1::1]:5432"}
+	if got := api.servers("stream", "backend-stream"); !slices.Equal(got, expected) {
+		t.Errorf("the IPv6 stream upstream has servers %v after SyncOnce(), expected %v", got, expected)
+	}
+}
+
+func TestSyncScalingGroups(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	results := syncer.SyncScalingGroups(context.Background(), []string{"group-http", "group-unknown"})
+	if len(results) != 1 || results[0].Upstream.Name != "backend-http" || results[0].Err != nil {
+		t.Errorf("SyncScalingGroups() returned %+v, expected a successful synchronization of the HTTP upstream", results)
+	}
+	if cloud.lookups != 1 {
+		t.Errorf("SyncScalingGroups() looked up %v scaling groups, expected 1", cloud.lookups)
+	}
+	if got := api.servers("stream", "backend-stream"); len(got) != 0 {
+		t.Errorf("SyncScalingGroups() changed the servers of the stream upstream to %v", got)
+	}
+}
+
+func TestSyncOnceMaxConcurrency(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	cloud := &fakeCloudProvider{
+		ips:   make(map[string][]string),
+		delay: 20 * time.Millisecond,
+	}
+	for i := range 6 {
+		name := fmt.Sprintf("backend-%d", i)
+		api.addUpstream("http", name)
+		cloud.ips[name] = []string{"10.0.0.1"}
+		cloud.upstreams = append(cloud.upstreams, Upstream{Name: name, ScalingGroups: []ScalingGroup{{Name: name}}, Kind: "http", Port: 80})
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+	syncer.SetMaxConcurrency(2)
+
+	results := syncer.SyncOnce(context.Background())
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("SyncOnce() returned an error for the upstream %v: %v", result.Upstream.Name, result.Err)
+		}
+		if result.Upstream.Name != cloud.upstreams[i].Name {
+			t.Errorf("SyncOnce() returned the result of %v at %v, expected the results in the order of the upstreams", result.Upstream.Name, i)
+		}
+	}
+
+	if cloud.maxInFlight != 2 {
+		t.Errorf("SyncOnce() synchronized up to %v upstreams at the same time, expected 2", cloud.maxInFlight)
+	}
+}
+
+func TestSyncOnceCanceled(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.9:80")
+	api.addUpstream("stream", "backend-stream")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+		delay:     time.Minute,
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	for _, result := range syncer.SyncOnce(ctx) {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("SyncOnce() returned the error %v for the upstream %v, expected context.Canceled", result.Err, result.Upstream.Name)
+		}
+	}
+
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.9:80"}) {
+		t.Errorf("SyncOnce() changed the servers of the HTTP upstream to %v after it was canceled", got)
+	}
+}
+
+func TestSyncOnceCloudProviderError(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80")
+	api.addUpstream("stream", "backend-stream", "10.0.1.1:5432")
+
+	cloud := &fakeCloudProvider{
+		err:       errors.New("throttled"),
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	for _, result := range syncer.SyncOnce(context.Background()) {
+		if result.Err == nil {
+			t.Errorf("SyncOnce() didn't return an error for the upstream %v", result.Upstream.Name)
+		}
+	}
+
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.1:80"}) {
+		t.Errorf("SyncOnce() changed the servers of the HTTP upstream to %v on a cloud provider error", got)
+	}
+}
+
+func TestSyncOnceLookupFallback(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg              string
+		expectedFallback string
+		expectedServers  []string
+		options          LookupOptions
+	}{
+		{
+			msg:              "the cache within stale_after",
+			options:          LookupOptions{StalePolicy: stalePolicyEmpty, StaleAfter: time.Hour},
+			expectedServers:  []string{"10.0.0.1:80"},
+			expectedFallback: fallbackCache,
+		},
+		{
+			msg:              "the cache without stale_after",
+			options:          LookupOptions{StalePolicy: stalePolicyEmpty},
+			expectedServers:  []string{"10.0.0.1:80"},
+			expectedFallback: fallbackCache,
+		},
+		{
+			msg:              "the empty policy after stale_after",
+			options:          LookupOptions{StalePolicy: stalePolicyEmpty, StaleAfter: time.Nanosecond},
+			expectedServers:  nil,
+			expectedFallback: fallbackEmpty,
+		},
+		{
+			msg:              "the keep policy after stale_after",
+			options:          LookupOptions{StalePolicy: stalePolicyKeep, StaleAfter: time.Nanosecond},
+			expectedServers:  []string{"10.0.0.1:80", "10.0.0.9:80"},
+			expectedFallback: "",
+		},
+	}
+
+	for _, test := range tests {
+		api := newFakeNginxPlusAPI()
+		api.addUpstream("http", "backend-http")
+
+		cloud := &fakeCloudProvider{
+			ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+			upstreams: getTestUpstreams()[:1],
+		}
+		syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+		syncer.SetLookupOptions(test.options)
+
+		if result := syncer.SyncOnce(context.Background())[0]; result.Err != nil {
+			t.Fatalf("SyncOnce() failed for %v: %v", test.msg, result.Err)
+		}
+
+		// a server added out of band shows whether the fallback synchronized the upstream
+		api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.9:80")
+		cloud.setErr(errors.New("throttled"))
+		time.Sleep(time.Millisecond)
+
+		result := syncer.SyncOnce(context.Background())[0]
+		if result.Err == nil {
+			t.Errorf("SyncOnce() didn't return the error of the lookup for %v", test.msg)
+		}
+		if result.Fallback != test.expectedFallback {
+			t.Errorf("SyncOnce() returned the fallback %q for %v, expected %q", result.Fallback, test.msg, test.expectedFallback)
+		}
+		if got := api.servers("http", "backend-http"); !slices.Equal(got, test.expectedServers) {
+			t.Errorf("SyncOnce() changed the servers to %v for %v, expected %v", got, test.msg, test.expectedServers)
+		}
+	}
+}
+
+func TestSyncOnceBacksOffLookups(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+
+	errThrottled := errors.New("throttled")
+	cloud := &fakeCloudProvider{
+		err:       errThrottled,
+		upstreams: getTestUpstreams()[:1],
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+	syncer.SetLookupOptions(LookupOptions{StalePolicy: stalePolicyKeep, BackoffBase: time.Hour, BackoffMax: time.Hour})
+
+	syncer.SyncOnce(context.Background())
+	result := syncer.SyncOnce(context.Background())[0]
+
+	if cloud.lookups != 1 {
+		t.Errorf("SyncOnce() looked up the scaling group %v times during the backoff, expected 1", cloud.lookups)
+	}
+	if !errors.Is(result.Err, errThrottled) {
+		t.Errorf("SyncOnce() returned the error %v during the backoff, expected the error of the last lookup", result.Err)
+	}
+}
+
+func TestSyncOnceLookupFallbackUpstreamOptions(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("http", "backend-http-empty")
+
+	staleAfter := time.Nanosecond
+	upstreams := getTestUpstreams()[:1]
+	empty := upstreams[0]
+	empty.Name = "backend-http-empty"
+	empty.StaleAfter = &staleAfter
+	empty.StalePolicy = stalePolicyEmpty
+	upstreams = append(upstreams, empty)
+
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+		upstreams: upstreams,
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+	syncer.SetLookupOptions(LookupOptions{StalePolicy: stalePolicyKeep, StaleAfter: time.Hour})
+	syncer.SyncOnce(context.Background())
+
+	cloud.setErr(errors.New("throttled"))
+	time.Sleep(time.Millisecond)
+
+	results := syncer.SyncOnce(context.Background())
+	if results[0].Fallback != fallbackCache {
+		t.Errorf("SyncOnce() returned the fallback %q for the upstream without stale options, expected %q", results[0].Fallback, fallbackCache)
+	}
+	if results[1].Fallback != fallbackEmpty {
+		t.Errorf("SyncOnce() returned the fallback %q for the upstream with stale options, expected %q", results[1].Fallback, fallbackEmpty)
+	}
+	if got := api.servers("http", "backend-http-empty"); len(got) != 0 {
+		t.Errorf("SyncOnce() kept the servers %v of the upstream with the empty stale_policy", got)
+	}
+}
+
+func TestGetLookupOptionsFirstBackoff(t *testing.T) {
+	t.Parallel()
+	cfg := &commonConfig{SyncInterval: 10 * time.Second}
+	options := getLookupOptions(cfg)
+
+	// the lookup after a single failure isn't delayed past the next regular synchronization
+	for range 100 {
+		if got := getLookupBackoff(options.BackoffBase, options.BackoffMax, 1); got >= cfg.SyncInterval {
+			t.Fatalf("getLookupBackoff() returned %v after the first failure, expected less than the sync interval %v", got, cfg.SyncInterval)
+		}
+	}
+}
+
+func TestGetLookupBackoff(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 1, expected: 10 * time.Second},
+		{failures: 2, expected: 20 * time.Second},
+		{failures: 3, expected: 40 * time.Second},
+		{failures: 5, expected: time.Minute},
+		{failures: 100, expected: time.Minute},
+	}
+
+	for _, test := range tests {
+		// the jitter is ±50% of the expected delay
+		got := getLookupBackoff(10*time.Second, time.Minute, test.failures)
+		if got < test.expected/2 || got >= test.expected*3/2 {
+			t.Errorf("getLookupBackoff() returned %v after %v failures, expected %v ±50%%", got, test.failures, test.expected)
+		}
+	}
+}
+
+func TestSyncOnceNginxError(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	results := syncer.SyncOnce(context.Background())
+	if results[0].Err != nil {
+		t.Errorf("SyncOnce() returned an error for the existing upstream: %v", results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("SyncOnce() didn't return an error for the upstream that doesn't exist in NGINX")
+	}
+}
+
+func TestCheckUpstreams(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	if err := syncer.CheckUpstreams(context.Background()); err == nil {
+		t.Error("CheckUpstreams() didn't fail for an upstream that doesn't exist in NGINX")
+	}
+
+	api.addUpstream("stream", "backend-stream")
+	if err := syncer.CheckUpstreams(context.Background()); err != nil {
+		t.Errorf("CheckUpstreams() failed for the existing upstreams: %v", err)
+	}
+}
+
+func TestCheckUpstreamsCloudProviderError(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+
+	cloud := &fakeCloudProvider{
+		err:       errors.New("throttled"),
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	if err := syncer.CheckUpstreams(context.Background()); err != nil {
+		t.Errorf("CheckUpstreams() failed on a cloud provider error: %v", err)
+	}
+}
+
+func TestSyncOnceMultipleEndpoints(t *testing.T) {
+	t.Parallel()
+	api1 := newFakeNginxPlusAPI()
+	api1.addUpstream("http", "backend-http", "10.0.0.9:80")
+	api1.addUpstream("stream", "backend-stream")
+	// the second instance lacks the stream upstream, which must not affect the other updates
+	api2 := newFakeNginxPlusAPI()
+	api2.addUpstream("http", "backend-http")
+
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api1, api2), NewMetrics(prometheus.NewRegistry()))
+
+	results := syncer.SyncOnce(context.Background())
+	if cloud.lookups != 2 {
+		t.Errorf("SyncOnce() looked up the scaling groups %v times, expected 2", cloud.lookups)
+	}
+	if len(results) != 4 {
+		t.Fatalf("SyncOnce() returned %v results, expected 4", len(results))
+	}
+
+	for _, result := range results {
+		failed := result.Upstream.Name == "backend-stream" && result.Endpoint == "nginx-2"
+		if (result.Err != nil) != failed {
+			t.Errorf("SyncOnce() returned the error %v for %v in %v", result.Err, result.Upstream.Name, result.Endpoint)
+		}
+	}
+
+	for _, api := range []*fakeNginxPlusAPI{api1, api2} {
+		if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.1:80"}) {
+			t.Errorf("the servers of backend-http are %v, expected [10.0.0.1:80]", got)
+		}
+	}
+	if got := api1.servers("stream", "backend-stream"); !slices.Equal(got, []string{"10.0.1.1:5432"}) {
+		t.Errorf("the servers of backend-stream are %v, expected [10.0.1.1:5432]", got)
+	}
+}
+
+func TestCheckUpstreamsMultipleEndpoints(t *testing.T) {
+	t.Parallel()
+	api1 := newFakeNginxPlusAPI()
+	api1.addUpstream("http", "backend-http")
+	api1.addUpstream("stream", "backend-stream")
+	api2 := newFakeNginxPlusAPI()
+
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{},
+		upstreams: getTestUpstreams(),
+	}
+
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api1, api2), NewMetrics(prometheus.NewRegistry()))
+	if err := syncer.CheckUpstreams(context.Background()); err != nil {
+		t.Errorf("CheckUpstreams() failed when the upstreams exist in one of the NGINX Plus instances: %v", err)
+	}
+
+	syncer = NewSyncer(cloud, newTestNginxEndpoints(t, api2, api2), NewMetrics(prometheus.NewRegistry()))
+	if err := syncer.CheckUpstreams(context.Background()); err == nil {
+		t.Error("CheckUpstreams() didn't fail when the upstreams don't exist in any NGINX Plus instance")
+	}
+}
+
+func TestSyncOnceDrainsRemovedServers(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.2:80")
+	api.setActiveConnections("10.0.0.2:80", 3)
+
+	upstream := getTestUpstreams()[0]
+	upstream.DrainTimeout = time.Hour
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+		upstreams: []Upstream{upstream},
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	for range 2 {
+		result := syncer.SyncOnce(context.Background())[0]
+		if result.Err != nil {
+			t.Fatalf("SyncOnce() failed: %v", result.Err)
+		}
+		if !slices.Equal(result.Draining, []string{"10.0.0.2:80"}) || len(result.Removed) > 0 {
+			t.Errorf("SyncOnce() returned draining %v and removed %v, expected [10.0.0.2:80] and []", result.Draining, result.Removed)
+		}
+		if server, ok := api.server("http", "backend-http", "10.0.0.2:80"); !ok || !server.Drain {
+			t.Errorf("the server 10.0.0.2:80 with active connections isn't in drain mode: %+v", server)
+		}
+	}
+
+	api.setActiveConnections("10.0.0.2:80", 0)
+	result := syncer.SyncOnce(context.Background())[0]
+	if !slices.Equal(result.Removed, []string{"10.0.0.2:80"}) || len(result.Draining) > 0 {
+		t.Errorf("SyncOnce() returned removed %v and draining %v, expected [10.0.0.2:80] and []", result.Removed, result.Draining)
+	}
+	if got := api.servers("http", "backend-http"); !slices.Equal(got, []string{"10.0.0.1:80"}) {
+		t.Errorf("the HTTP upstream has servers %v after draining, expected [10.0.0.1:80]", got)
+	}
+}
+
+func TestSyncOnceDrainTimeout(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.2:80")
+	api.setActiveConnections("10.0.0.2:80", 3)
+
+	upstream := getTestUpstreams()[0]
+	upstream.DrainTimeout = time.Nanosecond
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+		upstreams: []Upstream{upstream},
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	syncer.SyncOnce(context.Background())
+	result := syncer.SyncOnce(context.Background())[0]
+	if !slices.Equal(result.Removed, []string{"10.0.0.2:80"}) {
+		t.Errorf("SyncOnce() didn't remove the server with active connections after the drain timeout: %+v", result)
+	}
+}
+
+func TestSyncOnceStopsDrainingServersBackInScalingGroup(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.2:80")
+	api.setActiveConnections("10.0.0.2:80", 3)
+
+	upstream := getTestUpstreams()[0]
+	upstream.DrainTimeout = time.Hour
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+		upstreams: []Upstream{upstream},
+	}
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), NewMetrics(prometheus.NewRegistry()))
+
+	syncer.SyncOnce(context.Background())
+
+	cloud.ips["group-http"] = []string{"10.0.0.1", "10.0.0.2"}
+	result := syncer.SyncOnce(context.Background())[0]
+	if len(result.Draining) > 0 {
+		t.Errorf("SyncOnce() returned draining %v for servers back in the scaling group", result.Draining)
+	}
+	if server, ok := api.server("http", "backend-http", "10.0.0.2:80"); !ok || server.Drain {
+		t.Errorf("the server 10.0.0.2:80 back in the scaling group is still in drain mode: %+v", server)
+	}
+}
+
+func TestSyncOnceRefusesUpdateBelowMinServers(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("stream", "backend-stream", "10.0.1.1:5432", "10.0.1.2:5432", "10.0.1.3:5432")
+
+	upstream := getTestUpstreams()[1]
+	upstream.MinServers = 2
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-stream": {"10.0.1.1"}},
+		upstreams: []Upstream{upstream},
+	}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	syncer := NewSyncer(cloud, newTestNginxEndpoints(t, api), metrics)
+
+	result := syncer.SyncOnce(context.Background())[0]
+	if !errors.Is(result.Err, errUpdateRefused) {
+		t.Errorf("SyncOnce() returned the error %v, expected errUpdateRefused", result.Err)
+	}
+	expected := []string{"10.0.1.1:5432", "10.0.1.2:5432", "10.0.1.3:5432"}
+	if got := api.servers("stream", "backend-stream"); !slices.Equal(got, expected) {
+		t.Errorf("the servers of backend-stream are %v after a refused update, expected %v", got, expected)
+	}
+	if got := testutil.ToFloat64(metrics.syncRefusals.WithLabelValues("backend-stream", "nginx-1")); got != 1 {
+		t.Errorf("the sync refusals of backend-stream are %v, expected 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.syncErrors.WithLabelValues("backend-stream", "nginx-1")); got != 0 {
+		t.Errorf("the sync errors of backend-stream are %v, expected 0", got)
+	}
+}
+
+func TestCheckRemovalLimits(t *testing.T) {
+	t.Parallel()
+	current := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"}
+	tests := []struct {
+		msg               string
+		desired           []string
+		minServers        int
+		maxRemovalPercent int
+		refused           bool
+	}{
+		{
+			msg:     "no limits",
+			desired: nil,
+		},
+		{
+			msg:        "at min_servers",
+			desired:    []string{"10.0.0.1:80", "10.0.0.2:80"},
+			minServers: 2,
+		},
+		{
+			msg:        "below min_servers",
+			desired:    []string{"10.0.0.1:80"},
+			minServers: 2,
+			refused:    true,
+		},
+		{
+			msg:        "below min_servers but growing",
+			desired:    []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.5:80"},
+			minServers: 10,
+		},
+		{
+			msg:               "at max_removal_percent",
+			desired:           []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.5:80", "10.0.0.6:80"},
+			maxRemovalPercent: 50,
+		},
+		{
+			msg:               "above max_removal_percent",
+			desired:           []string{"10.0.0.1:80"},
+			maxRemovalPercent: 50,
+			refused:           true,
+		},
+	}
+
+	for _, test := range tests {
+		upstream := getTestUpstreams()[0]
+		upstream.MinServers = test.minServers
+		upstream.MaxRemovalPercent = test.maxRemovalPercent
+		endpoint := &nginxEndpoint{}
+
+		err := endpoint.checkRemovalLimits(upstream, current, test.desired)
+		if refused := errors.Is(err, errUpdateRefused); refused != test.refused {
+			t.Errorf("checkRemovalLimits() returned %v for the case of %v", err, test.msg)
+		}
+	}
+}
+
+func TestCheckRemovalLimitsIgnoresDrainingServers(t *testing.T) {
+	t.Parallel()
+	upstream := getTestUpstreams()[0]
+	upstream.MaxRemovalPercent = 50
+	endpoint := &nginxEndpoint{
+		draining: map[string]map[string]time.Time{
+			upstream.Name: {"10.0.0.3:80": time.Now(), "10.0.0.4:80": time.Now()},
+		},
+	}
+
+	err := endpoint.checkRemovalLimits(upstream, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"}, []string{"10.0.0.1:80"})
+	if err != nil {
+		t.Errorf("checkRemovalLimits() returned %v, expected the draining servers not to count as removed", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http", "10.0.0.1:80", "10.0.0.2:80")
+	api.addUpstream("stream", "backend-stream")
+	api.setActiveConnections("10.0.0.2:80", 1)
+	endpoints := newTestNginxEndpoints(t, api)
+
+	upstreams := getTestUpstreams()
+	upstreams[0].DrainTimeout = time.Minute
+	cloud := &fakeCloudProvider{
+		ips: map[string][]string{
+			"group-http":   {"10.0.0.1"},
+			"group-stream": {"10.0.1.1"},
+		},
+		upstreams: upstreams,
+	}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	syncer := NewSyncer(cloud, endpoints, metrics)
+	syncer.SyncOnce(context.Background())
+
+	reloaded := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1", "10.0.0.3"}},
+		upstreams: upstreams[:1],
+	}
+	err := syncer.Reload(context.Background(), reloaded, endpoints)
+	if err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v", err)
+	}
+
+	results := syncer.SyncOnce(context.Background())
+	if len(results) != 1 {
+		t.Fatalf("SyncOnce() synchronized %v upstreams after the reload, expected 1", len(results))
+	}
+	if !slices.Equal(results[0].Draining, []string{"10.0.0.2:80"}) {
+		t.Errorf("SyncOnce() didn't keep draining the server after the reload: %+v", results[0])
+	}
+	if got := testutil.CollectAndCount(metrics.servers); got != 1 {
+		t.Errorf("the servers are recorded for %v upstreams after the reload, expected 1", got)
+	}
+}
+
+func TestReloadDropsCacheOfMovedScalingGroups(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg              string
+		location         string
+		expectedFallback string
+	}{
+		{
+			msg:              "the same location",
+			location:         "us-east-1",
+			expectedFallback: fallbackCache,
+		},
+		{
+			msg:      "another location",
+			location: "eu-west-1",
+		},
+	}
+
+	for _, test := range tests {
+		api := newFakeNginxPlusAPI()
+		api.addUpstream("http", "backend-http")
+		endpoints := newTestNginxEndpoints(t, api)
+
+		cloud := &fakeCloudProvider{
+			ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+			upstreams: getTestUpstreams()[:1],
+			locations: map[string]string{"group-http": "us-east-1"},
+		}
+		syncer := NewSyncer(cloud, endpoints, NewMetrics(prometheus.NewRegistry()))
+		syncer.SyncOnce(context.Background())
+
+		reloaded := &fakeCloudProvider{
+			ips:       map[string][]string{"group-http": {"10.0.0.2"}},
+			upstreams: getTestUpstreams()[:1],
+			locations: map[string]string{"group-http": test.location},
+			err:       errors.New("throttled"),
+		}
+		err := syncer.Reload(context.Background(), reloaded, endpoints)
+		if err != nil {
+			t.Fatalf("Reload() returned an unexpected error for %v: %v", test.msg, err)
+		}
+
+		result := syncer.SyncOnce(context.Background())[0]
+		if result.Fallback != test.expectedFallback {
+			t.Errorf("SyncOnce() returned the fallback %q after the reload to %v, expected %q", result.Fallback, test.msg, test.expectedFallback)
+		}
+	}
+}
+
+func TestReloadKeepsPreviousConfigOnError(t *testing.T) {
+	t.Parallel()
+	api := newFakeNginxPlusAPI()
+	api.addUpstream("http", "backend-http")
+	api.addUpstream("stream", "backend-stream")
+	endpoints := newTestNginxEndpoints(t, api)
+
+	cloud := &fakeCloudProvider{
+		ips:       map[string][]string{"group-http": {"10.0.0.1"}},
+		upstreams: getTestUpstreams(),
+	}
+	syncer := NewSyncer(cloud, endpoints, NewMetrics(prometheus.NewRegistry()))
+
+	missing := getTestUpstreams()[0]
+	missing.Name = "missing"
+	reloaded := &fakeCloudProvider{upstreams: []Upstream{missing}}
+	err := syncer.Reload(context.Background(), reloaded, endpoints)
+	if err == nil {
+		t.Fatal("Reload() didn't return an error for an upstream missing in NGINX Plus")
+	}
+
+	results := syncer.SyncOnce(context.Background())
+	if len(results) != 2 {
+		t.Errorf("SyncOnce() synchronized %v upstreams after a failed reload, expected 2", len(results))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -31,3 +32,7 @@ func isValidTime(time string) bool {
 
 	return validNginxTime.MatchString(time)
 }
+
+func isValidAddressFamily(addressFamily string) bool {
+	return addressFamily == "" || slices.Contains([]string{addressFamilyIPv4, addressFamilyIPv6, addressFamilyDual}, addressFamily)
+}
@@ -20,3 +20,20 @@ func TestIsValidTime(t *testing.T) {
 		}
 	}
 }
+
+func TestIsValidAddressFamily(t *testing.T) {
+	t.Parallel()
+	validInput := []string{"", addressFamilyIPv4, addressFamilyIPv6, addressFamilyDual}
+	invalidInput := []string{"IPv4", "ipv5", "both"}
+
+	for _, test := range validInput {
+		if !isValidAddressFamily(test) {
+			t.Errorf("isValidAddressFamily(%q) returned false for valid input.", test)
+		}
+	}
+	for _, test := range invalidInput {
+		if isValidAddressFamily(test) {
+			t.Errorf("isValidAddressFamily(%q) returned true for invalid input.", test)
+		}
+	}
+}